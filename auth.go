@@ -0,0 +1,62 @@
+package clip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var (
+	ErrAuthRequired = errors.New("relay requires NIP-42 authentication, which isn't enabled for it")
+	ErrAuthFailed   = errors.New("NIP-42 authentication failed")
+)
+
+// WithAuthRelays answers the NIP-42 AUTH challenges of the given relays with the
+// nostr signer, when publishing and fetching. Other relays are never authenticated
+// to, so that the nostr pubkey isn't revealed to them.
+func WithAuthRelays(urls ...string) ClientOption {
+	return func(c *Client) {
+		for _, u := range urls {
+			c.authRelays[nostr.NormalizeURL(u)] = struct{}{}
+		}
+	}
+}
+
+// authEnabled reports whether AUTH challenges of the relay are answered.
+func (c *Client) authEnabled(relayURL string) bool {
+	_, ok := c.authRelays[nostr.NormalizeURL(relayURL)]
+	return ok
+}
+
+// signAuth signs the AUTH event of a relay. It is also used by the pool, which
+// authenticates on its own when a subscription is closed with "auth-required:".
+func (c *Client) signAuth(ctx context.Context, ie nostr.RelayEvent) error {
+	if !c.authEnabled(ie.Relay.URL) {
+		return ErrAuthRequired
+	}
+	return c.nostrSigner.SignEvent(ctx, ie.Event)
+}
+
+// authenticate answers the last AUTH challenge of the relay after it has asked for
+// authentication with reason.
+func (c *Client) authenticate(ctx context.Context, relay *nostr.Relay, reason string) error {
+	if !c.authEnabled(relay.URL) {
+		return fmt.Errorf("%w (%s)", ErrAuthRequired, reason)
+	}
+	err := relay.Auth(ctx, func(ev *nostr.Event) error {
+		return c.signAuth(ctx, nostr.RelayEvent{Event: ev, Relay: relay})
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrAuthFailed, err)
+	}
+	return nil
+}
+
+// isAuthRequired reports whether a rejection or a CLOSED reason of a relay asks for
+// authentication (NIP-42).
+func isAuthRequired(reason string) bool {
+	return strings.HasPrefix(strings.TrimPrefix(reason, "msg: "), "auth-required:")
+}
@@ -0,0 +1,80 @@
+package clip
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// newAuthTest returns a relay which requires AUTH for reading and writing, and
+// node info to publish to it.
+func newAuthTest(t *testing.T, npub testNpub, node *testNode) (*testRelay, *nostr.Event) {
+	t.Helper()
+	relay := newTestRelay(t)
+	relay.requireAuth = true
+	created := nostr.Now() - 3600
+	relay.add(newTestEvent(t, npub, node, KindNodeAnnouncement, created))
+	return relay, newTestEvent(t, npub, node, KindNodeInfo, created)
+}
+
+func TestAuthPublishAndFetch(t *testing.T) {
+	node, npub := newTestNode(t), newTestNpub(t)
+	relay, info := newAuthTest(t, npub, node)
+	c := newTestClient(t, npub, node, WithAuthRelays(relay.url))
+	ctx := withTimeout(t)
+
+	report := c.publishEvent(ctx, info, []string{relay.url}).Wait()
+	if err := report.Err(); err != nil {
+		t.Fatalf("publishing: %v %+v", err, report.Status)
+	}
+	if !slices.ContainsFunc(relay.stored(), func(ev *nostr.Event) bool { return ev.ID == info.ID }) {
+		t.Fatal("event not stored by the relay")
+	}
+
+	events, err, fetchErrors := c.GetEvents(ctx, KindNodeInfo, nil, []string{relay.url}, time.Unix(0, 0))
+	if err != nil || len(fetchErrors) > 0 {
+		t.Fatalf("fetching: %v %v", err, fetchErrors)
+	}
+	if len(events) != 1 || events[0].NostrEvent.ID != info.ID {
+		t.Fatalf("expected the published node info, got %v", events)
+	}
+	pks := relay.authenticated()
+	if len(pks) == 0 {
+		t.Fatal("not authenticated")
+	}
+	for _, pk := range pks {
+		if pk != npub.pk {
+			t.Fatalf("authenticated as %s instead of %s", pk, npub.pk)
+		}
+	}
+}
+
+// Relays which aren't enabled for AUTH never learn the npub.
+func TestAuthNotEnabled(t *testing.T) {
+	node, npub := newTestNode(t), newTestNpub(t)
+	relay, info := newAuthTest(t, npub, node)
+	c := newTestClient(t, npub, node, WithAuthRelays("wss://other.example.com"))
+	ctx := withTimeout(t)
+
+	report := c.publishEvent(ctx, info, []string{relay.url}).Wait()
+	if report.QuorumMet() || len(report.Status) != 1 ||
+		!strings.Contains(report.Status[0].Error, ErrAuthRequired.Error()) {
+		t.Fatalf("expected publishing to fail with %v, got %+v", ErrAuthRequired, report.Status)
+	}
+
+	_, err, fetchErrors := c.GetEvents(ctx, KindNodeInfo, nil, []string{relay.url}, time.Unix(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.ContainsFunc(fetchErrors, func(err error) bool {
+		return strings.Contains(err.Error(), ErrAuthRequired.Error())
+	}) {
+		t.Fatalf("expected a fetch error about %v, got %v", ErrAuthRequired, fetchErrors)
+	}
+	if pks := relay.authenticated(); len(pks) > 0 {
+		t.Fatalf("authenticated to a relay which isn't enabled: %v", pks)
+	}
+}
@@ -0,0 +1,890 @@
+package clip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// Bucket with the raw nostr events of the candidates of the MapStore, key is the
+	// nostr ID. Replaced, dropped and evicted events are removed.
+	bucketEvents = []byte("events")
+
+	// Index of the events bucket by kind and 'd' tag. The values are empty.
+	bucketEventsByKind = []byte("events_kind")
+
+	// Last time an event of a node has been received, key is the node pubkey. The
+	// values are 8-byte big-endian unix times.
+	bucketSeen = []byte("seen")
+
+	// Bucket with the sync points, key is relay and filter key.
+	bucketSync = []byte("sync")
+
+	// Every verified version of the events by node, kind and created_at. The values
+	// are the raw nostr events.
+	bucketHistory = []byte("history")
+
+	// Bucket with the rejected events, key is the nostr ID.
+	bucketRejected = []byte("rejected")
+
+	// Index of the rejected events by the time of the rejection. The values are empty.
+	bucketRejectedTime = []byte("rejected_time")
+
+	// Bucket with the health of the relays, key is the relay URL.
+	bucketRelays = []byte("relays")
+
+	// Bucket with the counters, e.g. of the dropped events, also of earlier processes.
+	// The values are 8-byte big-endian numbers.
+	bucketCounters = []byte("counters")
+)
+
+// Maximum time to wait for another process holding the database
+var boltLockTimeout = 5 * time.Second
+
+const (
+	// Maximum number of rejected events kept in the database. The oldest rejections
+	// are removed first.
+	MaxRejectedEvents = 10000
+
+	// Rejections older than this are removed
+	rejectedTTL = 30 * 24 * time.Hour
+
+	// Changes are written in batches of this size, or when the oldest pending one has
+	// waited for writeFlushInterval. The receive times don't count, they are only
+	// written with the other changes.
+	writeBatchSize     = 1000
+	writeFlushInterval = 10 * time.Second
+
+	// Keys of the counters
+	counterDropped       = "dropped:"
+	counterEvictedNodes  = "evicted_nodes:"
+	counterEvictedEvents = "evicted_events"
+)
+
+// BoltStore is a persistent store backed by bbolt. The candidates of the MapStore are
+// kept in the database, and stored again in the MapStore when opening it, so that the
+// announcement state is derived by the same binding rules. Every verified event is
+// also kept in the history, also the ones which are stale or have been replaced.
+// Events of evicted nodes are removed with their history.
+//
+// The changes are written in batches, and together with the next sync point, so that
+// a sync point never covers events which haven't been written. The database is kept
+// open until Close, so only one process can use it at a time. Other processes wait
+// up to 5 seconds for it and fail then.
+type BoltStore struct {
+	*MapStore
+
+	db   *bolt.DB
+	path string
+
+	// Held while writing, so that the changes are written in order
+	writeMu sync.Mutex
+	closed  bool
+
+	// Changes which haven't been written yet, the relay health by URL and the receive
+	// times by node
+	pendingMu       sync.Mutex
+	pendingOps      []func(tx *bolt.Tx) error
+	pendingRejected map[string]*RejectedEvent
+	pendingRelays   map[string][]byte
+	pendingSeen     map[string]nostr.Timestamp
+	pendingSince    time.Time
+
+	// Set while the events of the database are stored in the MapStore
+	loading bool
+
+	// Counters which haven't been written yet. The evictions of the MapStore are
+	// written as the difference to the ones already written.
+	countersMu           sync.Mutex
+	dropped              map[DropReason]uint64
+	writtenEvicted       map[EvictReason]uint64
+	writtenEvictedEvents uint64
+}
+
+// OpenBoltStore opens or creates the database at path and loads all events. The
+// options are applied to the in-memory MapStore.
+func OpenBoltStore(path string, opts ...MapStoreOption) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: boltLockTimeout})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("opening database %s: used by another process", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening database %s: %w", path, err)
+	}
+
+	s := &BoltStore{
+		MapStore:        NewMapStore(opts...),
+		db:              db,
+		path:            path,
+		pendingRejected: make(map[string]*RejectedEvent),
+		pendingRelays:   make(map[string][]byte),
+		pendingSeen:     make(map[string]nostr.Timestamp),
+		dropped:         make(map[DropReason]uint64),
+
+		writtenEvicted: make(map[EvictReason]uint64),
+	}
+	s.MapStore.journal = s
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{bucketEvents, bucketEventsByKind, bucketSeen, bucketSync,
+			bucketHistory, bucketRejected, bucketRejectedTime, bucketRelays, bucketCounters} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return fmt.Errorf("creating buckets: %w", err)
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		err = s.load()
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// load stores all events of the database in the MapStore, with the time they have
+// been received. Events which aren't candidates anymore are removed. Missing entries of
+// the history and of the indexes are added for databases of older versions, which
+// kept all versions in the events bucket. For these, created_at is taken as the time
+// the events have been received.
+func (s *BoltStore) load() error {
+	var events []*Event
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		history, byKind := tx.Bucket(bucketHistory), tx.Bucket(bucketEventsByKind)
+		seen := tx.Bucket(bucketSeen)
+		err := tx.Bucket(bucketEvents).ForEach(func(k, v []byte) error {
+			ev, err := parseEvent(v)
+			if err != nil {
+				return fmt.Errorf("parsing event %s: %w", k, err)
+			}
+			events = append(events, ev)
+
+			ev.received = ev.NostrEvent.CreatedAt
+			if id, err := ev.GetIdentifier(); err == nil {
+				if t := seen.Get([]byte(id.PubKey)); len(t) == 8 {
+					ev.received = nostr.Timestamp(binary.BigEndian.Uint64(t))
+				}
+			}
+
+			hk, err := historyKey(ev)
+			if err != nil {
+				return err
+			}
+			if len(history.Get(hk)) == 0 {
+				if err := history.Put(hk, v); err != nil {
+					return err
+				}
+			}
+			kk, err := kindKey(ev)
+			if err != nil || byKind.Get(kk) != nil {
+				return err
+			}
+			return byKind.Put(kk, nil)
+		})
+		if err != nil {
+			return err
+		}
+
+		byTime := tx.Bucket(bucketRejectedTime)
+		return tx.Bucket(bucketRejected).ForEach(func(k, v []byte) error {
+			var r RejectedEvent
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("parsing rejected event %s: %w", k, err)
+			}
+			return byTime.Put(rejectedTimeKey(r.RejectedAt, string(k)), nil)
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("loading events: %w", err)
+	}
+
+	// The events are in the database already, only the removals are written.
+	s.loading = true
+	for _, ev := range events {
+		_, _ = s.MapStore.StoreEvent(ev)
+	}
+	s.loading = false
+
+	if err := s.flush(nil); err != nil {
+		return fmt.Errorf("loading events: %w", err)
+	}
+	return nil
+}
+
+// StoreEvent stores the event in the MapStore. The changes are written with the next
+// batch. The event has to be verified before.
+func (s *BoltStore) StoreEvent(ev *Event) (StoreResult, error) {
+	res, err := s.MapStore.StoreEvent(ev)
+	if ferr := s.flushIfDue(); ferr != nil {
+		return res, ferr
+	}
+	return res, err
+}
+
+// addOp adds a change to the next batch. The events are marshaled right away, so that
+// the errors are returned by the write.
+func (s *BoltStore) addOp(op func(tx *bolt.Tx) error) {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	if s.pendingSince.IsZero() {
+		s.pendingSince = time.Now()
+	}
+	s.pendingOps = append(s.pendingOps, op)
+}
+
+// stored writes the event to the events bucket and to the history.
+func (s *BoltStore) stored(ev *Event) {
+	if s.loading {
+		return
+	}
+	hk, hkErr := historyKey(ev)
+	kk, kkErr := kindKey(ev)
+	b, err := json.Marshal(ev.NostrEvent)
+	id := ev.NostrEvent.ID
+
+	s.addOp(func(tx *bolt.Tx) error {
+		if err := errors.Join(hkErr, kkErr, err); err != nil {
+			return fmt.Errorf("writing event %s: %w", id, err)
+		}
+		if err := tx.Bucket(bucketEvents).Put([]byte(id), b); err != nil {
+			return err
+		}
+		if err := tx.Bucket(bucketEventsByKind).Put(kk, nil); err != nil {
+			return err
+		}
+		// An earlier rejection of the event, e.g. of a failed check, is outdated.
+		if err := deleteRejected(tx, id); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketHistory).Put(hk, b)
+	})
+}
+
+// superseded keeps the event only in the history.
+func (s *BoltStore) superseded(ev *Event) {
+	hk, hkErr := historyKey(ev)
+	b, err := json.Marshal(ev.NostrEvent)
+
+	s.addOp(func(tx *bolt.Tx) error {
+		if err := errors.Join(hkErr, err); err != nil {
+			return fmt.Errorf("writing event %s: %w", ev.NostrEvent.ID, err)
+		}
+		if err := deleteEvent(tx, ev); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketHistory).Put(hk, b)
+	})
+}
+
+// removed removes the event from the database, also from the history.
+func (s *BoltStore) removed(ev *Event) {
+	s.addOp(func(tx *bolt.Tx) error {
+		if err := deleteEvent(tx, ev); err != nil {
+			return err
+		}
+		hk, err := historyKey(ev)
+		if err != nil {
+			return nil
+		}
+		return tx.Bucket(bucketHistory).Delete(hk)
+	})
+}
+
+// evicted removes the events and the history of the node from the database.
+func (s *BoltStore) evicted(pubKey string, events []*Event) {
+	s.pendingMu.Lock()
+	delete(s.pendingSeen, pubKey)
+	s.pendingMu.Unlock()
+
+	s.addOp(func(tx *bolt.Tx) error {
+		for _, ev := range events {
+			if err := deleteEvent(tx, ev); err != nil {
+				return err
+			}
+		}
+		if err := tx.Bucket(bucketSeen).Delete([]byte(pubKey)); err != nil {
+			return err
+		}
+		prefix := []byte(pubKey + "\x00")
+		history := tx.Bucket(bucketHistory)
+		var keys [][]byte
+		c := history.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			keys = append(keys, bytes.Clone(k))
+		}
+		for _, k := range keys {
+			if err := history.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// seen keeps the last time an event of the node has been received. Only the latest
+// time of a node is written.
+func (s *BoltStore) seen(pubKey string, t nostr.Timestamp) {
+	if s.loading {
+		return
+	}
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	if s.pendingSince.IsZero() {
+		s.pendingSince = time.Now()
+	}
+	s.pendingSeen[pubKey] = t
+}
+
+// deleteEvent removes the event from the events bucket and its index.
+func deleteEvent(tx *bolt.Tx, ev *Event) error {
+	if err := tx.Bucket(bucketEvents).Delete([]byte(ev.NostrEvent.ID)); err != nil {
+		return err
+	}
+	kk, err := kindKey(ev)
+	if err != nil {
+		return nil
+	}
+	return tx.Bucket(bucketEventsByKind).Delete(kk)
+}
+
+// flushIfDue writes the pending changes if there are writeBatchSize of them, or if
+// the oldest one has waited for writeFlushInterval.
+func (s *BoltStore) flushIfDue() error {
+	s.pendingMu.Lock()
+	n := len(s.pendingOps) + len(s.pendingRejected) + len(s.pendingRelays)
+	due := n >= writeBatchSize ||
+		(!s.pendingSince.IsZero() && time.Since(s.pendingSince) >= writeFlushInterval)
+	s.pendingMu.Unlock()
+
+	if !due {
+		return nil
+	}
+	return s.flush(nil)
+}
+
+// flush writes the pending changes and counters in one transaction, followed by fn
+// if it isn't nil.
+func (s *BoltStore) flush(fn func(tx *bolt.Tx) error) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if s.closed {
+		return bolt.ErrDatabaseNotOpen
+	}
+
+	s.pendingMu.Lock()
+	ops, rejected, relays, seen := s.pendingOps, s.pendingRejected, s.pendingRelays, s.pendingSeen
+	s.pendingOps, s.pendingRejected = nil, make(map[string]*RejectedEvent)
+	s.pendingRelays, s.pendingSeen = make(map[string][]byte), make(map[string]nostr.Timestamp)
+	s.pendingSince = time.Time{}
+	s.pendingMu.Unlock()
+
+	s.countersMu.Lock()
+	defer s.countersMu.Unlock()
+	stats := s.MapStore.Stats()
+	counters := s.pendingCounters(stats)
+
+	if len(ops) == 0 && len(rejected) == 0 && len(relays) == 0 && len(seen) == 0 &&
+		len(counters) == 0 && fn == nil {
+		return nil
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for _, op := range ops {
+			if err := op(tx); err != nil {
+				return err
+			}
+		}
+		if err := writeRejected(tx, rejected); err != nil {
+			return err
+		}
+		for url, b := range relays {
+			if err := tx.Bucket(bucketRelays).Put([]byte(url), b); err != nil {
+				return err
+			}
+		}
+		for pubKey, t := range seen {
+			v := binary.BigEndian.AppendUint64(nil, uint64(t))
+			if err := tx.Bucket(bucketSeen).Put([]byte(pubKey), v); err != nil {
+				return err
+			}
+		}
+		if err := writeCounters(tx, counters); err != nil {
+			return fmt.Errorf("writing counters: %w", err)
+		}
+		if fn != nil {
+			return fn(tx)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	clear(s.dropped)
+	maps.Copy(s.writtenEvicted, stats.EvictedNodes)
+	s.writtenEvictedEvents = stats.EvictedEvents
+	return nil
+}
+
+// GetHistory returns all versions of the events of a kind of a node, which have been
+// stored, sorted by created_at.
+func (s *BoltStore) GetHistory(kind Kind, pubKey string) ([]*Event, error) {
+	if err := s.flush(nil); err != nil {
+		return nil, err
+	}
+	prefix := historyPrefix(kind, pubKey)
+
+	var events []*Event
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketHistory).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			id := k[len(prefix)+8:]
+			ev, err := parseEvent(v)
+			if err != nil {
+				return fmt.Errorf("parsing event %s: %w", id, err)
+			}
+			events = append(events, ev)
+		}
+		return nil
+	})
+	return events, err
+}
+
+// GetRawEvents returns the candidates in the database matching the filter, also the
+// ones of npubs which aren't bound. If the filter has a 'k' tag, only the index of
+// these kinds and the 'd' tags of the filter is read.
+func (s *BoltStore) GetRawEvents(filter nostr.Filter) []*nostr.Event {
+	_ = s.flush(nil)
+
+	var events []*nostr.Event
+	add := func(v []byte) {
+		var ev nostr.Event
+		if err := json.Unmarshal(v, &ev); err == nil && filter.Matches(&ev) {
+			events = append(events, &ev)
+		}
+	}
+	_ = s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketEvents)
+		if len(filter.Tags["k"]) == 0 {
+			return bucket.ForEach(func(k, v []byte) error {
+				add(v)
+				return nil
+			})
+		}
+
+		var prefixes [][]byte
+		for _, k := range filter.Tags["k"] {
+			if len(filter.Tags["d"]) == 0 {
+				prefixes = append(prefixes, []byte(k+"\x00"))
+			}
+			for _, d := range filter.Tags["d"] {
+				prefixes = append(prefixes, []byte(k+"\x00"+d+"\x00"))
+			}
+		}
+		c := tx.Bucket(bucketEventsByKind).Cursor()
+		for _, prefix := range prefixes {
+			for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+				id := k[bytes.LastIndexByte(k, 0)+1:]
+				if v := bucket.Get(id); v != nil {
+					add(v)
+				}
+			}
+		}
+		return nil
+	})
+	return events
+}
+
+// StoreRejected keeps the last rejection of an event. The rejections are written in
+// batches, so that a flood of invalid events doesn't cause a write per event.
+func (s *BoltStore) StoreRejected(r *RejectedEvent) error {
+	s.pendingMu.Lock()
+	if s.pendingSince.IsZero() {
+		s.pendingSince = time.Now()
+	}
+	s.pendingRejected[r.EventID] = r
+	s.pendingMu.Unlock()
+
+	return s.flushIfDue()
+}
+
+// writeRejected writes the rejections and removes the expired ones and the oldest
+// ones above MaxRejectedEvents.
+func writeRejected(tx *bolt.Tx, pending map[string]*RejectedEvent) error {
+	if len(pending) == 0 {
+		return nil
+	}
+
+	rejected, byTime := tx.Bucket(bucketRejected), tx.Bucket(bucketRejectedTime)
+	for id, r := range pending {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("marshaling rejected event: %w", err)
+		}
+		if err := deleteRejected(tx, id); err != nil {
+			return err
+		}
+		if err := rejected.Put([]byte(id), b); err != nil {
+			return err
+		}
+		if err := byTime.Put(rejectedTimeKey(r.RejectedAt, id), nil); err != nil {
+			return err
+		}
+	}
+
+	// Bucket.Stats doesn't count the keys written in this transaction.
+	var count int
+	c := byTime.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		count++
+	}
+	excess := count - MaxRejectedEvents
+	expiry := rejectedTimeKey(nostr.Timestamp(time.Now().Add(-rejectedTTL).Unix()), "")
+	var remove [][]byte
+	for k, _ := c.First(); k != nil && (excess > 0 || bytes.Compare(k, expiry) < 0); k, _ = c.Next() {
+		remove = append(remove, bytes.Clone(k))
+		excess--
+	}
+	for _, k := range remove {
+		if err := rejected.Delete(k[8:]); err != nil {
+			return err
+		}
+		if err := byTime.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteRejected removes the rejection of the event and its index entry.
+func deleteRejected(tx *bolt.Tx, id string) error {
+	rejected := tx.Bucket(bucketRejected)
+	v := rejected.Get([]byte(id))
+	if v == nil {
+		return nil
+	}
+	var r RejectedEvent
+	if err := json.Unmarshal(v, &r); err == nil {
+		if err := tx.Bucket(bucketRejectedTime).Delete(rejectedTimeKey(r.RejectedAt, id)); err != nil {
+			return err
+		}
+	}
+	return rejected.Delete([]byte(id))
+}
+
+func rejectedTimeKey(t nostr.Timestamp, id string) []byte {
+	return append(binary.BigEndian.AppendUint64(nil, uint64(t)), id...)
+}
+
+// GetRejected returns the rejected events. Rejections of events which have been
+// accepted since, e.g. after the announcement of their npub has arrived, are removed.
+func (s *BoltStore) GetRejected() ([]*RejectedEvent, error) {
+	if err := s.flush(nil); err != nil {
+		return nil, err
+	}
+
+	var all []*RejectedEvent
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketRejected).ForEach(func(k, v []byte) error {
+			var r RejectedEvent
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("parsing rejected event %s: %w", k, err)
+			}
+			all = append(all, &r)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	accepted := make(map[string]map[string]struct{})
+	var res []*RejectedEvent
+	var outdated []string
+	for _, r := range all {
+		if accepted[r.PubKey] == nil {
+			accepted[r.PubKey] = make(map[string]struct{})
+			for _, kind := range []Kind{KindNodeAnnouncement, KindNodeInfo} {
+				for _, ev := range s.MapStore.GetEvents(kind, map[string]struct{}{r.PubKey: {}}) {
+					accepted[r.PubKey][ev.NostrEvent.ID] = struct{}{}
+				}
+			}
+		}
+		if _, ok := accepted[r.PubKey][r.EventID]; ok {
+			outdated = append(outdated, r.EventID)
+			continue
+		}
+		res = append(res, r)
+	}
+
+	if len(outdated) > 0 {
+		err = s.flush(func(tx *bolt.Tx) error {
+			for _, id := range outdated {
+				if err := deleteRejected(tx, id); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	return res, err
+}
+
+// SetRelayHealth stores the health of a relay. Like the rejections, the health is
+// written in batches, only the last record of a relay.
+func (s *BoltStore) SetRelayHealth(h *RelayHealth) error {
+	b, err := json.Marshal(h)
+	if err != nil {
+		return fmt.Errorf("marshaling relay health: %w", err)
+	}
+
+	s.pendingMu.Lock()
+	if s.pendingSince.IsZero() {
+		s.pendingSince = time.Now()
+	}
+	s.pendingRelays[h.URL] = b
+	s.pendingMu.Unlock()
+
+	return s.flushIfDue()
+}
+
+func (s *BoltStore) GetRelayHealth() ([]*RelayHealth, error) {
+	if err := s.flush(nil); err != nil {
+		return nil, err
+	}
+	var res []*RelayHealth
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketRelays).ForEach(func(k, v []byte) error {
+			var h RelayHealth
+			if err := json.Unmarshal(v, &h); err != nil {
+				return fmt.Errorf("parsing health of relay %s: %w", k, err)
+			}
+			res = append(res, &h)
+			return nil
+		})
+	})
+	return res, err
+}
+
+func parseEvent(b []byte) (*Event, error) {
+	var nev nostr.Event
+	if err := json.Unmarshal(b, &nev); err != nil {
+		return nil, err
+	}
+	return NewEventFromNostrRelay(&nev)
+}
+
+func historyPrefix(kind Kind, pubKey string) []byte {
+	return []byte(pubKey + "\x00" + strconv.Itoa(int(kind)) + "\x00")
+}
+
+// historyKey returns the key of the event in the history: node pubkey, kind,
+// created_at and nostr ID.
+func historyKey(ev *Event) ([]byte, error) {
+	id, err := ev.GetIdentifier()
+	if err != nil {
+		return nil, err
+	}
+	k := historyPrefix(id.Kind, id.PubKey)
+	k = binary.BigEndian.AppendUint64(k, uint64(ev.NostrEvent.CreatedAt))
+	return append(k, ev.NostrEvent.ID...), nil
+}
+
+// kindKey returns the key of the event in the index by kind: kind, 'd' tag and nostr
+// ID.
+func kindKey(ev *Event) ([]byte, error) {
+	id, err := ev.GetIdentifier()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strconv.Itoa(int(id.Kind)) + "\x00" + id.TagD + "\x00" + ev.NostrEvent.ID), nil
+}
+
+func syncPointKey(relay string, key string) []byte {
+	return []byte(relay + "\x00" + key)
+}
+
+func (s *BoltStore) GetSyncPoint(relay string, key string) (SyncPoint, bool) {
+	var (
+		sp SyncPoint
+		ok bool
+	)
+	_ = s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucketSync).Get(syncPointKey(relay, key))
+		if len(v) != 16 {
+			return nil
+		}
+		sp.From = nostr.Timestamp(binary.BigEndian.Uint64(v[:8]))
+		sp.Until = nostr.Timestamp(binary.BigEndian.Uint64(v[8:]))
+		ok = true
+		return nil
+	})
+	return sp, ok
+}
+
+// SetSyncPoint writes the sync point together with the pending changes, so that the
+// events of the sync are written in the same transaction.
+func (s *BoltStore) SetSyncPoint(relay string, key string, sp SyncPoint) error {
+	v := make([]byte, 16)
+	binary.BigEndian.PutUint64(v[:8], uint64(sp.From))
+	binary.BigEndian.PutUint64(v[8:], uint64(sp.Until))
+	return s.flush(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSync).Put(syncPointKey(relay, key), v)
+	})
+}
+
+// AddDropped counts an event dropped because of the ingest limits. The counts are
+// written with the next batch.
+func (s *BoltStore) AddDropped(reason DropReason) error {
+	s.countersMu.Lock()
+	s.dropped[reason]++
+	s.countersMu.Unlock()
+
+	s.pendingMu.Lock()
+	if s.pendingSince.IsZero() {
+		s.pendingSince = time.Now()
+	}
+	s.pendingMu.Unlock()
+
+	return s.flushIfDue()
+}
+
+// GetDropped returns the numbers of dropped events, also of earlier processes.
+func (s *BoltStore) GetDropped() (map[DropReason]uint64, error) {
+	counters, err := s.readCounters(counterDropped)
+	if err != nil {
+		return nil, err
+	}
+
+	dropped := make(map[DropReason]uint64, len(counters))
+	s.countersMu.Lock()
+	defer s.countersMu.Unlock()
+	for k, n := range counters {
+		dropped[DropReason(k)] = n
+	}
+	for r, n := range s.dropped {
+		dropped[r] += n
+	}
+	return dropped, nil
+}
+
+// Stats is like MapStore.Stats, but the evictions are counted over all processes
+// which have used the database.
+func (s *BoltStore) Stats() StoreStats {
+	stats := s.MapStore.Stats()
+	nodes, err := s.readCounters(counterEvictedNodes)
+	if err != nil {
+		return stats
+	}
+	events, err := s.readCounters(counterEvictedEvents)
+	if err != nil {
+		return stats
+	}
+
+	s.countersMu.Lock()
+	defer s.countersMu.Unlock()
+	for r, n := range stats.EvictedNodes {
+		stats.EvictedNodes[r] = n - s.writtenEvicted[r]
+	}
+	stats.EvictedEvents -= s.writtenEvictedEvents
+	for k, n := range nodes {
+		stats.EvictedNodes[EvictReason(k)] += n
+	}
+	stats.EvictedEvents += events[""]
+	return stats
+}
+
+// Evict is like MapStore.Evict and writes the removals and counters afterwards.
+func (s *BoltStore) Evict() {
+	s.MapStore.Evict()
+	_ = s.flush(nil)
+}
+
+// pendingCounters returns the dropped events and the evictions since the last write.
+// The caller has to hold countersMu.
+func (s *BoltStore) pendingCounters(stats StoreStats) map[string]uint64 {
+	add := make(map[string]uint64)
+	for r, n := range s.dropped {
+		add[counterDropped+string(r)] += n
+	}
+	for r, n := range stats.EvictedNodes {
+		if d := n - s.writtenEvicted[r]; d > 0 {
+			add[counterEvictedNodes+string(r)] += d
+		}
+	}
+	if d := stats.EvictedEvents - s.writtenEvictedEvents; d > 0 {
+		add[counterEvictedEvents] += d
+	}
+	return add
+}
+
+// writeCounters adds the numbers to the counters in the database.
+func writeCounters(tx *bolt.Tx, add map[string]uint64) error {
+	counters := tx.Bucket(bucketCounters)
+	for k, n := range add {
+		if v := counters.Get([]byte(k)); len(v) == 8 {
+			n += binary.BigEndian.Uint64(v)
+		}
+		if err := counters.Put([]byte(k), binary.BigEndian.AppendUint64(nil, n)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readCounters returns the counters in the database with the prefix, keyed by the
+// rest of their key.
+func (s *BoltStore) readCounters(prefix string) (map[string]uint64, error) {
+	counters := make(map[string]uint64)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketCounters).Cursor()
+		for k, v := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, v = c.Next() {
+			if len(v) == 8 {
+				counters[string(k[len(prefix):])] = binary.BigEndian.Uint64(v)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading counters: %w", err)
+	}
+	return counters, nil
+}
+
+// Flush writes the pending changes and counters. Long-running processes should call
+// it periodically, so that they aren't lost if the process is killed.
+func (s *BoltStore) Flush() error {
+	return s.flush(nil)
+}
+
+// Close writes the pending changes and closes the database.
+func (s *BoltStore) Close() error {
+	err := s.flush(nil)
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return errors.Join(err, s.db.Close())
+}
+
+var (
+	_ Store          = (*BoltStore)(nil)
+	_ SyncState      = (*BoltStore)(nil)
+	_ HistoryStore   = (*BoltStore)(nil)
+	_ RejectionStore = (*BoltStore)(nil)
+	_ DropStore      = (*BoltStore)(nil)
+)
@@ -0,0 +1,190 @@
+package clip
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	bolt "go.etcd.io/bbolt"
+)
+
+// countKeys returns the number of keys in a bucket of a closed database.
+func countKeys(t *testing.T, path string, bucket []byte) int {
+	t.Helper()
+	db, err := bolt.Open(path, 0o600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var n int
+	db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+			n++
+			return nil
+		})
+	})
+	return n
+}
+
+// The database is held by one process, others fail after the timeout.
+func TestBoltStoreSingleOwner(t *testing.T) {
+	timeout := boltLockTimeout
+	boltLockTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { boltLockTimeout = timeout })
+
+	path := filepath.Join(t.TempDir(), "clip.db")
+	s, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenBoltStore(path); err == nil {
+		t.Fatal("database opened twice")
+	}
+
+	sp := SyncPoint{From: 1, Until: 2}
+	if err := s.SetSyncPoint("wss://relay.example.com", "key", sp); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := newTestBoltStoreAt(t, path)
+	if got, ok := reopened.GetSyncPoint("wss://relay.example.com", "key"); !ok || got != sp {
+		t.Fatalf("expected sync point %v, got %v", sp, got)
+	}
+}
+
+// Replaced events are only kept in the history, and the events of a sync are written
+// with its sync point.
+func TestBoltStoreKeepsCandidates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clip.db")
+	node, npub := newTestNode(t), newTestNpub(t)
+	s, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := []*nostr.Event{
+		newTestEvent(t, npub, node, KindNodeAnnouncement, 100),
+		newTestEvent(t, npub, node, KindNodeInfo, 110),
+		newTestEvent(t, npub, node, KindNodeInfo, 120),
+		newTestEvent(t, npub, node, KindNodeInfo, 105),
+	}
+	for _, nev := range events {
+		ev, err := NewEventFromNostrRelay(nev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = s.StoreEvent(ev)
+	}
+	if err := s.SetSyncPoint("wss://relay.example.com", "key", SyncPoint{Until: 130}); err != nil {
+		t.Fatal(err)
+	}
+
+	filter := nostr.Filter{Tags: nostr.TagMap{"k": {strconv.Itoa(int(KindNodeInfo))}}}
+	raw := s.GetRawEvents(filter)
+	if len(raw) != 1 || raw[0].ID != events[2].ID {
+		t.Fatalf("expected the latest node info, got %d events", len(raw))
+	}
+	if history, err := s.GetHistory(KindNodeInfo, node.pubKey()); err != nil || len(history) != 3 {
+		t.Fatalf("expected 3 versions, got %d: %v", len(history), err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countKeys(t, path, bucketEvents); n != 2 {
+		t.Fatalf("expected 2 events in the database, got %d", n)
+	}
+
+	reopened := newTestBoltStoreAt(t, path)
+	if infos := reopened.GetEvents(KindNodeInfo, nil); len(infos) != 1 || infos[0].NostrEvent.ID != events[2].ID {
+		t.Fatal("latest node info not loaded")
+	}
+}
+
+// Evicted nodes are removed from the database with their history.
+func TestBoltStoreRemovesEvicted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clip.db")
+	s, err := OpenBoltStore(path, WithStoreLimits(StoreLimits{MaxNodes: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	// Distinct receive times, so that the nodes received first are evicted.
+	created := nostr.Now() - 100
+	received := time.Now().Add(-time.Minute)
+	for i := range 3 {
+		node, npub := newTestNode(t), newTestNpub(t)
+		received = received.Add(time.Second)
+		storeReceived(t, s, newTestEvent(t, npub, node, KindNodeAnnouncement, created+nostr.Timestamp(i)), received)
+		storeReceived(t, s, newTestEvent(t, npub, node, KindNodeInfo, created+nostr.Timestamp(i)), received)
+	}
+	if stats := s.Stats(); stats.Nodes != 1 || stats.Events != 2 {
+		t.Fatalf("expected 1 node with 2 events, got %+v", stats)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := countKeys(t, path, bucketEvents); n != 2 {
+		t.Fatalf("expected 2 events in the database, got %d", n)
+	}
+	if n := countKeys(t, path, bucketHistory); n != 2 {
+		t.Fatalf("expected 2 events in the history, got %d", n)
+	}
+	if n := countKeys(t, path, bucketEventsByKind); n != 2 {
+		t.Fatalf("expected 2 entries in the index, got %d", n)
+	}
+}
+
+// Databases of older versions kept every version in the events bucket.
+func TestBoltStoreMigratesEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clip.db")
+	node, npub := newTestNode(t), newTestNpub(t)
+	events := []*nostr.Event{
+		newTestEvent(t, npub, node, KindNodeAnnouncement, 100),
+		newTestEvent(t, npub, node, KindNodeInfo, 110),
+		newTestEvent(t, npub, node, KindNodeInfo, 120),
+	}
+
+	db, err := bolt.Open(path, 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, _ := tx.CreateBucket(bucketEvents)
+		history, _ := tx.CreateBucket(bucketHistory)
+		for _, nev := range events {
+			b, _ := json.Marshal(nev)
+			ev, _ := NewEventFromNostrRelay(nev)
+			hk, _ := historyKey(ev)
+			bucket.Put([]byte(nev.ID), b)
+			history.Put(hk, nil)
+		}
+		return nil
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestBoltStoreAt(t, path)
+	if history, err := s.GetHistory(KindNodeInfo, node.pubKey()); err != nil || len(history) != 2 {
+		t.Fatalf("expected 2 versions, got %d: %v", len(history), err)
+	}
+	raw := s.GetRawEvents(nostr.Filter{Tags: nostr.TagMap{"k": {strconv.Itoa(int(KindNodeInfo))}}})
+	if len(raw) != 1 || raw[0].ID != events[2].ID {
+		t.Fatalf("expected the latest node info, got %d events", len(raw))
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countKeys(t, path, bucketEvents); n != 2 {
+		t.Fatalf("expected 2 events in the database, got %d", n)
+	}
+}
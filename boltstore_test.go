@@ -0,0 +1,20 @@
+package clip_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/feelancer21/clip"
+	"github.com/feelancer21/clip/storetest"
+)
+
+func TestBoltStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) clip.Store {
+		s, err := clip.OpenBoltStore(filepath.Join(t.TempDir(), "clip.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
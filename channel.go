@@ -0,0 +1,273 @@
+package clip
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var errNoCipher = errors.New("nostr signer does not support encryption")
+
+// ChannelRequestThread groups a channel open request with the responses linked to it
+// by the request ID.
+type ChannelRequestThread struct {
+	RequestID string                               `json:"request_id"`
+	Incoming  bool                                 `json:"incoming"`
+	Request   *EventEnvelope[ChannelOpenRequest]   `json:"request"`
+	Responses []EventEnvelope[ChannelOpenResponse] `json:"responses"`
+
+	// Nostr pubkey of the other party
+	peer string
+}
+
+// SendChannelRequest sends an encrypted channel open request to the operator of the
+// node with the given pubkey. The operator's npub is taken from the node announcement,
+// and the request is checked against the channel size limits of the node info before
+// it is published, so the node info of the node on our network is required. The node
+// pubkey of the request has to be our node. Returns the publish result and the
+// request ID.
+func (c *Client) SendChannelRequest(ctx context.Context, pubkey string, req ChannelOpenRequest,
+	urls []string, from time.Time) (PublishResult, string, error) {
+
+	if c.cipher == nil {
+		return PublishResult{}, "", errNoCipher
+	}
+
+	if req.NodePubKey == "" {
+		req.NodePubKey = c.info.PubKey
+	}
+	if req.NodePubKey != c.info.PubKey {
+		return PublishResult{}, "", fmt.Errorf("node pubkey %s of the request isn't our node %s",
+			req.NodePubKey, c.info.PubKey)
+	}
+	if err := req.Validate(); err != nil {
+		return PublishResult{}, "", fmt.Errorf("validating request: %w", err)
+	}
+
+	pubkeys := map[string]struct{}{pubkey: {}}
+	infos, err, _ := c.GetEvents(ctx, KindNodeInfo, pubkeys, urls, from)
+	if err != nil {
+		return PublishResult{}, "", err
+	}
+
+	announcements := c.store.GetEvents(KindNodeAnnouncement, pubkeys)
+	if len(announcements) == 0 {
+		return PublishResult{}, "", fmt.Errorf("no node announcement found for %s", pubkey)
+	}
+	recipient := announcements[0].NostrEvent.PubKey
+
+	var checked bool
+	for _, ev := range infos {
+		id, err := ev.GetIdentifier()
+		if err != nil || id.Network != c.info.Network || len(id.Opts) > 0 {
+			continue
+		}
+		var info NodeInfo
+		if err := json.Unmarshal([]byte(ev.NostrEvent.Content), &info); err != nil {
+			return PublishResult{}, "", fmt.Errorf("parsing node info of %s: %w", pubkey, err)
+		}
+		if err := req.CheckLimits(&info); err != nil {
+			return PublishResult{}, "", err
+		}
+		checked = true
+	}
+	if !checked {
+		return PublishResult{}, "", fmt.Errorf("no node info found for %s on %s to check the channel size limits",
+			pubkey, c.info.Network)
+	}
+
+	requestID, err := newRequestID()
+	if err != nil {
+		return PublishResult{}, "", err
+	}
+
+	res, err := c.publishEncrypted(ctx, req, recipient, KindChannelRequest, urls, requestID)
+	if err != nil {
+		return PublishResult{}, "", err
+	}
+	return res, requestID, nil
+}
+
+// RespondChannelRequest answers the incoming channel open request with the given ID.
+func (c *Client) RespondChannelRequest(ctx context.Context, requestID string, resp ChannelOpenResponse,
+	urls []string, from time.Time) (PublishResult, error) {
+
+	if err := resp.Validate(); err != nil {
+		return PublishResult{}, fmt.Errorf("validating response: %w", err)
+	}
+
+	threads, err, _ := c.ListChannelRequests(ctx, urls, from)
+	if err != nil {
+		return PublishResult{}, err
+	}
+
+	for _, t := range threads {
+		if t.RequestID != requestID || !t.Incoming {
+			continue
+		}
+		return c.publishEncrypted(ctx, resp, t.peer, KindChannelResponse, urls, requestID)
+	}
+	return PublishResult{}, fmt.Errorf("no incoming channel request found with id %s", requestID)
+}
+
+// ListChannelRequests fetches all channel open requests sent or received by us,
+// together with their responses. Requests whose node pubkey isn't the node of their
+// sender, which is ours for the requests sent by us, are skipped with a fetch error.
+func (c *Client) ListChannelRequests(ctx context.Context, urls []string,
+	from time.Time) ([]ChannelRequestThread, error, []error) {
+
+	if c.cipher == nil {
+		return nil, errNoCipher, nil
+	}
+
+	since := nostr.Timestamp(from.Unix())
+
+	var fetchErrors []error
+	err, err2 := c.syncAnnouncements(ctx, urls, &since)
+	if err != nil {
+		return nil, err, nil
+	}
+	fetchErrors = append(fetchErrors, err2...)
+
+	// Fetching everything addressed to us and everything sent by us.
+	filters := []nostr.Filter{
+		{Since: &since, Tags: nostr.TagMap{"p": {c.pub}}},
+		{Since: &since, Authors: []string{c.pub}},
+	}
+	for _, kind := range []Kind{KindChannelRequest, KindChannelResponse} {
+		for _, filter := range filters {
+			err, err2 = c.syncKind(ctx, kind, urls, filter)
+			if err != nil {
+				return nil, err, nil
+			}
+			fetchErrors = append(fetchErrors, err2...)
+		}
+	}
+
+	threads := make(map[string]*ChannelRequestThread)
+	for _, ev := range c.store.GetEvents(KindChannelRequest, nil) {
+		peer, incoming, ok := c.channelPeer(ev)
+		if !ok {
+			continue
+		}
+		env, err := decryptEnvelope[ChannelOpenRequest](ctx, c.cipher, ev, peer)
+		if err != nil {
+			fetchErrors = append(fetchErrors, fmt.Errorf("decrypting channel request %s: %v",
+				ev.NostrEvent.ID, err))
+			continue
+		}
+		if len(env.Id.Opts) == 0 {
+			continue
+		}
+		if env.Payload.NodePubKey != env.Id.PubKey {
+			fetchErrors = append(fetchErrors, fmt.Errorf("channel request %s for node %s sent by node %s",
+				ev.NostrEvent.ID, env.Payload.NodePubKey, env.Id.PubKey))
+			continue
+		}
+		requestID := env.Id.Opts[0]
+		threads[peer+":"+requestID] = &ChannelRequestThread{
+			RequestID: requestID,
+			Incoming:  incoming,
+			Request:   env,
+			peer:      peer,
+		}
+	}
+
+	for _, ev := range c.store.GetEvents(KindChannelResponse, nil) {
+		peer, _, ok := c.channelPeer(ev)
+		if !ok {
+			continue
+		}
+		env, err := decryptEnvelope[ChannelOpenResponse](ctx, c.cipher, ev, peer)
+		if err != nil {
+			fetchErrors = append(fetchErrors, fmt.Errorf("decrypting channel response %s: %v",
+				ev.NostrEvent.ID, err))
+			continue
+		}
+		if len(env.Id.Opts) == 0 {
+			continue
+		}
+		// A response is only linked to a request between the same two parties.
+		t, ok := threads[peer+":"+env.Id.Opts[0]]
+		if !ok {
+			continue
+		}
+		t.Responses = append(t.Responses, *env)
+	}
+
+	res := make([]ChannelRequestThread, 0, len(threads))
+	for _, t := range threads {
+		sort.Slice(t.Responses, func(i, j int) bool {
+			return t.Responses[i].CreatedAt < t.Responses[j].CreatedAt
+		})
+		res = append(res, *t)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Request.CreatedAt > res[j].Request.CreatedAt
+	})
+	return res, nil, fetchErrors
+}
+
+// channelPeer returns the nostr pubkey of the other party of a request or response
+// and whether the event was sent to us. Returns false if we are not involved.
+func (c *Client) channelPeer(ev *Event) (string, bool, bool) {
+	recipient, ok := ev.RecipientPubKey()
+	if !ok {
+		return "", false, false
+	}
+	switch {
+	case recipient == c.pub:
+		return ev.NostrEvent.PubKey, true, true
+	case ev.NostrEvent.PubKey == c.pub:
+		return recipient, false, true
+	}
+	return "", false, false
+}
+
+// publishEncrypted publishes data as NIP-44 encrypted content addressed to the
+// recipient with a 'p' tag.
+func (c *Client) publishEncrypted(ctx context.Context, data any, recipient string, kind Kind,
+	urls []string, opts ...string) (PublishResult, error) {
+
+	if c.cipher == nil {
+		return PublishResult{}, errNoCipher
+	}
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		return PublishResult{}, fmt.Errorf("marshaling payload: %w", err)
+	}
+
+	content, err := c.cipher.Encrypt(ctx, string(b), recipient)
+	if err != nil {
+		return PublishResult{}, fmt.Errorf("encrypting payload: %w", err)
+	}
+
+	tags := nostr.Tags{{"p", recipient}}
+	return c.publishContent(ctx, content, tags, kind, urls, opts...)
+}
+
+func decryptEnvelope[T any](ctx context.Context, cipher nostr.Cipher, ev *Event,
+	peer string) (*EventEnvelope[T], error) {
+
+	plaintext, err := cipher.Decrypt(ctx, ev.NostrEvent.Content, peer)
+	if err != nil {
+		return nil, err
+	}
+	return newEventEnvelope[T](ev, plaintext)
+}
+
+func newRequestID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating request id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,228 @@
+package clip
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// channelTest is a node operator with a client publishing to the relay.
+type channelTest struct {
+	node *testNode
+	c    *Client
+}
+
+func newChannelTest(t *testing.T, relay *testRelay, info *NodeInfo) channelTest {
+	t.Helper()
+	ct := channelTest{node: newTestNode(t)}
+	ct.c = newTestClient(t, newTestNpub(t), ct.node)
+	ct.publish(t, relay, struct{}{}, KindNodeAnnouncement)
+	if info != nil {
+		ct.publish(t, relay, info, KindNodeInfo)
+	}
+	return ct
+}
+
+func (ct channelTest) publish(t *testing.T, relay *testRelay, data any, kind Kind) {
+	t.Helper()
+	res, err := ct.c.Publish(withTimeout(t), data, kind, []string{relay.url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := res.Wait().Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (ct channelTest) threads(t *testing.T, relay *testRelay) ([]ChannelRequestThread, []error) {
+	t.Helper()
+	threads, err, fetchErrs := ct.c.ListChannelRequests(withTimeout(t), []string{relay.url},
+		time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return threads, fetchErrs
+}
+
+func uint64Ptr(v uint64) *uint64 { return &v }
+
+// A request is decrypted by both parties, and the response is linked to it by the
+// request ID.
+func TestChannelRequestRoundTrip(t *testing.T) {
+	relay := newTestRelay(t)
+	from := time.Now().Add(-time.Hour)
+	alice := newChannelTest(t, relay, nil)
+	bob := newChannelTest(t, relay, &NodeInfo{
+		MinChannelSizeSat: uint64Ptr(1_000_000),
+		MaxChannelSizeSat: uint64Ptr(10_000_000),
+	})
+
+	msg := "Hi, interested in a channel?"
+	res, requestID, err := alice.c.SendChannelRequest(withTimeout(t), bob.node.pubKey(),
+		ChannelOpenRequest{AmountSat: 5_000_000, Message: &msg}, []string{relay.url}, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := res.Wait().Err(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(res.Event.Content, msg) {
+		t.Fatal("request not encrypted")
+	}
+
+	threads, fetchErrs := bob.threads(t, relay)
+	if len(threads) != 1 || len(fetchErrs) != 0 {
+		t.Fatalf("expected 1 thread, got %d, errors: %v", len(threads), fetchErrs)
+	}
+	got := threads[0]
+	if !got.Incoming || got.RequestID != requestID || got.Request.Payload.AmountSat != 5_000_000 ||
+		got.Request.Payload.NodePubKey != alice.node.pubKey() || *got.Request.Payload.Message != msg {
+		t.Fatalf("unexpected incoming thread %+v, request %+v", got, got.Request.Payload)
+	}
+
+	res, err = bob.c.RespondChannelRequest(withTimeout(t), requestID, ChannelOpenResponse{
+		Status:           ChannelResponseCounter,
+		CounterAmountSat: uint64Ptr(3_000_000),
+	}, []string{relay.url}, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := res.Wait().Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	threads, fetchErrs = alice.threads(t, relay)
+	if len(threads) != 1 || len(fetchErrs) != 0 {
+		t.Fatalf("expected 1 thread, got %d, errors: %v", len(threads), fetchErrs)
+	}
+	got = threads[0]
+	if got.Incoming || got.RequestID != requestID || len(got.Responses) != 1 {
+		t.Fatalf("unexpected outgoing thread %+v", got)
+	}
+	resp := got.Responses[0].Payload
+	if resp.Status != ChannelResponseCounter || *resp.CounterAmountSat != 3_000_000 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	if _, err := alice.c.RespondChannelRequest(withTimeout(t), requestID, ChannelOpenResponse{
+		Status: ChannelResponseAccept,
+	}, []string{relay.url}, from); err == nil {
+		t.Fatal("answered an outgoing request")
+	}
+}
+
+// A response is only linked to a request between the same two parties, and a request
+// naming another node than its sender's is skipped.
+func TestChannelRequestThreadKeying(t *testing.T) {
+	relay := newTestRelay(t)
+	from := time.Now().Add(-time.Hour)
+	alice := newChannelTest(t, relay, nil)
+	bob := newChannelTest(t, relay, &NodeInfo{})
+	carol := newChannelTest(t, relay, nil)
+
+	res, requestID, err := alice.c.SendChannelRequest(withTimeout(t), bob.node.pubKey(),
+		ChannelOpenRequest{AmountSat: 5_000_000}, []string{relay.url}, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := res.Wait().Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Carol answers the request of Alice to Bob.
+	res, err = carol.c.publishEncrypted(withTimeout(t), ChannelOpenResponse{Status: ChannelResponseAccept},
+		alice.c.pub, KindChannelResponse, []string{relay.url}, requestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := res.Wait().Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Carol sends a request in the name of Alice's node.
+	res, err = carol.c.publishEncrypted(withTimeout(t), ChannelOpenRequest{
+		AmountSat:  5_000_000,
+		NodePubKey: alice.node.pubKey(),
+	}, bob.c.pub, KindChannelRequest, []string{relay.url}, "forged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := res.Wait().Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	threads, _ := alice.threads(t, relay)
+	if len(threads) != 1 || threads[0].RequestID != requestID || len(threads[0].Responses) != 0 {
+		t.Fatalf("expected the thread without responses, got %+v", threads)
+	}
+
+	threads, fetchErrs := bob.threads(t, relay)
+	if len(threads) != 1 || threads[0].RequestID != requestID {
+		t.Fatalf("expected only the request of Alice, got %+v", threads)
+	}
+	if len(fetchErrs) != 1 || !strings.Contains(fetchErrs[0].Error(), carol.node.pubKey()) {
+		t.Fatalf("expected an error about the forged request, got %v", fetchErrs)
+	}
+}
+
+func TestSendChannelRequestChecks(t *testing.T) {
+	relay := newTestRelay(t)
+	from := time.Now().Add(-time.Hour)
+	alice := newChannelTest(t, relay, nil)
+	bob := newChannelTest(t, relay, &NodeInfo{
+		MinChannelSizeSat: uint64Ptr(1_000_000),
+		MaxChannelSizeSat: uint64Ptr(10_000_000),
+	})
+	carol := newChannelTest(t, relay, nil)
+
+	for _, tt := range []struct {
+		name   string
+		pubkey string
+		req    ChannelOpenRequest
+		err    string
+	}{
+		{"missing amount", bob.node.pubKey(), ChannelOpenRequest{}, "validating request"},
+		{"push amount", bob.node.pubKey(), ChannelOpenRequest{AmountSat: 10, PushAmountSat: 10}, "validating request"},
+		{"below minimum", bob.node.pubKey(), ChannelOpenRequest{AmountSat: 999_999}, "below the minimum"},
+		{"above maximum", bob.node.pubKey(), ChannelOpenRequest{AmountSat: 10_000_001}, "above the maximum"},
+		{"other node", bob.node.pubKey(), ChannelOpenRequest{AmountSat: 5_000_000, NodePubKey: carol.node.pubKey()},
+			"isn't our node"},
+		{"no node info", carol.node.pubKey(), ChannelOpenRequest{AmountSat: 5_000_000}, "no node info"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := alice.c.SendChannelRequest(withTimeout(t), tt.pubkey, tt.req, []string{relay.url}, from)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+
+	// Only the announcement of Alice has been published.
+	var published int
+	for _, ev := range relay.stored() {
+		if ev.PubKey == alice.c.pub {
+			published++
+		}
+	}
+	if published != 1 {
+		t.Fatalf("expected only the announcement of Alice, got %d events", published)
+	}
+}
+
+func TestChannelOpenResponseValidate(t *testing.T) {
+	for _, tt := range []struct {
+		resp  ChannelOpenResponse
+		valid bool
+	}{
+		{ChannelOpenResponse{Status: ChannelResponseAccept}, true},
+		{ChannelOpenResponse{Status: ChannelResponseDecline}, true},
+		{ChannelOpenResponse{Status: ChannelResponseCounter, CounterAmountSat: uint64Ptr(1)}, true},
+		{ChannelOpenResponse{Status: ChannelResponseCounter}, false},
+		{ChannelOpenResponse{Status: "maybe"}, false},
+		{ChannelOpenResponse{}, false},
+	} {
+		if err := tt.resp.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%+v: expected valid %v, got %v", tt.resp, tt.valid, err)
+		}
+	}
+}
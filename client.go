@@ -3,8 +3,14 @@ package clip
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nbd-wtf/go-nostr"
@@ -14,12 +20,26 @@ type Client struct {
 	// Responsible for publishing and subscribing to events
 	pool *nostr.SimplePool
 
-	// A simple in-memory store
-	store *MapStore
+	// A simple in-memory store by default
+	store Store
+
+	// Sync points per relay for incremental syncs. Nil if the store isn't persistent.
+	syncState SyncState
+
+	// Set if the store keeps rejected events
+	rejections RejectionStore
 
 	// Responsible for signing events
 	signer EventSigner
 
+	// Signs the NIP-42 AUTH events of the relays in authRelays
+	nostrSigner nostr.Signer
+	authRelays  map[string]struct{}
+
+	// Responsible for encrypting and decrypting direct messages. Nil if the
+	// nostr signer doesn't support encryption.
+	cipher nostr.Cipher
+
 	// Responsible for interacting with the Lightning node (signing, getting info, etc)
 	ln LightningNode
 
@@ -28,19 +48,102 @@ type Client struct {
 
 	// Cache of the node info
 	info NodeInfoResponse
+
+	// NIP-13 difficulty to mine when publishing events
+	powDifficulty int
+
+	// Minimum NIP-13 difficulty of fetched events. Events with less work are
+	// dropped before any signature is checked.
+	minPowDifficulty int
+
+	// Set if the store is reconciled with the relays using NIP-77
+	negentropy bool
+
+	// Relays which don't support NIP-77, with the time they have been found to
+	negUnsupported sync.Map
+
+	// Running reconciliations by subscription ID, which get the NIP-77 messages and
+	// the notices of their pool relay
+	negSessions sync.Map
+	negSeq      atomic.Uint64
+
+	// Health of the relays, persisted if the store implements RelayHealthStore
+	health *relayTracker
+
+	// Retries and quorum of published events
+	publishPolicy PublishPolicy
+
+	// Number of events requested per query when syncing
+	pagination Pagination
+
+	// Limits of the events received from the relays and the dropped events
+	ingest *ingestTracker
+
+	// Dialer of the connections to the relays. Nil to connect directly.
+	relayProxy *ProxyDialer
+}
+
+// ClientOption configures optional behaviour of the Client.
+type ClientOption func(*Client)
+
+// WithPowDifficulty sets the NIP-13 difficulty mined on published events.
+func WithPowDifficulty(bits int) ClientOption {
+	return func(c *Client) {
+		c.powDifficulty = bits
+	}
 }
 
-func NewClient(ctx context.Context, nostrSigner nostr.Signer, ln LightningNode) (*Client, error) {
+// WithStore uses the given store instead of an in-memory MapStore. If the store
+// implements SyncState, only events newer than the last sync point of each relay
+// are fetched.
+func WithStore(s Store) ClientOption {
+	return func(c *Client) {
+		c.store = s
+		c.syncState, _ = s.(SyncState)
+		c.rejections, _ = s.(RejectionStore)
+		c.health.store, _ = s.(RelayHealthStore)
+		c.ingest.store, _ = s.(DropStore)
+	}
+}
+
+// WithMinPowDifficulty sets the minimum NIP-13 difficulty of fetched events.
+func WithMinPowDifficulty(bits int) ClientOption {
+	return func(c *Client) {
+		c.minPowDifficulty = bits
+	}
+}
+
+func NewClient(ctx context.Context, nostrSigner nostr.Signer, ln LightningNode,
+	opts ...ClientOption) (*Client, error) {
+
 	combinedSigner := &CombinedSigner{
 		NostrSigner: nostrSigner,
 		LnSigner:    ln,
 	}
 
 	c := &Client{
-		pool:   nostr.NewSimplePool(ctx),
-		store:  NewMapStore(),
-		signer: combinedSigner,
-		ln:     ln,
+		store:       NewMapStore(),
+		signer:      combinedSigner,
+		nostrSigner: nostrSigner,
+		authRelays:  make(map[string]struct{}),
+		ln:          ln,
+		health:      newRelayTracker(),
+		ingest:      newIngestTracker(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	// Signatures are checked in Event.Verify, after the cheap checks like the
+	// proof of work. So the relays don't need to check them before.
+	c.pool = nostr.NewSimplePool(c.relayContext(ctx),
+		nostr.WithRelayOptions(assumeValid{}, negHandlers{c}),
+		nostr.WithAuthHandler(c.signAuth),
+	)
+	if err := c.health.load(); err != nil {
+		return nil, err
+	}
+	if cipher, ok := nostrSigner.(nostr.Cipher); ok {
+		c.cipher = cipher
 	}
 
 	initCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
@@ -62,6 +165,19 @@ func NewClient(ctx context.Context, nostrSigner nostr.Signer, ln LightningNode)
 	return c, nil
 }
 
+// relayContext returns the context to connect to the relays with.
+func (c *Client) relayContext(ctx context.Context) context.Context {
+	if c.relayProxy == nil {
+		return ctx
+	}
+	return withRelayDialer(ctx, c.relayProxy)
+}
+
+// Network returns the network of the connected Lightning node.
+func (c *Client) Network() string {
+	return c.info.Network
+}
+
 func (c *Client) GetNodeInfo(ctx context.Context) (NodeInfoResponse, error) {
 	info, err := c.ln.GetNodeInfo(ctx)
 	if err == nil && !info.checkNetwork() {
@@ -83,73 +199,382 @@ func (c *Client) GetNodeInfo(ctx context.Context) (NodeInfoResponse, error) {
 func (c *Client) GetEvents(ctx context.Context, kind Kind, pubkeys map[string]struct{}, urls []string,
 	from time.Time) ([]*Event, error, []error) {
 
+	return c.GetEventsByQuery(ctx, kind, Query{PubKeys: pubkeys}, urls, from)
+}
+
+// GetEventsByQuery is like GetEvents, but returns only the events matching the query.
+// If the query has pubkeys, the relays are only asked for the node info of the npubs
+// bound to these nodes, and with a network only for the events with its 'n' tag.
+// Events of older versions without 'n' tag are only fetched by queries without a
+// network. The other fields of the query are applied locally.
+func (c *Client) GetEventsByQuery(ctx context.Context, kind Kind, q Query, urls []string,
+	from time.Time) ([]*Event, error, []error) {
+
 	since := nostr.Timestamp(from.Unix())
-	filter := nostr.Filter{
-		Kinds: []int{KindLightningInformation},
-		Since: &since,
-	}
 
 	var fetchErrors []error
 	// We have to sync our store twice: once for node announcements and
 	// once for the specific kind. Node announcements have to be fetched
 	// first to ensure that we have all relevant announcements in our store
 	// when processing the other kinds.
-	filter.Tags = nostr.TagMap{"k": {strconv.Itoa(int(KindNodeAnnouncement))}}
-	err, err2 := c.syncStoreWithPool(ctx, urls, filter)
+	err, err2 := c.syncAnnouncements(ctx, urls, &since)
 	if err != nil {
-		return nil, fmt.Errorf("fetching node announcements: %v", err), nil
+		return nil, err, nil
 	}
 	fetchErrors = append(fetchErrors, err2...)
 
 	if kind != KindNodeAnnouncement {
-		filter.Tags = nostr.TagMap{"k": {strconv.Itoa(int(kind))}}
-		err, err2 = c.syncStoreWithPool(ctx, urls, filter)
+		// The events of the nodes are only accepted from their bound npubs, so only
+		// these are asked for. The network is asked for by the 'n' tag. Relays can't
+		// match a part of the 'd' tag, so the opts variants are filtered locally, and
+		// the network as well for the events of older versions without 'n' tag.
+		filter := nostr.Filter{Since: &since}
+		if kind == KindNodeInfo && len(q.PubKeys) > 0 {
+			filter.Authors = c.boundNpubs(q.PubKeys)
+		}
+		if q.Network != "" {
+			filter.Tags = nostr.TagMap{"n": {q.Network}}
+		}
+		if kind != KindNodeInfo || len(q.PubKeys) == 0 || len(filter.Authors) > 0 {
+			err, err2 = c.syncKind(ctx, kind, urls, filter)
+			if err != nil {
+				return nil, err, nil
+			}
+			fetchErrors = append(fetchErrors, err2...)
+		}
+
+		// Additionally asking the relays announced by the nodes themselves.
+		err, err2 = c.syncRelayHints(ctx, kind, q.PubKeys, urls, &since)
 		if err != nil {
-			return nil, fmt.Errorf("fetching events of kind %d: %v", kind, err), nil
+			return nil, err, nil
 		}
 		fetchErrors = append(fetchErrors, err2...)
 	}
-	return c.store.GetEvents(kind, pubkeys), nil, fetchErrors
+	return c.QueryLocalEvents(kind, q), nil, fetchErrors
+}
+
+// boundNpubs returns the sorted npubs bound to the nodes.
+func (c *Client) boundNpubs(pubkeys map[string]struct{}) []string {
+	var npubs []string
+	for pk := range pubkeys {
+		if state, ok := c.store.GetAnnouncementState(pk); ok && state.PubKey != "" &&
+			!slices.Contains(npubs, state.PubKey) {
+			npubs = append(npubs, state.PubKey)
+		}
+	}
+	sort.Strings(npubs)
+	return npubs
+}
+
+// tagsD returns the sorted 'd' tags of the events of a kind without options of the
+// nodes on a network.
+func tagsD(kind Kind, pubkeys map[string]struct{}, network string) []string {
+	tags := make([]string, 0, len(pubkeys))
+	for pk := range pubkeys {
+		tags = append(tags, fmt.Sprintf("%d:%s:%s", kind, pk, network))
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// GetLocalEvents returns the events of the store without fetching from relays.
+func (c *Client) GetLocalEvents(kind Kind, pubkeys map[string]struct{}) []*Event {
+	return c.store.GetEvents(kind, pubkeys)
+}
+
+// syncAnnouncements syncs all node announcements since the given timestamp.
+func (c *Client) syncAnnouncements(ctx context.Context, urls []string, since *nostr.Timestamp) (error, []error) {
+	filter := nostr.Filter{
+		Kinds: []int{KindLightningInformation},
+		Since: since,
+		Tags:  nostr.TagMap{"k": {strconv.Itoa(int(KindNodeAnnouncement))}},
+	}
+	err, fetchErrors := c.syncStoreWithPool(ctx, urls, filter)
+	if err != nil {
+		return fmt.Errorf("fetching node announcements: %v", err), nil
+	}
+	return nil, fetchErrors
+}
+
+// syncKind syncs the events of the given kind. The filter may restrict the events
+// further (e.g. by authors or tags). Node announcements have to be synced before.
+func (c *Client) syncKind(ctx context.Context, kind Kind, urls []string, filter nostr.Filter) (error, []error) {
+	tags := nostr.TagMap{"k": {strconv.Itoa(int(kind))}}
+	for k, v := range filter.Tags {
+		tags[k] = v
+	}
+	filter.Kinds = []int{KindLightningInformation}
+	filter.Tags = tags
+
+	err, fetchErrors := c.syncStoreWithPool(ctx, urls, filter)
+	if err != nil {
+		return fmt.Errorf("fetching events of kind %d: %v", kind, err), nil
+	}
+	return nil, fetchErrors
 }
 
 // syncStoreWithPool fetches events from the given URLs using the provided filter
-// and stores them in the client's store.
+// and stores them in the client's store. Relays which have stayed unhealthy are
+// skipped according to the health policy.
 // Returns (error, []error): critical error + non-fatal warnings (fetchErrors).
 // fetchErrors collect per-event issues without stopping the fetch process,
 // enabling resilient operation across multiple relays and events.
 func (c *Client) syncStoreWithPool(ctx context.Context, urls []string, filter nostr.Filter) (error, []error) {
+	urls = c.health.usable(urls)
+	if c.negentropy {
+		return c.syncStoreNegentropy(ctx, urls, filter)
+	}
+	return c.syncStoreFetch(ctx, urls, filter)
+}
 
-	var fetchErrors []error
-	appendErrs := func(err error) { fetchErrors = append(fetchErrors, err) }
+// syncStoreFetch fetches the events from every relay separately, page by page. If
+// the store is persistent, the fetch starts at the last sync point of the relay for
+// the filter. The sync point is only advanced if the relay has sent all stored events,
+// and not past events dropped because of the ingest limits.
+func (c *Client) syncStoreFetch(ctx context.Context, urls []string, filter nostr.Filter) (error, []error) {
+	key := syncKey(filter)
 
-	res := c.pool.FetchManyReplaceable(ctx, urls, filter)
+	var from nostr.Timestamp
+	if filter.Since != nil {
+		from = *filter.Since
+	}
+	// Events may arrive with a delay at the relays, so the next sync overlaps.
+	until := nostr.Now() - EventGracePeriodSeconds
+
+	type result struct {
+		relay    string
+		sp       SyncPoint
+		events   []*nostr.Event
+		err      error
+		warnings []error
+	}
+	results := make(chan result, len(urls))
+
+	for _, u := range urls {
+		go func(relay string) {
+			f := filter
+			sp := SyncPoint{From: from, Until: until}
+
+			// Continuing at the last sync point if it covers the requested range.
+			if c.syncState != nil {
+				if last, ok := c.syncState.GetSyncPoint(relay, key); ok && last.From <= from &&
+					last.Until > from {
+
+					since := last.Until
+					f.Since = &since
+					sp.From = last.From
+				}
+			}
+
+			events, err, warnings := c.fetchPaged(ctx, relay, f)
+			results <- result{relay: relay, sp: sp, events: events, err: err, warnings: warnings}
+		}(nostr.NormalizeURL(u))
+	}
 
-	res.Range(func(k nostr.ReplaceableKey, ev *nostr.Event) bool {
-		if err := ctx.Err(); err != nil {
-			return false
+	var (
+		fetchErrors []error
+		synced      []result
+		events      = make(map[string][]*nostr.Event, len(urls))
+	)
+	for range urls {
+		r := <-results
+		fetchErrors = append(fetchErrors, r.warnings...)
+		if r.err != nil {
+			fetchErrors = append(fetchErrors, fmt.Errorf("fetching from relay %s: %v", r.relay, r.err))
+		} else {
+			synced = append(synced, r)
 		}
-		// Process each event
-		lev, err := NewEventFromNostrRelay(ev)
-		if err != nil {
-			appendErrs(fmt.Errorf("creating event from nostr relay: %v", err))
-			return true
+		events[r.relay] = r.events
+	}
+	if ctx.Err() != nil {
+		return ctx.Err(), nil
+	}
+	errs, dropped := c.processLatest(events)
+	fetchErrors = append(fetchErrors, errs...)
+
+	if c.syncState == nil {
+		return nil, fetchErrors
+	}
+	for _, r := range synced {
+		if !limitSyncPoint(&r.sp, dropped, r.relay) {
+			continue
+		}
+		if err := c.syncState.SetSyncPoint(r.relay, key, r.sp); err != nil {
+			fetchErrors = append(fetchErrors, fmt.Errorf("saving sync point of relay %s: %v", r.relay, err))
 		}
+	}
+	return nil, fetchErrors
+}
 
-		if ok, err := lev.Verify(); !ok || err != nil {
-			appendErrs(fmt.Errorf("invalid event %v: %v", lev.NostrEvent.ID, err))
-			return true
+// processLatest processes only the latest valid event per address, and returns the
+// errors of the events. The relays don't check the signatures, so if the latest event
+// is invalid, the next older one is processed, so that a forged event of one relay
+// can't hide the events of the others. The events are given per relay, so that the
+// received and invalid events are counted for the relay health. For every relay with
+// events dropped because of the ingest limits, the oldest created_at of these events
+// is returned in dropped.
+func (c *Client) processLatest(byRelay map[string][]*nostr.Event) (errs []error,
+	dropped map[string]nostr.Timestamp) {
+
+	candidates := make(map[nostr.ReplaceableKey][]*nostr.Event)
+	sources := make(map[string][]string)
+	for relay, events := range byRelay {
+		for _, ev := range events {
+			if _, ok := sources[ev.ID]; !ok {
+				k := nostr.ReplaceableKey{PubKey: ev.PubKey, D: ev.Tags.GetD()}
+				candidates[k] = append(candidates[k], ev)
+			}
+			sources[ev.ID] = append(sources[ev.ID], relay)
 		}
-		if err := c.store.StoreEvent(lev); err != nil {
-			appendErrs(fmt.Errorf("storing event failed %v: %v", lev.NostrEvent.ID, err))
+	}
+
+	invalid := make(map[string]int)
+	dropped = make(map[string]nostr.Timestamp)
+	for _, events := range candidates {
+		sort.Slice(events, func(i, j int) bool { return Replaces(events[i], events[j]) })
+		for _, ev := range events {
+			err := c.processEvent(ev)
+			if err == nil {
+				break
+			}
+			errs = append(errs, err)
+			if errors.Is(err, ErrIngestLimit) {
+				for _, relay := range sources[ev.ID] {
+					if t, ok := dropped[relay]; !ok || ev.CreatedAt < t {
+						dropped[relay] = ev.CreatedAt
+					}
+				}
+			}
+			if !isInvalid(err) {
+				break
+			}
+			for _, relay := range sources[ev.ID] {
+				invalid[relay]++
+			}
 		}
+	}
 
-		return true
-	})
+	for relay, events := range byRelay {
+		c.health.received(relay, events, invalid[relay])
+	}
+	return errs, dropped
+}
 
-	if ctx.Err() != nil {
-		return ctx.Err(), nil
+// limitSyncPoint ends the sync point at the oldest event of the relay which has been
+// dropped because of the ingest limits, so that the next sync fetches it again. It
+// returns false if nothing of the range is left to save.
+func limitSyncPoint(sp *SyncPoint, dropped map[string]nostr.Timestamp, relay string) bool {
+	if t, ok := dropped[relay]; ok && t < sp.Until {
+		sp.Until = t
 	}
-	return nil, fetchErrors
+	return sp.Until > sp.From
+}
+
+// syncKey identifies a filter independent of its time range.
+func syncKey(filter nostr.Filter) string {
+	kinds := make([]string, 0, len(filter.Kinds))
+	for _, k := range filter.Kinds {
+		kinds = append(kinds, strconv.Itoa(k))
+	}
+	sort.Strings(kinds)
+
+	authors := slices.Clone(filter.Authors)
+	sort.Strings(authors)
+
+	parts := []string{"kinds=" + strings.Join(kinds, ","), "authors=" + strings.Join(authors, ",")}
+
+	tags := make([]string, 0, len(filter.Tags))
+	for k, v := range filter.Tags {
+		values := slices.Clone(v)
+		sort.Strings(values)
+		tags = append(tags, "#"+k+"="+strings.Join(values, ","))
+	}
+	sort.Strings(tags)
+
+	return strings.Join(append(parts, tags...), ";")
+}
+
+// processEvent verifies an event received from a relay and stores it. If the event
+// isn't accepted, a *RejectedEvent is returned and kept by the store. Events dropped
+// because of the ingest limits return an error wrapping ErrIngestLimit.
+func (c *Client) processEvent(ev *nostr.Event) error {
+	err := c.checkEvent(ev)
+	if err == nil {
+		return nil
+	}
+	// Dropped events are only counted, so that a flood doesn't fill the store.
+	if errors.Is(err, ErrIngestLimit) {
+		return err
+	}
+
+	rej := newRejectedEvent(ev, err)
+	if c.rejections != nil {
+		if err := c.rejections.StoreRejected(rej); err != nil {
+			return errors.Join(rej, fmt.Errorf("storing rejected event: %v", err))
+		}
+	}
+	return rej
+}
+
+func (c *Client) checkEvent(ev *nostr.Event) error {
+	lev, err := NewEventFromNostrRelay(ev)
+	if err != nil {
+		return fmt.Errorf("creating event from nostr relay: %w", err)
+	}
+
+	if _, err := c.verifyEvent(lev); err != nil {
+		return err
+	}
+	// Ties with a stored event are resolved by the store. The event which has lost
+	// is rejected like a stale one.
+	res, err := c.store.StoreEvent(lev)
+	if err != nil {
+		return fmt.Errorf("storing event failed: %w", err)
+	}
+	if res == StoreResultTieLost {
+		return fmt.Errorf("%w: an event with the same created_at and a lower ID is stored", ErrTieLost)
+	}
+
+	// The event is kept as candidate, but only accepted if its author is bound.
+	if lev.kind != KindNodeAnnouncement {
+		id, _ := lev.GetIdentifier()
+		state, _ := c.store.GetAnnouncementState(id.PubKey)
+		if state.PubKey != ev.PubKey {
+			return fmt.Errorf("%w: author %s, bound %q", ErrPubKeyMismatch, ev.PubKey, state.PubKey)
+		}
+	}
+	return nil
+}
+
+// verifyEvent checks the proof of work and the ingest limits of the event before
+// verifying it, and returns its identifier. Only events with a valid signature count
+// towards the limits.
+func (c *Client) verifyEvent(lev *Event) (*Identifier, error) {
+	if c.minPowDifficulty > 0 {
+		if work := lev.PowDifficulty(); work < c.minPowDifficulty {
+			return nil, fmt.Errorf("%w: %d < %d", ErrInsufficientPow, work, c.minPowDifficulty)
+		}
+	}
+
+	var id *Identifier
+	if c.ingest.limits != (IngestLimits{}) {
+		// Malformed 'd' tags are rejected by Verify.
+		if id, _ = lev.GetIdentifier(); id != nil {
+			state, _ := c.store.GetAnnouncementState(id.PubKey)
+			if err := c.ingest.admit(lev.NostrEvent.PubKey, id, state.PubKey); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if ok, err := lev.Verify(); !ok || err != nil {
+		return nil, fmt.Errorf("invalid event: %w", err)
+	}
+	if id != nil {
+		c.ingest.record(lev.NostrEvent.PubKey, id)
+		return id, nil
+	}
+	return lev.GetIdentifier()
 }
 
 // GetEventEnvelopes wraps events with additional metadata (like node aliases).
@@ -164,28 +589,44 @@ func GetEventEnvelopes[T any](c *Client, ctx context.Context, kind Kind, pubkeys
 		return nil, err, nil
 	}
 
+	envelopes, errs := newEventEnvelopes[T](c, ctx, events)
+	return envelopes, nil, append(fetchErrors, errs...)
+}
+
+// GetLocalEventEnvelopes is like GetEventEnvelopes, but only uses the events of the
+// store without fetching from relays.
+func GetLocalEventEnvelopes[T any](c *Client, ctx context.Context, kind Kind,
+	pubkeys map[string]struct{}) ([]EventEnvelope[T], []error) {
+
+	return newEventEnvelopes[T](c, ctx, c.GetLocalEvents(kind, pubkeys))
+}
+
+func newEventEnvelopes[T any](c *Client, ctx context.Context, events []*Event) ([]EventEnvelope[T], []error) {
+	var errs []error
 	envelopes := make([]EventEnvelope[T], 0, len(events))
 	for _, ev := range events {
 		env, err := NewEventEnvelope[T](ev)
 		if err != nil {
-			fetchErrors = append(fetchErrors, fmt.Errorf("creating event envelope: %v", err))
+			errs = append(errs, fmt.Errorf("creating event envelope: %v", err))
 			continue
 		}
 		alias, err := c.ln.GetAlias(ctx, env.Id.PubKey)
 		if err != nil {
 			// We can continue with empty alias if it fails.
-			fetchErrors = append(fetchErrors, fmt.Errorf("getting alias for pubkey %s: %v", env.Id.PubKey, err))
+			errs = append(errs, fmt.Errorf("getting alias for pubkey %s: %v", env.Id.PubKey, err))
 		}
 		env.Alias = alias
 		envelopes = append(envelopes, *env)
 	}
-
-	return envelopes, nil, fetchErrors
+	return envelopes, errs
 }
 
 type PublishResult struct {
 	Event   *nostr.Event
 	Channel chan nostr.PublishResult
+
+	// Number of relays which have to accept the event
+	quorum int
 }
 
 func (c *Client) Publish(ctx context.Context, data any, kind Kind, urls []string,
@@ -197,15 +638,41 @@ func (c *Client) Publish(ctx context.Context, data any, kind Kind, urls []string
 		return PublishResult{}, fmt.Errorf("marshaling node info: %w", err)
 	}
 
+	// Announcing the relays we publish to, so that others can find our events.
+	var tags nostr.Tags
+	if kind == KindNodeAnnouncement {
+		tags = relayHintTags(urls)
+	}
+
+	return c.publishContent(ctx, string(b), tags, kind, urls, opts...)
+}
+
+// publishContent creates, signs, verifies and publishes an event with the given
+// content and additional tags.
+func (c *Client) publishContent(ctx context.Context, content string, tags nostr.Tags, kind Kind,
+	urls []string, opts ...string) (PublishResult, error) {
+
+	if err := c.checkQuorum(urls); err != nil {
+		return PublishResult{}, err
+	}
+
 	ev := Event{NostrEvent: &nostr.Event{
 		PubKey:    c.pub,
 		CreatedAt: nostr.Now(),
-		Content:   string(b),
+		Tags:      tags,
+		Content:   content,
 	}}
 
 	if err := ev.Finalize(c.info.Network, c.info.PubKey, kind, opts); err != nil {
 		return PublishResult{}, fmt.Errorf("finalizing event: %w", err)
 	}
+
+	// Mining before signing, so that the nonce is covered by the ln signature.
+	if c.powDifficulty > 0 {
+		if err := ev.DoWork(ctx, c.powDifficulty); err != nil {
+			return PublishResult{}, fmt.Errorf("mining proof of work: %w", err)
+		}
+	}
 	if err := c.signer.SignEvent(ctx, &ev); err != nil {
 		return PublishResult{}, fmt.Errorf("signing event: %w", err)
 	}
@@ -215,8 +682,19 @@ func (c *Client) Publish(ctx context.Context, data any, kind Kind, urls []string
 		return PublishResult{}, fmt.Errorf("verifying event before publish: %v", err)
 	}
 
-	res := c.pool.PublishMany(ctx, urls, *ev.NostrEvent)
-	return PublishResult{Event: ev.NostrEvent, Channel: res}, nil
+	// Keeping our own event, so that it is known without fetching it from a relay.
+	if _, err := c.store.StoreEvent(&ev); err != nil {
+		return PublishResult{}, fmt.Errorf("storing event: %w", err)
+	}
+
+	return c.publishEvent(ctx, ev.NostrEvent, urls), nil
+}
+
+// assumeValid is a relay option which disables the signature check of the relay.
+type assumeValid struct{}
+
+func (assumeValid) ApplyRelayOption(r *nostr.Relay) {
+	r.AssumeValid = true
 }
 
 func (c *Client) Close() error {
@@ -0,0 +1,222 @@
+package clip
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// A forged newer event of one relay must not hide the valid event of another.
+func TestProcessLatestSkipsForgedEvents(t *testing.T) {
+	node, npub := newTestNode(t), newTestNpub(t)
+	c := newTestClient(t, npub, node)
+
+	now := nostr.Now()
+	valid := newTestEvent(t, npub, node, KindNodeAnnouncement, now-100)
+	forged := forge(newTestEvent(t, npub, node, KindNodeAnnouncement, now-10))
+
+	errs, _ := c.processLatest(map[string][]*nostr.Event{
+		"wss://forging.example.com": {forged},
+		"wss://honest.example.com":  {valid},
+	})
+	if len(errs) != 1 || ReasonOf(errs[0]) != ReasonNostrSignature {
+		t.Fatalf("expected one bad_nostr_sig error, got %v", errs)
+	}
+
+	state, ok := c.store.GetAnnouncementState(node.pubKey())
+	if !ok || state.PubKey != npub.pk {
+		t.Fatalf("valid announcement not accepted: %+v", state)
+	}
+	if got := c.GetLocalEvents(KindNodeAnnouncement, nil); len(got) != 1 || got[0].NostrEvent.ID != valid.ID {
+		t.Fatalf("expected the valid announcement, got %v", got)
+	}
+}
+
+// Node info is only requested from the bound npubs and by the 'n' tag of the network,
+// and the opts variants are filtered locally.
+func TestGetEventsByQueryRequestsBoundNpubs(t *testing.T) {
+	node, npub, other := newTestNode(t), newTestNpub(t), newTestNpub(t)
+	c := newTestClient(t, npub, node)
+
+	created := nostr.Now() - 3600
+	info := newTestEvent(t, npub, node, KindNodeInfo, created)
+	variant := newTestEvent(t, npub, node, KindNodeInfo, created, "variant")
+	relay := newTestRelay(t)
+	relay.add(
+		newTestEvent(t, npub, node, KindNodeAnnouncement, created),
+		info, variant,
+		newTestEvent(t, other, node, KindNodeInfo, created),
+	)
+
+	q := Query{PubKeys: map[string]struct{}{node.pubKey(): {}}, Network: "mainnet"}
+	events, err, fetchErrors := c.GetEventsByQuery(withTimeout(t), KindNodeInfo, q, []string{relay.url}, time.Unix(0, 0))
+	if err != nil || len(fetchErrors) > 0 {
+		t.Fatalf("fetching: %v %v", err, fetchErrors)
+	}
+
+	var ids []string
+	for _, ev := range events {
+		ids = append(ids, ev.NostrEvent.ID)
+	}
+	if want := []string{info.ID, variant.ID}; !sameIDs(ids, want) {
+		t.Fatalf("expected the node info of the bound npub %v, got %v", want, ids)
+	}
+
+	var requested bool
+	for _, f := range relay.requested() {
+		if !slices.Contains(f.Tags["k"], "1") {
+			continue
+		}
+		requested = true
+		if !slices.Equal(f.Authors, []string{npub.pk}) || len(f.Tags["d"]) > 0 ||
+			!slices.Equal(f.Tags["n"], []string{"mainnet"}) {
+			t.Fatalf("expected a filter by the bound npub and the network, got %v", f)
+		}
+	}
+	if !requested {
+		t.Fatal("node info not requested")
+	}
+}
+
+func sameIDs(a, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
+
+// Of two events with the same created_at the one with the lower ID is kept, and the
+// other one is rejected as tie_lost, but not counted as invalid.
+func TestProcessEventTieLost(t *testing.T) {
+	node, npub := newTestNode(t), newTestNpub(t)
+	c := newTestClient(t, npub, node)
+
+	created := nostr.Now() - 100
+	if err := c.processEvent(newTestEvent(t, npub, node, KindNodeAnnouncement, created)); err != nil {
+		t.Fatal(err)
+	}
+	low := newTestEventWithContent(t, npub, node, KindNodeInfo, created, `{"about":"one"}`)
+	high := newTestEventWithContent(t, npub, node, KindNodeInfo, created, `{"about":"two"}`)
+	if high.ID < low.ID {
+		low, high = high, low
+	}
+
+	if err := c.processEvent(low); err != nil {
+		t.Fatal(err)
+	}
+	err := c.processEvent(high)
+	if ReasonOf(err) != ReasonTieLost || isInvalid(err) {
+		t.Fatalf("expected a valid event rejected as tie_lost, got %v", err)
+	}
+
+	// The event with the lower ID wins the tie the other way round.
+	other := newTestClient(t, npub, node)
+	for _, ev := range []*nostr.Event{newTestEvent(t, npub, node, KindNodeAnnouncement, created), high, low} {
+		if err := other.processEvent(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, cl := range []*Client{c, other} {
+		if got := cl.GetLocalEvents(KindNodeInfo, nil); len(got) != 1 || got[0].NostrEvent.ID != low.ID {
+			t.Fatal("event with the lower ID not kept")
+		}
+	}
+}
+
+// newMinedTestEvent returns an event like newTestEvent with a proof of work of the
+// given difficulty.
+func newMinedTestEvent(t *testing.T, npub testNpub, node *testNode, kind Kind, createdAt nostr.Timestamp,
+	difficulty int) *nostr.Event {
+
+	t.Helper()
+	content := `{"about":"test node"}`
+	if kind == KindNodeAnnouncement {
+		content = "{}"
+	}
+	ev := &Event{NostrEvent: &nostr.Event{PubKey: npub.pk, CreatedAt: createdAt, Content: content}}
+	if err := ev.Finalize("mainnet", node.pubKey(), kind, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ev.DoWork(withTimeout(t), difficulty); err != nil {
+		t.Fatal(err)
+	}
+	signer := &CombinedSigner{NostrSigner: npub.signer(t), LnSigner: node}
+	if err := signer.SignEvent(withTimeout(t), ev); err != nil {
+		t.Fatal(err)
+	}
+	return ev.NostrEvent
+}
+
+// The work is measured without the 'sig' tag, so the Lightning signature added after
+// mining doesn't break the proof of work of an announcement.
+func TestDoWork(t *testing.T) {
+	node, npub := newTestNode(t), newTestNpub(t)
+	created := nostr.Now() - 100
+
+	for _, kind := range []Kind{KindNodeAnnouncement, KindNodeInfo} {
+		ev, err := NewEventFromNostrRelay(newMinedTestEvent(t, npub, node, kind, created, 12))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := ev.Verify(); !ok || err != nil {
+			t.Fatalf("kind %d: mined event invalid: %v", kind, err)
+		}
+		if got := ev.PowDifficulty(); got != 12 {
+			t.Fatalf("kind %d: expected difficulty 12, got %d", kind, got)
+		}
+		if err := ev.DoWork(withTimeout(t), 12); err == nil {
+			t.Fatalf("kind %d: mined again after signing", kind)
+		}
+	}
+
+	ev := &Event{NostrEvent: newTestEvent(t, npub, node, KindNodeInfo, created)}
+	if got := ev.PowDifficulty(); got != 0 {
+		t.Fatalf("expected no difficulty without a nonce, got %d", got)
+	}
+}
+
+func TestPublishPowDifficulty(t *testing.T) {
+	relay := newTestRelay(t)
+	c := newTestClient(t, newTestNpub(t), newTestNode(t), WithPowDifficulty(12))
+
+	for _, kind := range []Kind{KindNodeAnnouncement, KindNodeInfo} {
+		res, err := c.Publish(withTimeout(t), NodeInfo{}, kind, []string{relay.url})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := res.Wait().Err(); err != nil {
+			t.Fatal(err)
+		}
+		if got := (&Event{NostrEvent: res.Event}).PowDifficulty(); got != 12 {
+			t.Fatalf("kind %d: expected difficulty 12, got %d", kind, got)
+		}
+	}
+}
+
+// Events with too little work are rejected before the signatures are checked.
+func TestMinPowDifficulty(t *testing.T) {
+	node, npub := newTestNode(t), newTestNpub(t)
+	c := newTestClient(t, npub, node, WithMinPowDifficulty(12))
+	created := nostr.Now() - 100
+
+	if err := c.processEvent(newMinedTestEvent(t, npub, node, KindNodeAnnouncement, created, 12)); err != nil {
+		t.Fatal(err)
+	}
+	for name, ev := range map[string]*nostr.Event{
+		"without work":    newTestEvent(t, npub, node, KindNodeInfo, created),
+		"too little work": newMinedTestEvent(t, npub, node, KindNodeInfo, created, 4),
+		"forged":          forge(newTestEvent(t, npub, node, KindNodeInfo, created)),
+	} {
+		if err := c.processEvent(ev); ReasonOf(err) != ReasonInsufficientPow {
+			t.Fatalf("%s: expected insufficient_pow, got %v", name, err)
+		}
+	}
+	if err := c.processEvent(newMinedTestEvent(t, npub, node, KindNodeInfo, created, 12)); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.GetLocalEvents(KindNodeInfo, nil); len(got) != 1 {
+		t.Fatalf("expected the node info with enough work, got %d events", len(got))
+	}
+}
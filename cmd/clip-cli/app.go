@@ -2,13 +2,22 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
 	"time"
 
 	"github.com/feelancer21/clip"
+	"github.com/nbd-wtf/go-nostr"
 	"github.com/urfave/cli/v2"
 )
 
+// Value of the network flag to list the events of all networks.
+const networkAll = "all"
+
 var (
 	timeoutLightning    = 60 * time.Second
 	timeoutNostrPublish = 60 * time.Second
@@ -16,6 +25,7 @@ var (
 
 type ClipApp struct {
 	client *clip.Client
+	store  *clip.BoltStore
 	config *Config
 	ctx    *cli.Context
 }
@@ -26,25 +36,72 @@ func NewApp(c *cli.Context) (*ClipApp, error) {
 		return nil, err
 	}
 
-	client, err := newClient(c.Context, cfg)
+	if err := os.MkdirAll(filepath.Dir(cfg.DBPath), 0o700); err != nil {
+		return nil, fmt.Errorf("creating database directory: %w", err)
+	}
+	store, err := clip.OpenBoltStore(cfg.DBPath,
+		clip.WithStoreLimits(cfg.StoreLimits),
+		clip.WithPinnedNodes(cfg.PinnedNodes...),
+	)
 	if err != nil {
 		return nil, err
 	}
 
+	client, err := newClient(c.Context, cfg, store)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
 	return &ClipApp{
 		client: client,
+		store:  store,
 		config: cfg,
 		ctx:    c,
 	}, nil
 }
 
-func newClient(ctx context.Context, cfg *Config) (*clip.Client, error) {
+func newClient(ctx context.Context, cfg *Config, store *clip.BoltStore) (*clip.Client, error) {
 
 	keyer, err := loadKeyer(ctx, cfg.KeyStorePath)
 	if err != nil {
 		return nil, fmt.Errorf("loading keyer: %w", err)
 	}
 
+	opts := []clip.ClientOption{
+		clip.WithStore(store),
+		clip.WithPowDifficulty(cfg.PowDifficulty),
+		clip.WithMinPowDifficulty(cfg.MinPowDifficulty),
+	}
+	if cfg.Negentropy {
+		opts = append(opts, clip.WithNegentropy())
+	}
+	if len(cfg.AuthRelays) > 0 {
+		opts = append(opts, clip.WithAuthRelays(cfg.AuthRelays...))
+	}
+	opts = append(opts,
+		clip.WithRelayHealthPolicy(cfg.RelayHealth),
+		clip.WithPublishPolicy(cfg.Publish),
+		clip.WithPagination(cfg.Pagination),
+		clip.WithIngestLimits(cfg.IngestLimits),
+	)
+
+	var lndOpts []clip.LNDOption
+	if cfg.Proxy != "" {
+		var fallback func(addr string, err error)
+		if cfg.ProxyFallback {
+			fallback = func(addr string, err error) {
+				fmt.Fprintf(os.Stderr, "warning: connecting to %s without the proxy: %v\n", addr, err)
+			}
+		}
+		dialer, err := clip.NewProxyDialer(cfg.Proxy, fallback)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, clip.WithRelayProxy(dialer))
+		lndOpts = append(lndOpts, clip.WithLNDProxy(dialer))
+	}
+
 	switch cfg.Lnclient {
 	case "lnd":
 		ln, err := clip.NewLND(
@@ -52,15 +109,16 @@ func newClient(ctx context.Context, cfg *Config) (*clip.Client, error) {
 			cfg.LNDConfig.MacaroonPath,
 			cfg.LNDConfig.Host,
 			cfg.LNDConfig.Port,
+			lndOpts...,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create LND client: %w", err)
 		}
-		return clip.NewClient(ctx, keyer, ln)
+		return clip.NewClient(ctx, keyer, ln, opts...)
 
 	case "interactive":
 		ln := clip.NewLnInteractive(cfg.LnInter.Network, cfg.LnInter.PubKey)
-		return clip.NewClient(ctx, keyer, ln)
+		return clip.NewClient(ctx, keyer, ln, opts...)
 
 	default:
 		return nil, fmt.Errorf("unsupported lnclient: %s", cfg.Lnclient)
@@ -80,6 +138,16 @@ func (a *ClipApp) GetInfo() error {
 }
 
 func (a *ClipApp) ListNodeAnnouncements() error {
+	return listEvents[clip.NodeAnnouncement](a, clip.KindNodeAnnouncement)
+}
+
+func (a *ClipApp) ListNodeInfo() error {
+	return listEvents[clip.NodeInfo](a, clip.KindNodeInfo)
+}
+
+// listEvents fetches the events of a kind, unless offline is set, and prints the
+// events of the local database matching the query flags.
+func listEvents[T any](a *ClipApp, kind clip.Kind) error {
 	timeout := a.ctx.Duration("timeout")
 	ctx, cancel := context.WithTimeout(a.ctx.Context, timeout)
 	defer cancel()
@@ -87,24 +155,141 @@ func (a *ClipApp) ListNodeAnnouncements() error {
 	since := a.ctx.Duration("since")
 	from := time.Now().Add(-since)
 
-	var pubkeys map[string]struct{}
+	q, err := a.query(kind)
+	if err != nil {
+		return err
+	}
+
+	showErrors := a.ctx.Bool("show-errors")
+
+	var fetchErrors []error
+	if !a.ctx.Bool("offline") {
+		_, err, fetchErrors = a.client.GetEventsByQuery(ctx, kind, q, a.config.RelayURLs, from)
+		if err != nil {
+			return fmt.Errorf("getting events: %w", err)
+		}
+	}
+
+	res, errs := clip.QueryLocalEventEnvelopes[T](a.client, ctx, kind, q)
+	return printSliceJSON(res, append(fetchErrors, errs...), showErrors)
+}
+
+// query returns the query given by the flags of the command. Node info is filtered
+// by the network of the connected node by default.
+func (a *ClipApp) query(kind clip.Kind) (clip.Query, error) {
+	q := clip.Query{
+		ContactType:  a.ctx.String("contact-type"),
+		CustomRecord: a.ctx.String("custom-record"),
+		About:        a.ctx.String("about"),
+	}
 	if a.ctx.IsSet("pubkey") {
-		pubkeys = map[string]struct{}{a.ctx.String("pubkey"): {}}
+		q.PubKeys = map[string]struct{}{a.ctx.String("pubkey"): {}}
+	}
+	if a.ctx.IsSet("npub") {
+		pk, err := parseNostrPubKey(a.ctx.String("npub"))
+		if err != nil {
+			return q, err
+		}
+		q.Npubs = map[string]struct{}{pk: {}}
+	}
+
+	switch network := a.ctx.String("network"); {
+	case network == networkAll:
+	case network != "":
+		if !clip.IsValidNetwork(network) {
+			return q, fmt.Errorf("invalid network: %s", network)
+		}
+		q.Network = network
+	case kind == clip.KindNodeInfo:
+		q.Network = a.client.Network()
 	}
+	return q, nil
+}
 
+// History fetches the latest node info of a node and shows all versions from the
+// local database.
+func (a *ClipApp) History() error {
+	timeout := a.ctx.Duration("timeout")
+	ctx, cancel := context.WithTimeout(a.ctx.Context, timeout)
+	defer cancel()
+
+	since := a.ctx.Duration("since")
+	from := time.Now().Add(-since)
+
+	pubkey := a.ctx.String("pubkey")
 	showErrors := a.ctx.Bool("show-errors")
 
-	res, err, fetchErrors := clip.GetEventEnvelopes[clip.NodeAnnouncement](a.client,
-		ctx, clip.KindNodeAnnouncement, pubkeys, a.config.RelayURLs, from)
+	var fetchErrors []error
+	if !a.ctx.Bool("offline") {
+		var err error
+		_, err, fetchErrors = a.client.GetEvents(ctx, clip.KindNodeInfo,
+			map[string]struct{}{pubkey: {}}, a.config.RelayURLs, from)
+		if err != nil {
+			return fmt.Errorf("getting events: %w", err)
+		}
+	}
 
+	res, err, errs := a.client.GetNodeInfoHistory(ctx, pubkey)
 	if err != nil {
-		return fmt.Errorf("getting event envelopes: %w", err)
+		return fmt.Errorf("getting node info history: %w", err)
 	}
 
-	return printSliceJSON(res, fetchErrors, showErrors)
+	return printSliceJSON(res, append(fetchErrors, errs...), showErrors)
 }
 
-func (a *ClipApp) ListNodeInfo() error {
+type healedEvent struct {
+	EventID string                    `json:"event_id"`
+	Status  []clip.RelayPublishStatus `json:"status"`
+}
+
+// Status checks which relays have the latest announcement and node info of our node.
+// With --heal, the events are republished to the relays which don't have them.
+func (a *ClipApp) Status() error {
+	timeout := a.ctx.Duration("timeout")
+	ctx, cancel := context.WithTimeout(a.ctx.Context, timeout)
+	defer cancel()
+
+	status, err, fetchErrors := a.client.CheckOwnEvents(ctx, a.config.RelayURLs)
+	if err != nil {
+		return fmt.Errorf("checking own events: %w", err)
+	}
+
+	var healed []healedEvent
+	if a.ctx.Bool("heal") {
+		ctx, cancel := context.WithTimeout(a.ctx.Context, timeoutNostrPublish)
+		defer cancel()
+		for _, res := range a.client.Heal(ctx, status) {
+			healed = append(healed, healedEvent{
+				EventID: res.Event.ID,
+				Status:  res.Wait().Status,
+			})
+		}
+	}
+
+	var errs []string
+	if a.ctx.Bool("show-errors") {
+		errs = make([]string, len(fetchErrors))
+		for i, err := range fetchErrors {
+			errs[i] = err.Error()
+		}
+	}
+	return printJSON(struct {
+		*clip.OwnStatus
+		Healed []healedEvent `json:"healed,omitempty"`
+		Errors []string      `json:"errors,omitempty"`
+	}{status, healed, errs})
+}
+
+type rejectedGroup struct {
+	Reason clip.RejectReason     `json:"reason"`
+	PubKey string                `json:"pub_key"`
+	Count  int                   `json:"count"`
+	Events []*clip.RejectedEvent `json:"events"`
+}
+
+// ListRejected fetches the node announcements and node info and lists all rejected
+// events of the local database grouped by reason and node.
+func (a *ClipApp) ListRejected() error {
 	timeout := a.ctx.Duration("timeout")
 	ctx, cancel := context.WithTimeout(a.ctx.Context, timeout)
 	defer cancel()
@@ -119,14 +304,294 @@ func (a *ClipApp) ListNodeInfo() error {
 
 	showErrors := a.ctx.Bool("show-errors")
 
-	res, err, fetchErrors := clip.GetEventEnvelopes[clip.NodeInfo](a.client,
-		ctx, clip.KindNodeInfo, pubkeys, a.config.RelayURLs, from)
+	// Rejections are reported as fetch errors and kept by the store.
+	var fetchErrors []error
+	if !a.ctx.Bool("offline") {
+		var err error
+		_, err, fetchErrors = a.client.GetEvents(ctx, clip.KindNodeInfo, pubkeys,
+			a.config.RelayURLs, from)
+		if err != nil {
+			return fmt.Errorf("getting events: %w", err)
+		}
+	}
+	var otherErrors []error
+	for _, err := range fetchErrors {
+		var rej *clip.RejectedEvent
+		if !errors.As(err, &rej) {
+			otherErrors = append(otherErrors, err)
+		}
+	}
 
+	rejected, err := a.client.GetRejected()
 	if err != nil {
-		return fmt.Errorf("getting event envelopes: %w", err)
+		return fmt.Errorf("getting rejected events: %w", err)
 	}
 
-	return printSliceJSON(res, fetchErrors, showErrors)
+	type groupKey struct {
+		reason clip.RejectReason
+		pubKey string
+	}
+	groups := make(map[groupKey]*rejectedGroup)
+	for _, r := range rejected {
+		if a.ctx.IsSet("reason") && string(r.Reason) != a.ctx.String("reason") {
+			continue
+		}
+		if _, ok := pubkeys[r.PubKey]; pubkeys != nil && !ok {
+			continue
+		}
+		k := groupKey{r.Reason, r.PubKey}
+		if groups[k] == nil {
+			groups[k] = &rejectedGroup{Reason: r.Reason, PubKey: r.PubKey}
+		}
+		groups[k].Count++
+		groups[k].Events = append(groups[k].Events, r)
+	}
+
+	res := make([]*rejectedGroup, 0, len(groups))
+	for _, g := range groups {
+		sort.Slice(g.Events, func(i, j int) bool {
+			return g.Events[i].CreatedAt > g.Events[j].CreatedAt
+		})
+		res = append(res, g)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Count != res[j].Count {
+			return res[i].Count > res[j].Count
+		}
+		if res[i].Reason != res[j].Reason {
+			return res[i].Reason < res[j].Reason
+		}
+		return res[i].PubKey < res[j].PubKey
+	})
+
+	return printSliceJSON(res, otherErrors, showErrors)
+}
+
+const (
+	// Interval of the eviction of expired nodes in long-running commands.
+	evictInterval = time.Hour
+
+	// Interval in which long-running commands write the pending events, rejections
+	// and counters, so that they aren't lost if the command is killed.
+	flushInterval = 10 * time.Second
+)
+
+// Watch streams the changes of the node announcements and node info as JSON lines
+// until it is interrupted.
+func (a *ClipApp) Watch() error {
+	q, err := a.query(clip.KindNodeInfo)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(evictInterval)
+	defer ticker.Stop()
+	flush := time.NewTicker(flushInterval)
+	defer flush.Stop()
+
+	changes := a.client.Subscribe(a.ctx.Context, q, a.config.RelayURLs)
+	for {
+		select {
+		case change, ok := <-changes:
+			if !ok {
+				return nil
+			}
+			if err := printJSONLine(change); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			a.store.Evict()
+		case <-flush.C:
+			if err := a.store.Flush(); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+			}
+		}
+	}
+}
+
+func (a *ClipApp) StoreStats() error {
+	return printJSON(struct {
+		clip.StoreStats
+		Ingest clip.IngestStats `json:"ingest"`
+	}{a.store.Stats(), a.client.IngestStats()})
+}
+
+type relayStatusEntry struct {
+	clip.RelayHealth
+	Latency    string           `json:"latency"`
+	Status     clip.RelayStatus `json:"status"`
+	Configured bool             `json:"configured"`
+}
+
+// RelayStatus lists the health of the configured relays and of all relays used
+// before, e.g. relays announced by other nodes.
+func (a *ClipApp) RelayStatus() error {
+	configured := make(map[string]struct{}, len(a.config.RelayURLs))
+	for _, u := range a.config.RelayURLs {
+		configured[nostr.NormalizeURL(u)] = struct{}{}
+	}
+
+	var (
+		policy = a.client.RelayHealthPolicy()
+		now    = time.Now()
+		list   []relayStatusEntry
+	)
+	add := func(h clip.RelayHealth) {
+		_, ok := configured[h.URL]
+		delete(configured, h.URL)
+		list = append(list, relayStatusEntry{
+			RelayHealth: h,
+			Latency:     h.Latency.Round(time.Millisecond).String(),
+			Status:      h.Status(policy, now),
+			Configured:  ok,
+		})
+	}
+	for _, h := range a.client.RelayHealth() {
+		add(h)
+	}
+	// Configured relays which haven't been used yet
+	for _, u := range a.config.RelayURLs {
+		if _, ok := configured[nostr.NormalizeURL(u)]; ok {
+			add(clip.RelayHealth{URL: nostr.NormalizeURL(u)})
+		}
+	}
+
+	if a.ctx.Bool("configured") {
+		list = slices.DeleteFunc(list, func(s relayStatusEntry) bool { return !s.Configured })
+	}
+	return printJSON(list)
+}
+
+type discoveredRelay struct {
+	clip.RelayCandidate
+	Latency    string `json:"latency"`
+	Configured bool   `json:"configured"`
+}
+
+// DiscoverRelays syncs the node announcements and probes the relays found in them,
+// in the relay lists of their npubs and in the health records. The best relays which
+// aren't configured are suggested, and added to the config file with --add.
+func (a *ClipApp) DiscoverRelays() error {
+	timeout := a.ctx.Duration("timeout")
+	ctx, cancel := context.WithTimeout(a.ctx.Context, timeout)
+	defer cancel()
+
+	from := time.Now().Add(-a.ctx.Duration("since"))
+	_, err, fetchErrors := a.client.GetEvents(ctx, clip.KindNodeAnnouncement, nil,
+		a.config.RelayURLs, from)
+	if err != nil {
+		return fmt.Errorf("getting node announcements: %w", err)
+	}
+
+	candidates, err, errs := a.client.DiscoverRelays(ctx, a.config.RelayURLs)
+	if err != nil {
+		return fmt.Errorf("discovering relays: %w", err)
+	}
+	fetchErrors = append(fetchErrors, errs...)
+	warnTruncation(fetchErrors)
+	warnDropped(fetchErrors)
+
+	configured := make(map[string]struct{}, len(a.config.RelayURLs))
+	for _, u := range a.config.RelayURLs {
+		configured[nostr.NormalizeURL(u)] = struct{}{}
+	}
+
+	var (
+		list      = make([]discoveredRelay, 0, len(candidates))
+		suggested []string
+		minNodes  = a.ctx.Int("min-nodes")
+	)
+	for _, cand := range candidates {
+		_, ok := configured[cand.URL]
+		list = append(list, discoveredRelay{
+			RelayCandidate: cand,
+			Latency:        cand.Latency.Round(time.Millisecond).String(),
+			Configured:     ok,
+		})
+		if !ok && cand.Supported && cand.Nodes >= minNodes && len(suggested) < a.ctx.Int("max") {
+			suggested = append(suggested, cand.URL)
+		}
+	}
+
+	var added []string
+	if a.ctx.Bool("add") && len(suggested) > 0 {
+		path, err := configPath(a.ctx)
+		if err != nil {
+			return err
+		}
+		if err := addRelayURLs(path, suggested); err != nil {
+			return fmt.Errorf("adding relays to config: %w", err)
+		}
+		added = suggested
+	}
+
+	var errStrings []string
+	if a.ctx.Bool("show-errors") {
+		errStrings = make([]string, len(fetchErrors))
+		for i, err := range fetchErrors {
+			errStrings[i] = err.Error()
+		}
+	}
+	return printJSON(struct {
+		Relays    []discoveredRelay `json:"relays"`
+		Suggested []string          `json:"suggested"`
+		Added     []string          `json:"added,omitempty"`
+		Errors    []string          `json:"errors,omitempty"`
+	}{list, suggested, added, errStrings})
+}
+
+func (a *ClipApp) ExportSnapshot() error {
+	if !a.ctx.IsSet("out") {
+		_, err := a.client.ExportSnapshot(os.Stdout)
+		return err
+	}
+
+	f, err := os.Create(a.ctx.String("out"))
+	if err != nil {
+		return fmt.Errorf("creating snapshot file: %w", err)
+	}
+	n, err := a.client.ExportSnapshot(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("exporting snapshot: %w", err)
+	}
+
+	return printJSON(struct {
+		File     string `json:"file"`
+		Exported int    `json:"exported"`
+	}{a.ctx.String("out"), n})
+}
+
+func (a *ClipApp) ImportSnapshot() error {
+	if a.ctx.NArg() != 1 {
+		return errors.New("expected the snapshot file as argument")
+	}
+
+	f, err := os.Open(a.ctx.Args().First())
+	if err != nil {
+		return fmt.Errorf("opening snapshot file: %w", err)
+	}
+	defer f.Close()
+
+	n, err, fetchErrors := a.client.ImportSnapshot(f)
+	if err != nil {
+		return fmt.Errorf("importing snapshot: %w", err)
+	}
+
+	var errs []string
+	if a.ctx.Bool("show-errors") {
+		errs = make([]string, len(fetchErrors))
+		for i, err := range fetchErrors {
+			errs[i] = err.Error()
+		}
+	}
+	return printJSON(struct {
+		Imported int      `json:"imported"`
+		Rejected int      `json:"rejected"`
+		Errors   []string `json:"errors,omitempty"`
+	}{n, len(fetchErrors), errs})
 }
 
 func (a *ClipApp) PublishNodeAnnouncement() error {
@@ -155,6 +620,96 @@ func (a *ClipApp) PublishNodeInfo() error {
 	return printPublishResults(res, data)
 }
 
+func (a *ClipApp) SendChannelRequest() error {
+	timeout := a.ctx.Duration("timeout")
+	ctx, cancel := context.WithTimeout(a.ctx.Context, timeout)
+	defer cancel()
+
+	since := a.ctx.Duration("since")
+	from := time.Now().Add(-since)
+
+	data := clip.ChannelOpenRequest{
+		AmountSat:     a.ctx.Uint64("amount"),
+		PushAmountSat: a.ctx.Uint64("push"),
+		ChannelType:   a.ctx.String("channel-type"),
+		NodeAddress:   a.ctx.String("address"),
+	}
+	if a.ctx.IsSet("message") {
+		msg := a.ctx.String("message")
+		data.Message = &msg
+	}
+
+	res, requestID, err := a.client.SendChannelRequest(ctx, a.ctx.String("pubkey"), data,
+		a.config.RelayURLs, from)
+	if err != nil {
+		return fmt.Errorf("sending channel request: %w", err)
+	}
+
+	payload := struct {
+		RequestID string `json:"request_id"`
+		clip.ChannelOpenRequest
+	}{
+		RequestID:          requestID,
+		ChannelOpenRequest: data,
+	}
+	return printPublishResults(res, payload)
+}
+
+func (a *ClipApp) ListChannelRequests() error {
+	timeout := a.ctx.Duration("timeout")
+	ctx, cancel := context.WithTimeout(a.ctx.Context, timeout)
+	defer cancel()
+
+	since := a.ctx.Duration("since")
+	from := time.Now().Add(-since)
+
+	showErrors := a.ctx.Bool("show-errors")
+
+	res, err, fetchErrors := a.client.ListChannelRequests(ctx, a.config.RelayURLs, from)
+	if err != nil {
+		return fmt.Errorf("listing channel requests: %w", err)
+	}
+
+	return printSliceJSON(res, fetchErrors, showErrors)
+}
+
+func (a *ClipApp) AnswerChannelRequest() error {
+	timeout := a.ctx.Duration("timeout")
+	ctx, cancel := context.WithTimeout(a.ctx.Context, timeout)
+	defer cancel()
+
+	since := a.ctx.Duration("since")
+	from := time.Now().Add(-since)
+
+	data := clip.ChannelOpenResponse{
+		Status: clip.ChannelResponseStatus(a.ctx.String("status")),
+	}
+	if a.ctx.IsSet("amount") {
+		amount := a.ctx.Uint64("amount")
+		data.CounterAmountSat = &amount
+	}
+	if a.ctx.IsSet("push") {
+		push := a.ctx.Uint64("push")
+		data.CounterPushAmountSat = &push
+	}
+	if a.ctx.IsSet("channel-type") {
+		channelType := a.ctx.String("channel-type")
+		data.CounterChannelType = &channelType
+	}
+	if a.ctx.IsSet("message") {
+		msg := a.ctx.String("message")
+		data.Message = &msg
+	}
+
+	res, err := a.client.RespondChannelRequest(ctx, a.ctx.String("id"), data,
+		a.config.RelayURLs, from)
+	if err != nil {
+		return fmt.Errorf("answering channel request: %w", err)
+	}
+
+	return printPublishResults(res, data)
+}
+
 func (a *ClipApp) Close() error {
-	return a.client.Close()
+	return errors.Join(a.client.Close(), a.store.Close())
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/feelancer21/clip"
 	"github.com/go-playground/validator/v10"
@@ -19,12 +20,57 @@ var (
 // Config is loaded from the YAML file (global).
 type Config struct {
 	KeyStorePath string               `yaml:"key_store_path"`
+	DBPath       string               `yaml:"db_path"`
 	Lnclient     string               `yaml:"lnclient" validate:"required,oneof=lnd interactive"`
 	LNDConfig    *LNDConfig           `yaml:"lnd" validate:"required_if=Lnclient lnd"`
 	LnInter      *LnInteractiveConfig `yaml:"interactive" validate:"required_if=Lnclient interactive"`
 	LogLevel     string               `yaml:"log_level"`
 	RelayURLs    []string             `yaml:"relay_urls" validate:"required,min=1,dive,url"`
 	NodeInfo     clip.NodeInfo        `yaml:"node_info"`
+
+	// Relays whose NIP-42 AUTH challenges are answered with the nostr key
+	AuthRelays []string `yaml:"auth_relays" validate:"dive,url"`
+
+	// SOCKS5 proxy for the relays and lnd. With the fallback, connections are made
+	// directly if the proxy is down.
+	Proxy         string `yaml:"proxy" validate:"omitempty,url"`
+	ProxyFallback bool   `yaml:"proxy_fallback"`
+
+	// NIP-13 proof of work
+	PowDifficulty    int `yaml:"pow_difficulty" validate:"min=0,max=256"`
+	MinPowDifficulty int `yaml:"min_pow_difficulty" validate:"min=0,max=256"`
+
+	// NIP-77 set reconciliation with the relays
+	Negentropy bool `yaml:"negentropy"`
+
+	// When relays which have stayed unhealthy are skipped
+	RelayHealth clip.RelayHealthPolicy `yaml:"relay_health"`
+
+	// Retries and the number of relays which have to accept a published event
+	Publish clip.PublishPolicy `yaml:"publish"`
+
+	// Number of events requested per query, per relay
+	Pagination clip.Pagination `yaml:"pagination"`
+
+	// Quotas of the events received from the relays, checked before the signatures
+	IngestLimits clip.IngestLimits `yaml:"ingest_limits"`
+
+	// Limits of the events kept in memory, pinned nodes are never evicted
+	StoreLimits clip.StoreLimits `yaml:"store_limits"`
+	PinnedNodes []string         `yaml:"pinned_nodes"`
+
+	Daemon DaemonConfig `yaml:"daemon"`
+}
+
+// DaemonConfig holds the intervals of the daemon command.
+type DaemonConfig struct {
+	// The signed events are sent to all relays again after this interval plus a
+	// random delay of up to Jitter
+	RepublishInterval time.Duration `yaml:"republish_interval" validate:"min=0"`
+	Jitter            time.Duration `yaml:"jitter" validate:"min=0"`
+
+	// Interval of checking the config file for changes
+	ConfigCheckInterval time.Duration `yaml:"config_check_interval" validate:"min=0"`
 }
 
 // LNDConfig holds the LND node connection settings
@@ -52,6 +98,15 @@ func (c *Config) validate() error {
 		return fmt.Errorf("validating node info: %w", err)
 	}
 
+	if c.ProxyFallback && c.Proxy == "" {
+		return fmt.Errorf("proxy_fallback requires a proxy")
+	}
+
+	if c.Publish.MinSuccessfulRelays > len(c.RelayURLs) {
+		return fmt.Errorf("min_successful_relays %d exceeds the number of relays %d",
+			c.Publish.MinSuccessfulRelays, len(c.RelayURLs))
+	}
+
 	if c.LnInter != nil {
 		if !clip.IsValidNetwork(c.LnInter.Network) {
 			return fmt.Errorf("invalid interactive network: %s", c.LnInter.Network)
@@ -70,25 +125,45 @@ func (c *Config) setDefaults() error {
 		c.KeyStorePath = path
 	}
 
+	if c.DBPath == "" {
+		path, err := defaultDBPath()
+		if err != nil {
+			return err
+		}
+		c.DBPath = path
+	}
+
 	if c.LogLevel == "" {
 		c.LogLevel = "info"
 	}
 
+	if c.Daemon.RepublishInterval == 0 {
+		c.Daemon.RepublishInterval = 24 * time.Hour
+	}
+	if c.Daemon.ConfigCheckInterval == 0 {
+		c.Daemon.ConfigCheckInterval = time.Minute
+	}
+
 	return nil
 }
 
 func loadConfig(c *cli.Context) (*Config, error) {
-	var (
-		configFile string
-		err        error
-	)
+	configFile, err := configPath(c)
+	if err != nil {
+		return nil, err
+	}
+	return loadConfigFile(configFile)
+}
 
+// configPath returns the config file of the flag, or the default path.
+func configPath(c *cli.Context) (string, error) {
 	if c.IsSet("config") {
-		configFile = c.String("config")
-	} else if configFile, err = defaultConfigPath(); err != nil {
-		return nil, err
+		return c.String("config"), nil
 	}
+	return defaultConfigPath()
+}
 
+func loadConfigFile(configFile string) (*Config, error) {
 	b, err := os.ReadFile(configFile)
 	if err != nil {
 		return nil, err
@@ -114,6 +189,63 @@ func loadConfig(c *cli.Context) (*Config, error) {
 	return cfg, nil
 }
 
+// addRelayURLs appends the relays to relay_urls of the config file. The file is
+// edited as a YAML document, so that the comments are kept.
+func addRelayURLs(configFile string, urls []string) error {
+	b, err := os.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(configFile)
+	if err != nil {
+		return err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return fmt.Errorf("unmarshaling config file: %w", err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return fmt.Errorf("config file isn't a YAML mapping")
+	}
+
+	root := doc.Content[0]
+	var seq *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "relay_urls" {
+			seq = root.Content[i+1]
+		}
+	}
+	if seq == nil || seq.Kind != yaml.SequenceNode {
+		return fmt.Errorf("relay_urls not found in config file")
+	}
+
+	// Same quoting as the existing entries
+	style := yaml.DoubleQuotedStyle
+	if len(seq.Content) > 0 {
+		style = seq.Content[len(seq.Content)-1].Style
+	}
+	for _, u := range urls {
+		seq.Content = append(seq.Content, &yaml.Node{
+			Kind:  yaml.ScalarNode,
+			Tag:   "!!str",
+			Value: u,
+			Style: style,
+		})
+	}
+
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return fmt.Errorf("marshaling config file: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("marshaling config file: %w", err)
+	}
+	return os.WriteFile(configFile, out.Bytes(), fi.Mode().Perm())
+}
+
 // DefaultConfigPath returns a reasonable per-user path like
 //
 //	Linux/macOS: $XDG_CONFIG_HOME/.<app>/config.yaml
@@ -121,6 +253,13 @@ func defaultConfigPath() (string, error) {
 	return configDirFilePath("config.yaml")
 }
 
+// defaultDBPath returns the path of the local event database like
+//
+//	Linux/macOS: $XDG_CONFIG_HOME/.<app>/clip.db
+func defaultDBPath() (string, error) {
+	return configDirFilePath("clip.db")
+}
+
 func configDirFilePath(filename string) (string, error) {
 	dir, err := os.UserConfigDir()
 	if err != nil {
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/feelancer21/clip"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Actions of the daemon, printed as JSON lines.
+const (
+	actionRepublish     = "republish"
+	actionPublishInfo   = "publish_info"
+	actionConfigChanged = "config_changed"
+	actionError         = "error"
+)
+
+type daemonEvent struct {
+	Time    time.Time                 `json:"time"`
+	Action  string                    `json:"action"`
+	EventID string                    `json:"event_id,omitempty"`
+	Status  []clip.RelayPublishStatus `json:"status,omitempty"`
+	Error   string                    `json:"error,omitempty"`
+}
+
+// logPublish logs the results of a publish, with an error if the quorum isn't met.
+func (a *ClipApp) logPublish(action string, res clip.PublishResult) {
+	report := res.Wait()
+	ev := daemonEvent{Action: action, EventID: report.Event.ID, Status: report.Status}
+	if err := report.Err(); err != nil {
+		ev.Error = err.Error()
+	}
+	a.logDaemon(ev)
+}
+
+func (a *ClipApp) logDaemon(ev daemonEvent) {
+	ev.Time = time.Now().UTC()
+	if err := printJSONLine(ev); err != nil {
+		fmt.Fprintf(os.Stderr, "printing daemon event: %v\n", err)
+	}
+}
+
+func (a *ClipApp) logDaemonError(err error) {
+	a.logDaemon(daemonEvent{Action: actionError, Error: err.Error()})
+}
+
+// Daemon keeps the signed node announcement and node info alive on the relays until
+// it is interrupted. The events are sent again without signing them again. If the
+// node info of the config file changes, it is signed and published.
+func (a *ClipApp) Daemon() error {
+	path, err := configPath(a.ctx)
+	if err != nil {
+		return err
+	}
+	modTime, err := configModTime(path)
+	if err != nil {
+		return err
+	}
+	cfg := a.config
+
+	// Learning the latest versions of our events on the relays first.
+	ctx, cancel := context.WithTimeout(a.ctx.Context, a.ctx.Duration("timeout"))
+	_, err, _ = a.client.CheckOwnEvents(ctx, cfg.RelayURLs)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("checking own events: %w (publish an announcement with pna first)", err)
+	}
+
+	a.publishInfoIfChanged(cfg)
+	a.republish(cfg.RelayURLs)
+
+	republish := time.NewTimer(nextRepublish(cfg.Daemon))
+	defer republish.Stop()
+	check := time.NewTicker(cfg.Daemon.ConfigCheckInterval)
+	defer check.Stop()
+	flush := time.NewTicker(flushInterval)
+	defer flush.Stop()
+
+	for {
+		select {
+		case <-a.ctx.Context.Done():
+			return nil
+
+		case <-flush.C:
+			if err := a.store.Flush(); err != nil {
+				a.logDaemonError(err)
+			}
+
+		case <-republish.C:
+			a.republish(cfg.RelayURLs)
+			republish.Reset(nextRepublish(cfg.Daemon))
+
+		case <-check.C:
+			t, err := configModTime(path)
+			if err != nil {
+				a.logDaemonError(err)
+				continue
+			}
+			if t.Equal(modTime) {
+				continue
+			}
+			modTime = t
+
+			// Only the node info, relays and intervals are reloaded, the other
+			// settings need a restart.
+			newCfg, err := loadConfigFile(path)
+			if err != nil {
+				a.logDaemonError(fmt.Errorf("reloading config: %w", err))
+				continue
+			}
+			a.logDaemon(daemonEvent{Action: actionConfigChanged})
+
+			relaysChanged := !slices.Equal(cfg.RelayURLs, newCfg.RelayURLs)
+			intervalsChanged := cfg.Daemon != newCfg.Daemon
+			cfg = newCfg
+
+			a.publishInfoIfChanged(cfg)
+			if relaysChanged {
+				a.republish(cfg.RelayURLs)
+			}
+			if intervalsChanged {
+				republish.Reset(nextRepublish(cfg.Daemon))
+				check.Reset(cfg.Daemon.ConfigCheckInterval)
+			}
+		}
+	}
+}
+
+// republish sends the latest signed events of our node to the relays.
+func (a *ClipApp) republish(urls []string) {
+	events, err := a.client.LatestOwnEvents()
+	if err != nil {
+		a.logDaemonError(err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(a.ctx.Context, timeoutNostrPublish)
+	defer cancel()
+	for _, ev := range events {
+		a.logPublish(actionRepublish, a.client.Republish(ctx, ev, urls))
+	}
+}
+
+// publishInfoIfChanged signs and publishes the node info of the config if it differs
+// from the latest published one.
+func (a *ClipApp) publishInfoIfChanged(cfg *Config) {
+	content, err := json.Marshal(cfg.NodeInfo)
+	if err != nil {
+		a.logDaemonError(fmt.Errorf("marshaling node info: %w", err))
+		return
+	}
+	if latest := a.latestOwnInfo(); (latest == nil && string(content) == "{}") ||
+		(latest != nil && latest.Content == string(content)) {
+
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(a.ctx.Context, timeoutNostrPublish)
+	defer cancel()
+	res, err := a.client.Publish(ctx, cfg.NodeInfo, clip.KindNodeInfo, cfg.RelayURLs)
+	if err != nil {
+		a.logDaemonError(fmt.Errorf("publishing node info: %w", err))
+		return
+	}
+	a.logPublish(actionPublishInfo, res)
+}
+
+// latestOwnInfo returns the latest node info of our node without opts, nil if there
+// is none. It is found by its 'd' tag, independent of the order of the events.
+func (a *ClipApp) latestOwnInfo() *nostr.Event {
+	events, err := a.client.LatestOwnEvents()
+	if err != nil {
+		return nil
+	}
+	for _, ev := range events {
+		lev, err := clip.NewEventFromNostrRelay(ev)
+		if err != nil {
+			continue
+		}
+		if id, err := lev.GetIdentifier(); err == nil && id.Kind == clip.KindNodeInfo && len(id.Opts) == 0 {
+			return ev
+		}
+	}
+	return nil
+}
+
+// nextRepublish returns the interval plus a random jitter.
+func nextRepublish(cfg DaemonConfig) time.Duration {
+	if cfg.Jitter <= 0 {
+		return cfg.RepublishInterval
+	}
+	return cfg.RepublishInterval + rand.N(cfg.Jitter)
+}
+
+func configModTime(path string) (time.Time, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("checking config file: %w", err)
+	}
+	return fi.ModTime(), nil
+}
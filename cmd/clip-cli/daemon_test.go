@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/coder/websocket"
+	"github.com/feelancer21/clip"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/keyer"
+	"github.com/tv42/zbase32"
+	"github.com/urfave/cli/v2"
+)
+
+// testNode is a Lightning node with an in-memory key, signing like lnd.
+type testNode struct {
+	key *btcec.PrivateKey
+}
+
+func (n *testNode) Close() error { return nil }
+
+func (n *testNode) GetAlias(context.Context, string) (string, error) { return "", nil }
+
+func (n *testNode) GetNodeInfo(context.Context) (clip.NodeInfoResponse, error) {
+	pk := hex.EncodeToString(n.key.PubKey().SerializeCompressed())
+	return clip.NodeInfoResponse{PubKey: pk, Network: "mainnet"}, nil
+}
+
+func (n *testNode) SignMessage(_ context.Context, msg []byte) (string, error) {
+	digest := chainhash.DoubleHashB(append([]byte("Lightning Signed Message:"), msg...))
+	return zbase32.EncodeToString(ecdsa.SignCompact(n.key, digest, true)), nil
+}
+
+// testRelay accepts every event and answers every REQ with EOSE.
+type testRelay struct {
+	url string
+
+	mu        sync.Mutex
+	published []*nostr.Event
+}
+
+func newTestRelay(t *testing.T) *testRelay {
+	t.Helper()
+	r := &testRelay{}
+	srv := httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(srv.Close)
+	r.url = "ws" + strings.TrimPrefix(srv.URL, "http")
+	return r
+}
+
+func (r *testRelay) events() []*nostr.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*nostr.Event(nil), r.published...)
+}
+
+func (r *testRelay) serve(w http.ResponseWriter, req *http.Request) {
+	conn, err := websocket.Accept(w, req, nil)
+	if err != nil {
+		return
+	}
+	defer conn.CloseNow()
+	ctx := req.Context()
+	send := func(msg ...any) {
+		b, _ := json.Marshal(msg)
+		conn.Write(ctx, websocket.MessageText, b)
+	}
+
+	for {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			return
+		}
+		var msg []json.RawMessage
+		if json.Unmarshal(data, &msg) != nil || len(msg) < 2 {
+			continue
+		}
+		var typ string
+		json.Unmarshal(msg[0], &typ)
+		switch typ {
+		case "EVENT":
+			var ev nostr.Event
+			if json.Unmarshal(msg[1], &ev) != nil {
+				continue
+			}
+			r.mu.Lock()
+			r.published = append(r.published, &ev)
+			r.mu.Unlock()
+			send("OK", ev.ID, true, "")
+		case "REQ":
+			var subID string
+			json.Unmarshal(msg[1], &subID)
+			send("EOSE", subID)
+		}
+	}
+}
+
+// newTestApp returns an app with a client of a new node, which has published its
+// announcement to the relay.
+func newTestApp(t *testing.T, relay *testRelay) *ClipApp {
+	t.Helper()
+	key, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := keyer.NewPlainKeySigner(nostr.GeneratePrivateKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := clip.NewClient(t.Context(), signer, &testNode{key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	c := cli.NewContext(cli.NewApp(), flag.NewFlagSet("test", flag.ContinueOnError), nil)
+	c.Context = t.Context()
+	a := &ClipApp{
+		client: client,
+		config: &Config{RelayURLs: []string{relay.url}},
+		ctx:    c,
+	}
+
+	res, err := client.Publish(t.Context(), clip.NodeAnnouncement{}, clip.KindNodeAnnouncement, a.config.RelayURLs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Wait()
+	return a
+}
+
+// publishedInfo returns the node info events published to the relay.
+func publishedInfo(relay *testRelay) []*nostr.Event {
+	var infos []*nostr.Event
+	for _, ev := range relay.events() {
+		if ev.Tags.GetD() != "" && strings.HasPrefix(ev.Tags.GetD(), "1:") {
+			infos = append(infos, ev)
+		}
+	}
+	return infos
+}
+
+func TestPublishInfoIfChanged(t *testing.T) {
+	relay := newTestRelay(t)
+	a := newTestApp(t, relay)
+
+	// Without node info in the config, nothing is published.
+	a.publishInfoIfChanged(a.config)
+	if n := len(publishedInfo(relay)); n != 0 {
+		t.Fatalf("published %d node info without a config", n)
+	}
+
+	about := "first"
+	a.config.NodeInfo = clip.NodeInfo{About: &about}
+	a.publishInfoIfChanged(a.config)
+	infos := publishedInfo(relay)
+	if len(infos) != 1 {
+		t.Fatalf("expected 1 published node info, got %d", len(infos))
+	}
+
+	// Unchanged config: nothing is signed or published.
+	a.publishInfoIfChanged(a.config)
+	if n := len(publishedInfo(relay)); n != 1 {
+		t.Fatalf("expected no publish of the unchanged node info, got %d events", n)
+	}
+
+	// Changed config: one newly signed event. It needs a later created_at to replace
+	// the first one.
+	time.Sleep(time.Second)
+	changed := "second"
+	a.config.NodeInfo = clip.NodeInfo{About: &changed}
+	a.publishInfoIfChanged(a.config)
+	infos = publishedInfo(relay)
+	if len(infos) != 2 {
+		t.Fatalf("expected 2 published node info, got %d", len(infos))
+	}
+	if infos[1].ID == infos[0].ID || !strings.Contains(infos[1].Content, changed) {
+		t.Fatalf("expected a new event with the changed node info, got %s", infos[1].Content)
+	}
+	if latest := a.latestOwnInfo(); latest == nil || latest.ID != infos[1].ID {
+		t.Fatal("latest own node info isn't the published one")
+	}
+}
+
+func TestNextRepublish(t *testing.T) {
+	interval, jitter := time.Hour, 10*time.Minute
+	if d := nextRepublish(DaemonConfig{RepublishInterval: interval}); d != interval {
+		t.Fatalf("expected %v without jitter, got %v", interval, d)
+	}
+
+	seen := make(map[time.Duration]struct{})
+	for range 1000 {
+		d := nextRepublish(DaemonConfig{RepublishInterval: interval, Jitter: jitter})
+		if d < interval || d >= interval+jitter {
+			t.Fatalf("%v not within [%v, %v)", d, interval, interval+jitter)
+		}
+		seen[d] = struct{}{}
+	}
+	if len(seen) < 2 {
+		t.Fatal("no jitter")
+	}
+}
@@ -90,3 +90,19 @@ func loadPrivateKeyPlain(path string) (string, error) {
 func defaultKeyPath() (string, error) {
 	return configDirFilePath("key")
 }
+
+// parseNostrPubKey returns the hex pubkey of an npub. Hex pubkeys are returned as is.
+func parseNostrPubKey(s string) (string, error) {
+	if nostr.IsValidPublicKey(s) {
+		return s, nil
+	}
+
+	prefix, value, err := nip19.Decode(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid npub %s: %w", s, err)
+	}
+	if prefix != "npub" {
+		return "", fmt.Errorf("unexpected key prefix: got %s, want npub", prefix)
+	}
+	return value.(string), nil
+}
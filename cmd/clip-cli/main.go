@@ -55,6 +55,58 @@ func publishNodeInfo(app *ClipApp) error {
 	return app.PublishNodeInfo()
 }
 
+func sendChannelRequest(app *ClipApp) error {
+	return app.SendChannelRequest()
+}
+
+func listChannelRequests(app *ClipApp) error {
+	return app.ListChannelRequests()
+}
+
+func answerChannelRequest(app *ClipApp) error {
+	return app.AnswerChannelRequest()
+}
+
+func history(app *ClipApp) error {
+	return app.History()
+}
+
+func watch(app *ClipApp) error {
+	return app.Watch()
+}
+
+func storeStats(app *ClipApp) error {
+	return app.StoreStats()
+}
+
+func daemon(app *ClipApp) error {
+	return app.Daemon()
+}
+
+func status(app *ClipApp) error {
+	return app.Status()
+}
+
+func relayStatus(app *ClipApp) error {
+	return app.RelayStatus()
+}
+
+func discoverRelays(app *ClipApp) error {
+	return app.DiscoverRelays()
+}
+
+func exportSnapshot(app *ClipApp) error {
+	return app.ExportSnapshot()
+}
+
+func importSnapshot(app *ClipApp) error {
+	return app.ImportSnapshot()
+}
+
+func listRejected(app *ClipApp) error {
+	return app.ListRejected()
+}
+
 func generateKey(c *cli.Context) error {
 	var (
 		filename string
@@ -90,6 +142,8 @@ func run() int {
 	timeoutFlag := &cli.DurationFlag{Name: "timeout", Usage: "maximum time to wait for fetching events.", Value: time.Second * 120}
 	pubkeyFlag := &cli.StringFlag{Name: "pubkey", Usage: "Lightning node public key to filter events by."}
 	showErrorsFlag := &cli.BoolFlag{Name: "show-errors", Usage: "show fetch errors alongside results.", Value: false}
+	offlineFlag := &cli.BoolFlag{Name: "offline", Usage: "answer from the local database without fetching from relays.", Value: false}
+	npubFlag := &cli.StringFlag{Name: "npub", Usage: "nostr public key (npub or hex) to filter events by."}
 
 	app := &cli.App{
 		Name:    "clip-cli",
@@ -122,7 +176,9 @@ func run() int {
 					sinceFlag,
 					timeoutFlag,
 					pubkeyFlag,
+					npubFlag,
 					showErrorsFlag,
+					offlineFlag,
 				},
 			},
 			{
@@ -134,7 +190,13 @@ func run() int {
 					sinceFlag,
 					timeoutFlag,
 					pubkeyFlag,
+					npubFlag,
+					&cli.StringFlag{Name: "network", Usage: "only list node info for this network (e.g. mainnet), or all. (default: network of the connected node)"},
+					&cli.StringFlag{Name: "contact-type", Usage: "only list nodes with a contact of this type (e.g. telegram)."},
+					&cli.StringFlag{Name: "custom-record", Usage: "only list nodes with this custom record key, or key=value."},
+					&cli.StringFlag{Name: "about", Usage: "only list nodes whose about text contains this text (case-insensitive)."},
 					showErrorsFlag,
+					offlineFlag,
 				},
 			},
 			{
@@ -149,6 +211,147 @@ func run() int {
 				Usage:   "Publishes the node information specified in the config to the configured Nostr relays.",
 				Action:  withApp(publishNodeInfo),
 			},
+			{
+				Name:    "sendchannelrequest",
+				Aliases: []string{"scr"},
+				Usage:   "Sends an encrypted channel open request to the operator of a Lightning node.",
+				Action:  withApp(sendChannelRequest),
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "pubkey", Usage: "Lightning node public key of the recipient.", Required: true},
+					&cli.Uint64Flag{Name: "amount", Usage: "requested channel size in satoshis.", Required: true},
+					&cli.Uint64Flag{Name: "push", Usage: "amount in satoshis pushed to the remote side."},
+					&cli.StringFlag{Name: "channel-type", Usage: "requested channel type (e.g., anchors, taproot)."},
+					&cli.StringFlag{Name: "address", Usage: "address of our node (host:port)."},
+					&cli.StringFlag{Name: "message", Usage: "free text message to the recipient."},
+					sinceFlag,
+					timeoutFlag,
+				},
+			},
+			{
+				Name:    "listchannelrequests",
+				Aliases: []string{"lcr"},
+				Usage:   "Lists all channel open requests sent or received, together with their responses.",
+				Action:  withApp(listChannelRequests),
+				Flags: []cli.Flag{
+					sinceFlag,
+					timeoutFlag,
+					showErrorsFlag,
+				},
+			},
+			{
+				Name:    "answerchannelrequest",
+				Aliases: []string{"acr"},
+				Usage:   "Accepts, declines or counters a received channel open request.",
+				Action:  withApp(answerChannelRequest),
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "id", Usage: "ID of the channel request.", Required: true},
+					&cli.StringFlag{Name: "status", Usage: "one of accept, decline or counter.", Required: true},
+					&cli.Uint64Flag{Name: "amount", Usage: "counter-offered channel size in satoshis."},
+					&cli.Uint64Flag{Name: "push", Usage: "counter-offered push amount in satoshis."},
+					&cli.StringFlag{Name: "channel-type", Usage: "counter-offered channel type."},
+					&cli.StringFlag{Name: "message", Usage: "free text message to the requester."},
+					sinceFlag,
+					timeoutFlag,
+				},
+			},
+			{
+				Name:   "history",
+				Usage:  "Shows every version of the node information of a node seen so far, with the changed fields.",
+				Action: withApp(history),
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "pubkey", Usage: "Lightning node public key.", Required: true},
+					sinceFlag,
+					timeoutFlag,
+					showErrorsFlag,
+					offlineFlag,
+				},
+			},
+			{
+				Name:    "listrejected",
+				Aliases: []string{"lr"},
+				Usage:   "Fetches node information and lists the rejected events, grouped by reason and node.",
+				Action:  withApp(listRejected),
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "reason", Usage: "only list events rejected for this reason (e.g. bad_ln_sig, stale)."},
+					sinceFlag,
+					timeoutFlag,
+					pubkeyFlag,
+					showErrorsFlag,
+					offlineFlag,
+				},
+			},
+			{
+				Name:   "watch",
+				Usage:  "Keeps subscriptions open and streams new nodes, updated node info and key changes as JSON lines.",
+				Action: withApp(watch),
+				Flags: []cli.Flag{
+					pubkeyFlag,
+					npubFlag,
+					&cli.StringFlag{Name: "network", Usage: "only watch node info for this network (e.g. mainnet), or all. (default: network of the connected node)"},
+				},
+			},
+			{
+				Name:   "storestats",
+				Usage:  "Shows the number of nodes and events in memory, and the evictions caused by the store limits and the events dropped by the ingest limits of all commands which have used the database.",
+				Action: withApp(storeStats),
+			},
+			{
+				Name:   "daemon",
+				Usage:  "Runs until interrupted and sends the signed node announcement and node info to the relays periodically. Changed node info in the config file is published.",
+				Action: withApp(daemon),
+				Flags: []cli.Flag{
+					timeoutFlag,
+				},
+			},
+			{
+				Name:   "status",
+				Usage:  "Checks which relays have the latest node announcement and node info of the connected node.",
+				Action: withApp(status),
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "heal", Usage: "republish the latest events to the relays which are missing them or have outdated versions."},
+					timeoutFlag,
+					showErrorsFlag,
+				},
+			},
+			{
+				Name:   "relaystatus",
+				Usage:  "Shows the health of the relays recorded while fetching and publishing.",
+				Action: withApp(relayStatus),
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "configured", Usage: "only list the relays of the config file."},
+				},
+			},
+			{
+				Name: "discoverrelays",
+				Usage: "Finds relays in the node announcements, the NIP-65 relay lists of their npubs and the " +
+					"relays used before, and ranks them by the number of announced nodes they hold.",
+				Action: withApp(discoverRelays),
+				Flags: []cli.Flag{
+					sinceFlag,
+					timeoutFlag,
+					showErrorsFlag,
+					&cli.IntFlag{Name: "max", Usage: "maximum number of suggested relays.", Value: 3},
+					&cli.IntFlag{Name: "min-nodes", Usage: "minimum number of announced nodes of a suggested relay.", Value: 1},
+					&cli.BoolFlag{Name: "add", Usage: "add the suggested relays to relay_urls of the config file."},
+				},
+			},
+			{
+				Name:   "export",
+				Usage:  "Writes the verified node announcements and node info of the local database as JSON lines.",
+				Action: withApp(exportSnapshot),
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "out", Usage: "name of the snapshot file, stdout if not set."},
+				},
+			},
+			{
+				Name:      "import",
+				Usage:     "Verifies the events of a snapshot file and stores them in the local database.",
+				ArgsUsage: "<snapshot file>",
+				Action:    withApp(importSnapshot),
+				Flags: []cli.Flag{
+					showErrorsFlag,
+				},
+			},
 		},
 	}
 
@@ -3,10 +3,11 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 
 	"github.com/feelancer21/clip"
-	"github.com/nbd-wtf/go-nostr"
 )
 
 func printJSON[T any](resp T) error {
@@ -26,40 +27,34 @@ func printJSON[T any](resp T) error {
 	return nil
 }
 
-type publishStatus struct {
-	RelayURL   string `json:"relay_url"`
-	Error      string `json:"error,omitempty"`
-	Successful bool   `json:"successful"`
+// printJSONLine prints resp as a single line of JSON.
+func printJSONLine[T any](resp T) error {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(append(b, '\n'))
+	return err
 }
 
 type publishSummary[T any] struct {
-	Payload T               `json:"payload"`
-	Event   *nostr.Event    `json:"event"`
-	Status  []publishStatus `json:"status"`
+	Payload T `json:"payload"`
+	*clip.PublishReport
 }
 
+// printPublishResults waits for the results of all relays and prints them. It
+// returns an error if the event wasn't accepted by enough relays.
 func printPublishResults[T any](res clip.PublishResult, payload T) error {
-	var status []publishStatus
-
-	for pr := range res.Channel {
-		s := publishStatus{
-			RelayURL:   pr.RelayURL,
-			Successful: pr.Error == nil,
-		}
-		if pr.Error != nil {
-			s.Error = pr.Error.Error()
-		}
-		status = append(status, s)
+	report := res.Wait()
+	if err := printJSON(publishSummary[T]{Payload: payload, PublishReport: report}); err != nil {
+		return err
 	}
-
-	return printJSON(publishSummary[T]{
-		Payload: payload,
-		Event:   res.Event,
-		Status:  status,
-	})
+	return report.Err()
 }
 
 func printSliceJSON[T any](items []T, errors []error, showErrors bool) error {
+	warnTruncation(errors)
+	warnDropped(errors)
 
 	var errStrings []string
 	var cntErrors *int
@@ -85,3 +80,27 @@ func printSliceJSON[T any](items []T, errors []error, showErrors bool) error {
 		NumErr:   cntErrors,
 	})
 }
+
+// warnTruncation prints the warnings about relays capping the number of events per
+// query to stderr, also without show-errors.
+func warnTruncation(errs []error) {
+	for _, err := range errs {
+		if errors.Is(err, clip.ErrRelayTruncates) {
+			fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+		}
+	}
+}
+
+// warnDropped prints the number of events dropped because of the ingest limits to
+// stderr, also without show-errors.
+func warnDropped(errs []error) {
+	var dropped int
+	for _, err := range errs {
+		if errors.Is(err, clip.ErrIngestLimit) {
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		fmt.Fprintf(os.Stderr, "warning: %d events dropped because of the ingest limits\n", dropped)
+	}
+}
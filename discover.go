@@ -0,0 +1,328 @@
+package clip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	// Maximum number of candidates probed by DiscoverRelays. Candidates mentioned
+	// by more nodes are preferred, the given relays are always probed.
+	MaxDiscoverCandidates = 100
+
+	// Number of candidates probed at the same time
+	discoverConcurrency = 10
+)
+
+// Maximum time of the queries of a relay by DiscoverRelays, so that a relay which
+// doesn't answer doesn't hold up the others
+var discoverTimeout = 20 * time.Second
+
+// RelaySource is where a candidate relay of DiscoverRelays was found.
+type RelaySource string
+
+const (
+	// Relay given to DiscoverRelays, usually a configured one
+	SourceConfigured RelaySource = "configured"
+
+	// Relay announced in the 'r' tags of a node announcement
+	SourceAnnouncement RelaySource = "announcement"
+
+	// Write relay in the NIP-65 relay list of a bound npub
+	SourceRelayList RelaySource = "relay_list"
+
+	// Relay from which node announcements have been received before
+	SourceFound RelaySource = "found"
+)
+
+// RelayCandidate is a relay found by DiscoverRelays, with the result of the probe.
+type RelayCandidate struct {
+	URL     string        `json:"url"`
+	Sources []RelaySource `json:"sources"`
+
+	// Number of nodes whose announcement or npub refers to the relay
+	Mentions int `json:"mentions"`
+
+	// Set if the relay answered the query for node announcements until EOSE
+	Supported bool   `json:"supported"`
+	Error     string `json:"error,omitempty"`
+
+	// Set if the relay holds more announcements than could be fetched within the
+	// timeout. Nodes counts the ones received until then.
+	Truncated bool `json:"truncated,omitempty"`
+
+	// Number of nodes with a valid announcement on the relay, and the share of
+	// all nodes found on any candidate or in the store
+	Nodes    int     `json:"nodes"`
+	Coverage float64 `json:"coverage"`
+
+	Latency time.Duration `json:"latency"`
+}
+
+// DiscoverRelays collects candidate relays from the given relays, the relay hints
+// of the stored node announcements, the NIP-65 relay lists of the bound npubs and
+// the relays which have sent announcements before. Every candidate is asked for the
+// node announcements, and the candidates are returned sorted by the number of nodes
+// with a valid announcement. The announcements of the candidates are checked like
+// received events, also against the ingest limits, but not stored. Announcements should be synced before,
+// e.g. with GetEvents. Errors of the relay lists are returned in fetchErrors.
+func (c *Client) DiscoverRelays(ctx context.Context, urls []string) ([]RelayCandidate, error, []error) {
+	candidates := make(map[string]*RelayCandidate)
+	mentioned := make(map[string]map[string]struct{})
+	add := func(url string, src RelaySource, pubkey string) {
+		url = nostr.NormalizeURL(url)
+		cand, ok := candidates[url]
+		if !ok {
+			cand = &RelayCandidate{URL: url}
+			candidates[url] = cand
+			mentioned[url] = make(map[string]struct{})
+		}
+		if !slices.Contains(cand.Sources, src) {
+			cand.Sources = append(cand.Sources, src)
+		}
+		if pubkey != "" {
+			mentioned[url][pubkey] = struct{}{}
+		}
+	}
+
+	for _, u := range urls {
+		add(u, SourceConfigured, "")
+	}
+
+	// The npubs bound to the nodes, for the relay lists
+	known := make(map[string]struct{})
+	nodeOfNpub := make(map[string]string)
+	for _, ann := range c.store.GetEvents(KindNodeAnnouncement, nil) {
+		id, err := ann.GetIdentifier()
+		if err != nil {
+			continue
+		}
+		known[id.PubKey] = struct{}{}
+		nodeOfNpub[ann.NostrEvent.PubKey] = id.PubKey
+		for _, relay := range c.countedRelayHints(ann) {
+			add(relay, SourceAnnouncement, id.PubKey)
+		}
+	}
+
+	lists, fetchErrors := c.fetchRelayLists(ctx, urls, nodeOfNpub)
+	if ctx.Err() != nil {
+		return nil, ctx.Err(), nil
+	}
+	for npub, relays := range lists {
+		for _, relay := range relays {
+			add(relay, SourceRelayList, nodeOfNpub[npub])
+		}
+	}
+
+	for _, h := range c.RelayHealth() {
+		if h.Events[KindNodeAnnouncement] > 0 {
+			add(h.URL, SourceFound, "")
+		}
+	}
+
+	list := make([]*RelayCandidate, 0, len(candidates))
+	for url, cand := range candidates {
+		cand.Mentions = len(mentioned[url])
+		list = append(list, cand)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		ci := slices.Contains(list[i].Sources, SourceConfigured)
+		cj := slices.Contains(list[j].Sources, SourceConfigured)
+		if ci != cj {
+			return ci
+		}
+		if list[i].Mentions != list[j].Mentions {
+			return list[i].Mentions > list[j].Mentions
+		}
+		return list[i].URL < list[j].URL
+	})
+	if len(list) > max(MaxDiscoverCandidates, len(urls)) {
+		list = list[:max(MaxDiscoverCandidates, len(urls))]
+	}
+
+	// Probing the candidates, and counting the nodes over all of them.
+	nodes := make([]map[string]struct{}, len(list))
+	checks := &probeChecks{valid: make(map[string]*Identifier)}
+	sem := make(chan struct{}, discoverConcurrency)
+	var wg sync.WaitGroup
+	for i, cand := range list {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			nodes[i] = c.probeRelay(ctx, cand, checks)
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err(), nil
+	}
+
+	for _, n := range nodes {
+		for pk := range n {
+			known[pk] = struct{}{}
+		}
+	}
+	result := make([]RelayCandidate, len(list))
+	for i, cand := range list {
+		if len(known) > 0 {
+			cand.Coverage = float64(cand.Nodes) / float64(len(known))
+		}
+		result[i] = *cand
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Nodes != result[j].Nodes {
+			return result[i].Nodes > result[j].Nodes
+		}
+		return result[i].Latency < result[j].Latency
+	})
+	return result, nil, fetchErrors
+}
+
+// probeChecks keeps the results of the checks of the announcements received by
+// the probes, so that an announcement sent by several relays is checked and counted
+// towards the ingest limits only once.
+type probeChecks struct {
+	mu sync.Mutex
+
+	// Identifier of valid events by ID and signature, nil for invalid ones. Relays
+	// may send an event with the ID of another one.
+	valid map[string]*Identifier
+}
+
+// probeRelay fetches the node announcements of the relay and returns the nodes with
+// a valid announcement. If the timeout ends the fetch, the nodes received until then
+// are returned and the candidate is marked as truncated.
+func (c *Client) probeRelay(ctx context.Context, cand *RelayCandidate,
+	checks *probeChecks) map[string]struct{} {
+
+	filter := nostr.Filter{
+		Kinds: []int{KindLightningInformation},
+		Tags:  nostr.TagMap{"k": {strconv.Itoa(int(KindNodeAnnouncement))}},
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, discoverTimeout)
+	defer cancel()
+
+	start := time.Now()
+	events, err, _ := c.fetchPaged(probeCtx, cand.URL, filter)
+	if err != nil {
+		if len(events) == 0 || ctx.Err() != nil || !errors.Is(err, context.DeadlineExceeded) {
+			cand.Error = err.Error()
+			return nil
+		}
+		cand.Truncated = true
+	}
+	cand.Supported = true
+	cand.Latency = time.Since(start)
+
+	nodes := make(map[string]struct{})
+	for _, ev := range events {
+		if id := c.checkProbeEvent(ev, checks); id != nil {
+			nodes[id.PubKey] = struct{}{}
+		}
+	}
+	cand.Nodes = len(nodes)
+	return nodes
+}
+
+// checkProbeEvent returns the identifier of a valid announcement, which isn't
+// dropped by the ingest limits.
+func (c *Client) checkProbeEvent(ev *nostr.Event, checks *probeChecks) *Identifier {
+	key := ev.ID + ev.Sig
+	checks.mu.Lock()
+	id, ok := checks.valid[key]
+	checks.mu.Unlock()
+	if ok {
+		return id
+	}
+
+	if lev, err := NewEventFromNostrRelay(ev); err == nil {
+		if id, err = c.verifyEvent(lev); err != nil {
+			id = nil
+		}
+	}
+	checks.mu.Lock()
+	checks.valid[key] = id
+	checks.mu.Unlock()
+	return id
+}
+
+// fetchRelayLists fetches the NIP-65 relay lists of the npubs from the relays and
+// returns the write relays of the latest valid list per npub.
+func (c *Client) fetchRelayLists(ctx context.Context, urls []string,
+	npubs map[string]string) (map[string][]string, []error) {
+
+	authors := make([]string, 0, len(npubs))
+	for npub := range npubs {
+		authors = append(authors, npub)
+	}
+	sort.Strings(authors)
+
+	var (
+		mu          sync.Mutex
+		latest      = make(map[string]*nostr.Event)
+		fetchErrors []error
+		wg          sync.WaitGroup
+	)
+	for _, u := range c.health.usable(urls) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, discoverTimeout)
+			defer cancel()
+			for start := 0; start < len(authors); start += maxAuthorsPerFilter {
+				end := min(start+maxAuthorsPerFilter, len(authors))
+				events, err := c.fetchFromRelay(ctx, u, nostr.Filter{
+					Kinds:   []int{nostr.KindRelayListMetadata},
+					Authors: authors[start:end],
+				})
+
+				mu.Lock()
+				if err != nil {
+					fetchErrors = append(fetchErrors, fmt.Errorf("fetching relay lists from %s: %v", u, err))
+				}
+				for _, ev := range events {
+					// The relays don't check the signatures, see NewClient.
+					if ok, _ := ev.CheckSignature(); !ok {
+						continue
+					}
+					if prev, ok := latest[ev.PubKey]; !ok || ev.CreatedAt > prev.CreatedAt {
+						latest[ev.PubKey] = ev
+					}
+				}
+				mu.Unlock()
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	lists := make(map[string][]string, len(latest))
+	for npub, ev := range latest {
+		for tag := range ev.Tags.FindAll("r") {
+			if len(lists[npub]) >= MaxRelayHintsPerNode {
+				break
+			}
+			// Relays marked as read only aren't written to by the npub.
+			if len(tag) > 2 && tag[2] == "read" {
+				continue
+			}
+			if isRelayURL(tag[1]) {
+				lists[npub] = append(lists[npub], tag[1])
+			}
+		}
+	}
+	return lists, fetchErrors
+}
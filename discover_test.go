@@ -0,0 +1,153 @@
+package clip
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// newAnnouncements returns the announcements of new nodes, each bound to a new npub.
+func newAnnouncements(t *testing.T, n int) []*nostr.Event {
+	t.Helper()
+	events := make([]*nostr.Event, n)
+	for i := range events {
+		events[i] = newTestEvent(t, newTestNpub(t), newTestNode(t), KindNodeAnnouncement, nostr.Now()-3600)
+	}
+	return events
+}
+
+func discover(t *testing.T, c *Client, relays ...*testRelay) map[string]RelayCandidate {
+	t.Helper()
+	urls := make([]string, len(relays))
+	for i, r := range relays {
+		urls[i] = r.url
+	}
+	candidates, err, fetchErrors := c.DiscoverRelays(withTimeout(t), urls)
+	if err != nil || len(fetchErrors) > 0 {
+		t.Fatalf("discovering relays: %v %v", err, fetchErrors)
+	}
+	if len(candidates) != len(relays) {
+		t.Fatalf("expected %d candidates, got %d", len(relays), len(candidates))
+	}
+	byURL := make(map[string]RelayCandidate, len(candidates))
+	for _, cand := range candidates {
+		byURL[cand.URL] = cand
+	}
+	return byURL
+}
+
+// The candidates are ranked by the number of nodes with a valid announcement, and
+// the coverage is the share of all nodes found.
+func TestDiscoverRelaysRanking(t *testing.T) {
+	c := newTestClient(t, newTestNpub(t), newTestNode(t))
+	anns := newAnnouncements(t, 3)
+
+	full, partial, empty := newTestRelay(t), newTestRelay(t), newTestRelay(t)
+	full.add(anns...)
+	partial.add(anns[0], forge(anns[1]))
+
+	candidates, err, _ := c.DiscoverRelays(withTimeout(t), []string{empty.url, partial.url, full.url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		url      string
+		nodes    int
+		coverage float64
+	}{
+		{full.url, 3, 1},
+		{partial.url, 1, 1.0 / 3},
+		{empty.url, 0, 0},
+	}
+	if len(candidates) != len(want) {
+		t.Fatalf("expected %d candidates, got %d", len(want), len(candidates))
+	}
+	for i, w := range want {
+		cand := candidates[i]
+		if cand.URL != nostr.NormalizeURL(w.url) || cand.Nodes != w.nodes || cand.Coverage != w.coverage {
+			t.Errorf("candidate %d: got %s with %d nodes and coverage %v, want %s with %d and %v",
+				i, cand.URL, cand.Nodes, cand.Coverage, w.url, w.nodes, w.coverage)
+		}
+		if !cand.Supported || cand.Truncated {
+			t.Errorf("candidate %d: supported %v, truncated %v", i, cand.Supported, cand.Truncated)
+		}
+	}
+}
+
+// Announcements dropped by the ingest limits don't count, also if several relays
+// send them.
+func TestDiscoverRelaysIngestLimits(t *testing.T) {
+	c := newTestClient(t, newTestNpub(t), newTestNode(t),
+		WithIngestLimits(IngestLimits{MaxEventsPerNpub: 1}))
+
+	npub := newTestNpub(t)
+	created := nostr.Now() - 3600
+	first := newTestEvent(t, npub, newTestNode(t), KindNodeAnnouncement, created)
+	second := newTestEvent(t, npub, newTestNode(t), KindNodeAnnouncement, created-1)
+
+	r1, r2 := newTestRelay(t), newTestRelay(t)
+	r1.add(first, second)
+	r2.add(first, second)
+
+	for url, cand := range discover(t, c, r1, r2) {
+		if cand.Nodes != 1 {
+			t.Errorf("%s: expected 1 node within the limits, got %d", url, cand.Nodes)
+		}
+	}
+}
+
+// A relay which doesn't answer all pages within the timeout is counted with the
+// announcements received until then.
+func TestDiscoverRelaysTruncated(t *testing.T) {
+	timeout := discoverTimeout
+	discoverTimeout = 500 * time.Millisecond
+	t.Cleanup(func() { discoverTimeout = timeout })
+
+	c := newTestClient(t, newTestNpub(t), newTestNode(t), WithPagination(Pagination{PageSize: 2}))
+	relay := newTestRelay(t)
+	relay.add(newAnnouncements(t, 5)...)
+	relay.stallAfter = 1
+
+	cand := discover(t, c, relay)[nostr.NormalizeURL(relay.url)]
+	if !cand.Supported || !cand.Truncated || cand.Nodes != 2 || cand.Error != "" {
+		t.Fatalf("expected a truncated candidate with 2 nodes, got %+v", cand)
+	}
+}
+
+// Only the write relays of the latest valid relay list of an npub are returned.
+func TestFetchRelayLists(t *testing.T) {
+	c := newTestClient(t, newTestNpub(t), newTestNode(t))
+	npub := newTestNpub(t)
+
+	list := func(created nostr.Timestamp, tags ...nostr.Tag) *nostr.Event {
+		ev := &nostr.Event{Kind: nostr.KindRelayListMetadata, CreatedAt: created, Tags: tags}
+		if err := ev.Sign(npub.sk); err != nil {
+			t.Fatal(err)
+		}
+		return ev
+	}
+	now := nostr.Now()
+	relay := newTestRelay(t)
+	relay.add(
+		list(now-10, nostr.Tag{"r", "wss://old.example.com"}),
+		list(now,
+			nostr.Tag{"r", "wss://both.example.com"},
+			nostr.Tag{"r", "wss://write.example.com", "write"},
+			nostr.Tag{"r", "wss://read.example.com", "read"},
+			nostr.Tag{"r", "https://not-a-relay.example.com"},
+		),
+		forge(list(now+10, nostr.Tag{"r", "wss://forged.example.com"})),
+	)
+
+	lists, fetchErrors := c.fetchRelayLists(withTimeout(t), []string{relay.url},
+		map[string]string{npub.pk: "node"})
+	if len(fetchErrors) > 0 {
+		t.Fatal(fetchErrors)
+	}
+	want := []string{"wss://both.example.com", "wss://write.example.com"}
+	got := lists[npub.pk]
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
@@ -1,8 +1,10 @@
 package clip
 
 import (
+	"context"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -10,6 +12,7 @@ import (
 	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip13"
 	"github.com/nbd-wtf/go-nostr/nip19"
 	"github.com/tv42/zbase32"
 )
@@ -22,6 +25,8 @@ const (
 
 	KindNodeAnnouncement Kind = 0
 	KindNodeInfo         Kind = 1
+	KindChannelRequest   Kind = 2
+	KindChannelResponse  Kind = 3
 
 	MaxContentSize = 1 * 1024 * 1024 // 1 MB
 
@@ -33,6 +38,19 @@ var (
 	signedMsgPrefix = []byte("Lightning Signed Message:")
 )
 
+// Errors returned by Verify and GetIdentifier. They are wrapped with more details.
+var (
+	ErrFutureEvent     = errors.New("event is too far in the future")
+	ErrIDMismatch      = errors.New("event ID mismatch")
+	ErrContentTooLarge = errors.New("content too large")
+	ErrInvalidNetwork  = errors.New("invalid network")
+	ErrInvalidTagD     = errors.New("missing or invalid 'd' tag")
+	ErrInvalidTagK     = errors.New("missing or invalid 'k' tag")
+	ErrInvalidTagN     = errors.New("invalid 'n' tag")
+	ErrNostrSignature  = errors.New("invalid nostr signature")
+	ErrLnSignature     = errors.New("invalid lightning signature")
+)
+
 type Event struct {
 	NostrEvent *nostr.Event
 
@@ -42,6 +60,10 @@ type Event struct {
 
 	// Identifier for the event
 	id *Identifier
+
+	// Time the event has been received from a relay, zero for now. Set by stores
+	// loading events they have received before.
+	received nostr.Timestamp
 }
 
 func NewEventFromNostrRelay(ev *nostr.Event) (*Event, error) {
@@ -84,7 +106,7 @@ func (e *Event) copyWithoutSig() *nostr.Event {
 
 func (e *Event) Finalize(network string, pubkey string, kind Kind, opts []string) error {
 	ev := e.NostrEvent
-	for _, t := range []string{"d", "k"} {
+	for _, t := range []string{"d", "k", "n"} {
 		if ev.Tags.Find(t) != nil {
 			return fmt.Errorf("event already has a '%s' tag", t)
 		}
@@ -110,10 +132,51 @@ func (e *Event) Finalize(network string, pubkey string, kind Kind, opts []string
 		nostr.Tag{"d", tagD},
 		nostr.Tag{"k", kindStr},
 	)
+	// The network of the 'd' tag again, since relays can only filter by whole tags
+	if kind != KindNodeAnnouncement {
+		ev.Tags = append(ev.Tags, nostr.Tag{"n", network})
+	}
 	e.finalized = true
 	return nil
 }
 
+// DoWork mines a NIP-13 'nonce' tag with the given difficulty. It has to be called
+// before signing. The work is done on Hash, so that the nonce is covered by the
+// Lightning signature, which is added after the mining.
+func (e *Event) DoWork(ctx context.Context, difficulty int) error {
+	if e.NostrEvent.Tags.Find("sig") != nil {
+		return fmt.Errorf("event already has a 'sig' tag")
+	}
+	if e.NostrEvent.Tags.Find("nonce") != nil {
+		return fmt.Errorf("event already has a 'nonce' tag")
+	}
+
+	tag, err := nip13.DoWork(ctx, *e.copyWithoutSig(), difficulty)
+	if err != nil {
+		return err
+	}
+	e.NostrEvent.Tags = append(e.NostrEvent.Tags, tag)
+	return nil
+}
+
+// PowDifficulty returns the NIP-13 difficulty committed in the 'nonce' tag. Like
+// in NIP-13, it is 0 if the work doesn't reach the committed target. The work is
+// measured on Hash, which is the nostr ID for events without a 'sig' tag.
+func (e *Event) PowDifficulty() int {
+	nonce := e.NostrEvent.Tags.Find("nonce")
+	if nonce == nil || len(nonce) < 3 {
+		return 0
+	}
+	target, err := strconv.Atoi(nonce[2])
+	if err != nil {
+		return 0
+	}
+	if nip13.Difficulty(string(e.Hash())) < target {
+		return 0
+	}
+	return target
+}
+
 func (e *Event) IsFinalized() bool {
 	return e.finalized
 }
@@ -121,18 +184,18 @@ func (e *Event) IsFinalized() bool {
 func (e *Event) Verify() (bool, error) {
 	createdAtLimitUpper := nostr.Now() + EventGracePeriodSeconds
 	if e.NostrEvent.CreatedAt > createdAtLimitUpper {
-		return false, fmt.Errorf("event is too far in the future")
+		return false, ErrFutureEvent
 	}
 
 	// See https://github.com/nbd-wtf/go-nostr/pull/119
 	if e.NostrEvent.ID != e.NostrEvent.GetID() {
-		return false, fmt.Errorf("event ID mismatch")
+		return false, ErrIDMismatch
 	}
 
 	// Checking that the content size is within limits
 	if len(e.NostrEvent.Content) > MaxContentSize {
-		return false, fmt.Errorf("content size exceeds (%d bytes) maximum limit (%d bytes)",
-			len(e.NostrEvent.Content), MaxContentSize)
+		return false, fmt.Errorf("%w: content size exceeds (%d bytes) maximum limit (%d bytes)",
+			ErrContentTooLarge, len(e.NostrEvent.Content), MaxContentSize)
 	}
 
 	// Checking that the public key matches the one in the 'd' tag
@@ -143,25 +206,33 @@ func (e *Event) Verify() (bool, error) {
 
 	if idx.Kind != KindNodeAnnouncement {
 		if !IsValidNetwork(idx.Network) {
-			return false, fmt.Errorf("invalid network: %s", idx.Network)
+			return false, fmt.Errorf("%w: %s", ErrInvalidNetwork, idx.Network)
 		}
 	}
 
 	k := e.NostrEvent.Tags.Find("k")
 	// Integrity checks
 	if k == nil || len(k) < 2 || k[1] != strconv.Itoa(int(idx.Kind)) {
-		return false, fmt.Errorf("missing or invalid 'k' tag")
+		return false, ErrInvalidTagK
+	}
+	// The 'n' tag is optional, events of older versions don't have it.
+	for n := range e.NostrEvent.Tags.FindAll("n") {
+		if idx.Kind == KindNodeAnnouncement || n[1] != idx.Network {
+			return false, ErrInvalidTagN
+		}
 	}
 
 	// Checking nostr signature first
-	if ok, err := e.NostrEvent.CheckSignature(); err != nil || !ok {
-		return false, err
+	if ok, err := e.NostrEvent.CheckSignature(); err != nil {
+		return false, fmt.Errorf("%w: %v", ErrNostrSignature, err)
+	} else if !ok {
+		return false, ErrNostrSignature
 	}
 
 	if e.RequiresLnSignature() {
 		ok, err := e.checkLightningSig(idx.PubKey)
 		if err != nil || !ok {
-			return false, err
+			return false, fmt.Errorf("%w: %v", ErrLnSignature, err)
 		}
 	}
 	return true, nil
@@ -228,17 +299,17 @@ func (e *Event) GetIdentifier() (*Identifier, error) {
 
 	tagD := e.NostrEvent.Tags.Find("d")
 	if tagD == nil || len(tagD) < 2 {
-		return nil, fmt.Errorf("missing or invalid 'd' tag")
+		return nil, ErrInvalidTagD
 	}
 
 	tagK := e.NostrEvent.Tags.Find("k")
 	if tagK == nil || len(tagK) < 2 {
-		return nil, fmt.Errorf("missing or invalid 'k' tag")
+		return nil, ErrInvalidTagK
 	}
 
 	kindInt, err := strconv.Atoi(tagK[1])
 	if err != nil {
-		return nil, fmt.Errorf("invalid kind in 'k' tag: %w", err)
+		return nil, fmt.Errorf("%w: invalid kind: %v", ErrInvalidTagK, err)
 	}
 	kind := Kind(kindInt)
 
@@ -254,7 +325,7 @@ func (e *Event) GetIdentifier() (*Identifier, error) {
 	default:
 		parts := strings.Split(tagD[1], ":")
 		if len(parts) < 3 {
-			return nil, fmt.Errorf("invalid 'd' tag format for kind %d", kind)
+			return nil, fmt.Errorf("%w: invalid format for kind %d", ErrInvalidTagD, kind)
 		}
 		
 		id.PubKey = parts[1]
@@ -266,18 +337,35 @@ func (e *Event) GetIdentifier() (*Identifier, error) {
 	return e.id, nil
 }
 
+// RecipientPubKey returns the nostr pubkey from the 'p' tag, which addresses
+// an encrypted event to a specific recipient.
+func (e *Event) RecipientPubKey() (string, bool) {
+	tagP := e.NostrEvent.Tags.Find("p")
+	if tagP == nil || len(tagP) < 2 {
+		return "", false
+	}
+	return tagP[1], true
+}
+
 type EventEnvelope[T any] struct {
 	Id        *Identifier `json:"id"`
 	Alias     string      `json:"alias"`
 	NostrId   string      `json:"nostr_id"`
 	Npub      string      `json:"npub"`
 	CreatedAt int64       `json:"created_at"`
+	Relays    []string    `json:"relays,omitempty"`
 	Payload   *T          `json:"payload"`
 }
 
 func NewEventEnvelope[T any](ev *Event) (*EventEnvelope[T], error) {
+	return newEventEnvelope[T](ev, ev.NostrEvent.Content)
+}
+
+// newEventEnvelope creates an envelope with the payload parsed from content instead
+// of the event content. Used for encrypted events.
+func newEventEnvelope[T any](ev *Event, content string) (*EventEnvelope[T], error) {
 	var payload T
-	if err := json.Unmarshal([]byte(ev.NostrEvent.Content), &payload); err != nil {
+	if err := json.Unmarshal([]byte(content), &payload); err != nil {
 		return nil, err
 	}
 	id, err := ev.GetIdentifier()
@@ -294,6 +382,7 @@ func NewEventEnvelope[T any](ev *Event) (*EventEnvelope[T], error) {
 		Id:        id,
 		NostrId:   ev.NostrEvent.ID,
 		CreatedAt: int64(ev.NostrEvent.CreatedAt),
+		Relays:    ev.RelayHints(),
 		Npub:      npub,
 		Payload:   &payload,
 	}, nil
@@ -0,0 +1,270 @@
+package clip
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// StoreLimits bounds the memory used by a MapStore. Zero values disable a limit.
+type StoreLimits struct {
+	// Maximum number of nodes
+	MaxNodes int `json:"max_nodes" yaml:"max_nodes" validate:"min=0"`
+
+	// Maximum number of candidate events per node, including the events of
+	// npubs which aren't bound
+	MaxEventsPerNode int `json:"max_events_per_node" yaml:"max_events_per_node" validate:"min=0"`
+
+	// Maximum size of all events in bytes
+	MaxBytes int64 `json:"max_bytes" yaml:"max_bytes" validate:"min=0"`
+
+	// Nodes without an event received within this duration are evicted. Known
+	// events received again count.
+	NodeTTL time.Duration `json:"node_ttl" yaml:"node_ttl" validate:"min=0"`
+}
+
+// EvictReason is the reason why a node has been evicted.
+type EvictReason string
+
+const (
+	EvictExpired   EvictReason = "expired"
+	EvictUnbound   EvictReason = "unbound"
+	EvictNodeLimit EvictReason = "node_limit"
+	EvictByteLimit EvictReason = "byte_limit"
+)
+
+// Nodes are evicted down to this share of the limits, so that the eviction doesn't
+// run for every new node.
+const evictTarget = 0.9
+
+// StoreStats are the metrics of a MapStore.
+type StoreStats struct {
+	Nodes  int   `json:"nodes"`
+	Events int64 `json:"events"`
+	Bytes  int64 `json:"bytes"`
+
+	EvictedNodes  map[EvictReason]uint64 `json:"evicted_nodes"`
+	EvictedEvents uint64                 `json:"evicted_events"`
+}
+
+type evictionStats struct {
+	mu     sync.Mutex
+	nodes  map[EvictReason]uint64
+	events uint64
+}
+
+func (e *evictionStats) addNode(reason EvictReason) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.nodes == nil {
+		e.nodes = make(map[EvictReason]uint64)
+	}
+	e.nodes[reason]++
+}
+
+func (e *evictionStats) addEvent() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.events++
+}
+
+// WithStoreLimits sets the limits of the store.
+func WithStoreLimits(l StoreLimits) MapStoreOption {
+	return func(s *MapStore) {
+		s.limits = l
+	}
+}
+
+// WithPinnedNodes sets nodes which are never evicted. Only their candidates of npubs
+// which aren't bound and their older events are dropped, if a node exceeds
+// MaxEventsPerNode.
+func WithPinnedNodes(pubKeys ...string) MapStoreOption {
+	return func(s *MapStore) {
+		for _, pk := range pubKeys {
+			s.pinned[pk] = struct{}{}
+		}
+	}
+}
+
+// Stats returns the current size of the store and the number of evictions.
+func (s *MapStore) Stats() StoreStats {
+	s.mu.RLock()
+	nodes := len(s.records)
+	s.mu.RUnlock()
+
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+	evicted := make(map[EvictReason]uint64, len(s.stats.nodes))
+	for r, n := range s.stats.nodes {
+		evicted[r] = n
+	}
+
+	return StoreStats{
+		Nodes:         nodes,
+		Events:        s.events.Load(),
+		Bytes:         s.bytes.Load(),
+		EvictedNodes:  evicted,
+		EvictedEvents: s.stats.events,
+	}
+}
+
+// Evict removes the nodes which exceed the limits of the store. It is called when
+// storing events, and should be called periodically if NodeTTL is set.
+//
+// First, all nodes without an event received within NodeTTL are removed. Events
+// received again count, so that nodes which only republish their signed events are
+// kept. If the store still exceeds MaxNodes or MaxBytes, nodes without a bound
+// announcement are removed, and then the nodes received least recently, until the
+// store is below 90% of the limits. Pinned nodes are never removed.
+func (s *MapStore) Evict() {
+	s.evict(time.Now(), evictTarget)
+}
+
+func (s *MapStore) overLimits() bool {
+	if s.limits.MaxBytes > 0 && s.bytes.Load() > s.limits.MaxBytes {
+		return true
+	}
+	if s.limits.MaxNodes <= 0 {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.records) > s.limits.MaxNodes
+}
+
+func (s *MapStore) evict(now time.Time, target float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type entry struct {
+		pubKey      string
+		ns          *nodeState
+		bound       bool
+		lastRefresh nostr.Timestamp
+	}
+
+	var expiry nostr.Timestamp
+	if s.limits.NodeTTL > 0 {
+		expiry = nostr.Timestamp(now.Add(-s.limits.NodeTTL).Unix())
+	}
+
+	var entries []entry
+	for pk, ns := range s.records {
+		if _, ok := s.pinned[pk]; ok {
+			continue
+		}
+		ns.mu.RLock()
+		e := entry{pk, ns, ns.lastAnnouncement.PubKey != "", ns.lastRefresh}
+		ns.mu.RUnlock()
+
+		if e.lastRefresh < expiry {
+			s.removeNode(pk, ns, EvictExpired)
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	maxNodes := int(float64(s.limits.MaxNodes) * target)
+	maxBytes := int64(float64(s.limits.MaxBytes) * target)
+	overNodes := func() bool { return s.limits.MaxNodes > 0 && len(s.records) > maxNodes }
+	overBytes := func() bool { return s.limits.MaxBytes > 0 && s.bytes.Load() > maxBytes }
+	if !overNodes() && !overBytes() {
+		return
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].bound != entries[j].bound {
+			return !entries[i].bound
+		}
+		return entries[i].lastRefresh < entries[j].lastRefresh
+	})
+
+	for _, e := range entries {
+		var reason EvictReason
+		switch {
+		case !overNodes() && !overBytes():
+			return
+		case !e.bound:
+			reason = EvictUnbound
+		case overNodes():
+			reason = EvictNodeLimit
+		default:
+			reason = EvictByteLimit
+		}
+		s.removeNode(e.pubKey, e.ns, reason)
+	}
+}
+
+// removeNode removes a node from the store. The caller has to hold the lock of the
+// store.
+func (s *MapStore) removeNode(pubKey string, ns *nodeState, reason EvictReason) {
+	ns.mu.Lock()
+	ns.evicted = true
+	s.account(ns, -ns.count, -ns.size)
+	if s.journal != nil {
+		s.journal.evicted(pubKey, ns.candidates())
+	}
+	ns.mu.Unlock()
+
+	delete(s.records, pubKey)
+	s.stats.addNode(reason)
+}
+
+// dropCandidate removes a single candidate of the node: first the oldest candidate
+// of an npub which isn't bound, then the oldest event of the bound npub. The bound
+// announcement is never removed. It returns false if there is nothing to remove. The
+// caller has to hold the lock of the node.
+func (s *MapStore) dropCandidate(ns *nodeState) bool {
+	bound := ns.lastAnnouncement.PubKey
+
+	var (
+		victim      *Event
+		victimBound bool
+		remove      func()
+	)
+	consider := func(ev *Event, isBound bool, rm func()) {
+		better := victim == nil ||
+			(victimBound && !isBound) ||
+			(victimBound == isBound && ev.NostrEvent.CreatedAt < victim.NostrEvent.CreatedAt)
+		if better {
+			victim, victimBound, remove = ev, isBound, rm
+		}
+	}
+
+	for npub, ann := range ns.announcements {
+		if npub == bound {
+			continue
+		}
+		consider(ann, false, func() { delete(ns.announcements, npub) })
+	}
+	for npub, events := range ns.events {
+		for tagD, ev := range events {
+			consider(ev, npub == bound, func() { delete(events, tagD) })
+		}
+	}
+	if victim == nil {
+		return false
+	}
+
+	remove()
+	s.account(ns, -1, -eventSize(victim))
+	s.stats.addEvent()
+	if s.journal != nil {
+		s.journal.removed(victim)
+	}
+	return true
+}
+
+// eventSize returns the approximate size of an event in memory.
+func eventSize(ev *Event) int64 {
+	n := len(ev.NostrEvent.ID) + len(ev.NostrEvent.PubKey) + len(ev.NostrEvent.Sig) +
+		len(ev.NostrEvent.Content)
+	for _, tag := range ev.NostrEvent.Tags {
+		for _, v := range tag {
+			n += len(v)
+		}
+	}
+	return int64(n)
+}
@@ -0,0 +1,83 @@
+package clip
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// storeReceived stores the event as received at t.
+func storeReceived(t *testing.T, s Store, nev *nostr.Event, received time.Time) {
+	t.Helper()
+	ev, err := NewEventFromNostrRelay(nev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev.received = nostr.Timestamp(received.Unix())
+	if _, err := s.StoreEvent(ev); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The TTL starts when an event has been received, also a known one, not when it has
+// been created.
+func TestNodeTTLFromReceiveTime(t *testing.T) {
+	node, npub := newTestNode(t), newTestNpub(t)
+	s := NewMapStore(WithStoreLimits(StoreLimits{NodeTTL: time.Hour}))
+	now := time.Now()
+
+	// Signed two days ago and republished since.
+	ann := newTestEvent(t, npub, node, KindNodeAnnouncement, nostr.Timestamp(now.Add(-48*time.Hour).Unix()))
+	storeReceived(t, s, ann, now.Add(-50*time.Minute))
+	s.evict(now, evictTarget)
+	if _, ok := s.GetAnnouncementState(node.pubKey()); !ok {
+		t.Fatal("node evicted by created_at")
+	}
+
+	storeReceived(t, s, ann, now)
+	s.evict(now.Add(30*time.Minute), evictTarget)
+	if _, ok := s.GetAnnouncementState(node.pubKey()); !ok {
+		t.Fatal("node evicted although its event has been received again")
+	}
+
+	s.evict(now.Add(2*time.Hour), evictTarget)
+	if _, ok := s.GetAnnouncementState(node.pubKey()); ok {
+		t.Fatal("node not evicted after the TTL")
+	}
+	if n := s.Stats().EvictedNodes[EvictExpired]; n != 1 {
+		t.Fatalf("expected 1 expired node, got %d", n)
+	}
+}
+
+// The receive times are kept in the database.
+func TestNodeTTLPersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clip.db")
+	node, npub := newTestNode(t), newTestNpub(t)
+	now := time.Now()
+
+	s, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ann := newTestEvent(t, npub, node, KindNodeAnnouncement, nostr.Timestamp(now.Add(-48*time.Hour).Unix()))
+	storeReceived(t, s, ann, now.Add(-50*time.Minute))
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenBoltStore(path, WithStoreLimits(StoreLimits{NodeTTL: time.Hour}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { reopened.Close() })
+	reopened.evict(now, evictTarget)
+	if _, ok := reopened.GetAnnouncementState(node.pubKey()); !ok {
+		t.Fatal("node evicted after loading")
+	}
+	reopened.evict(now.Add(20*time.Minute), evictTarget)
+	if _, ok := reopened.GetAnnouncementState(node.pubKey()); ok {
+		t.Fatal("node not evicted after the TTL since it has been received")
+	}
+}
@@ -0,0 +1,251 @@
+package clip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// RelayHealth records the results of the connections to a relay.
+type RelayHealth struct {
+	URL string `json:"url"`
+
+	// Consecutive failed fetches or publishes, reset by a success
+	Failures      int    `json:"failures"`
+	TotalFailures uint64 `json:"total_failures"`
+	LastError     string `json:"last_error,omitempty"`
+
+	LastFailure time.Time `json:"last_failure,omitzero"`
+	LastSuccess time.Time `json:"last_success,omitzero"`
+
+	// Duration of the last successful fetch until EOSE, or publish until OK
+	Latency time.Duration `json:"latency"`
+
+	// Received events by kind, and events which failed verification
+	Events  map[Kind]uint64 `json:"events,omitempty"`
+	Invalid uint64          `json:"invalid"`
+
+	// Events accepted by the relay
+	Published uint64 `json:"published"`
+}
+
+// RelayStatus summarizes the health of a relay.
+type RelayStatus string
+
+const (
+	RelayHealthy RelayStatus = "healthy"
+
+	// The last connections failed, but the relay is still used
+	RelayDegraded RelayStatus = "degraded"
+
+	// The relay is skipped until RetryAfter has passed
+	RelayUnhealthy RelayStatus = "unhealthy"
+)
+
+// RelayHealthPolicy decides when relays are skipped. Zero values never skip a relay.
+type RelayHealthPolicy struct {
+	// Relays are skipped after this number of consecutive failures
+	SkipAfter int `json:"skip_after_failures" yaml:"skip_after_failures" validate:"min=0"`
+
+	// Skipped relays are tried again after this duration since the last failure
+	RetryAfter time.Duration `json:"retry_after" yaml:"retry_after" validate:"min=0"`
+}
+
+// Status returns the status of the relay under the policy.
+func (h *RelayHealth) Status(p RelayHealthPolicy, now time.Time) RelayStatus {
+	switch {
+	case h.Failures == 0:
+		return RelayHealthy
+	case p.SkipAfter > 0 && h.Failures >= p.SkipAfter && now.Sub(h.LastFailure) < p.RetryAfter:
+		return RelayUnhealthy
+	default:
+		return RelayDegraded
+	}
+}
+
+// RelayHealthStore is implemented by stores which keep the health of the relays
+// between runs.
+type RelayHealthStore interface {
+	GetRelayHealth() ([]*RelayHealth, error)
+	SetRelayHealth(h *RelayHealth) error
+}
+
+// WithRelayHealthPolicy skips relays which have stayed unhealthy when fetching
+// and publishing.
+func WithRelayHealthPolicy(p RelayHealthPolicy) ClientOption {
+	return func(c *Client) {
+		c.health.policy = p
+	}
+}
+
+type relayTracker struct {
+	mu     sync.Mutex
+	relays map[string]*RelayHealth
+
+	store  RelayHealthStore
+	policy RelayHealthPolicy
+}
+
+func newRelayTracker() *relayTracker {
+	return &relayTracker{relays: make(map[string]*RelayHealth)}
+}
+
+func (t *relayTracker) load() error {
+	if t.store == nil {
+		return nil
+	}
+	relays, err := t.store.GetRelayHealth()
+	if err != nil {
+		return fmt.Errorf("loading relay health: %w", err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, h := range relays {
+		t.relays[h.URL] = h
+	}
+	return nil
+}
+
+// update changes the record of a relay and passes it to the store, which may write
+// it with the next batch. Errors of the store are ignored, the record is kept in
+// memory anyway.
+func (t *relayTracker) update(url string, f func(h *RelayHealth)) {
+	url = nostr.NormalizeURL(url)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h, ok := t.relays[url]
+	if !ok {
+		h = &RelayHealth{URL: url}
+		t.relays[url] = h
+	}
+	f(h)
+	if t.store != nil {
+		_ = t.store.SetRelayHealth(h)
+	}
+}
+
+func (t *relayTracker) success(url string, latency time.Duration) {
+	t.update(url, func(h *RelayHealth) {
+		h.Failures = 0
+		h.LastSuccess = time.Now()
+		h.Latency = latency
+	})
+}
+
+func (t *relayTracker) failure(url string, err error) {
+	t.update(url, func(h *RelayHealth) {
+		h.Failures++
+		h.TotalFailures++
+		h.LastFailure = time.Now()
+		h.LastError = err.Error()
+	})
+}
+
+// received counts the events received from a relay and the invalid ones.
+func (t *relayTracker) received(url string, events []*nostr.Event, invalid int) {
+	if len(events) == 0 && invalid == 0 {
+		return
+	}
+	t.update(url, func(h *RelayHealth) {
+		if h.Events == nil {
+			h.Events = make(map[Kind]uint64)
+		}
+		for _, ev := range events {
+			if id, err := (&Event{NostrEvent: ev}).GetIdentifier(); err == nil {
+				h.Events[id.Kind]++
+			}
+		}
+		h.Invalid += uint64(invalid)
+	})
+}
+
+func (t *relayTracker) published(url string, latency time.Duration) {
+	t.update(url, func(h *RelayHealth) {
+		h.Failures = 0
+		h.LastSuccess = time.Now()
+		h.Latency = latency
+		h.Published++
+	})
+}
+
+// usable returns the relays which aren't skipped by the policy. If all relays
+// would be skipped, all are returned, so that an operation is never without relays.
+func (t *relayTracker) usable(urls []string) []string {
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var usable []string
+	for _, u := range urls {
+		h, ok := t.relays[nostr.NormalizeURL(u)]
+		if ok && h.Status(t.policy, now) == RelayUnhealthy {
+			continue
+		}
+		usable = append(usable, u)
+	}
+	if len(usable) == 0 {
+		return urls
+	}
+	return usable
+}
+
+// RelayHealth returns the health of all relays the client has connected to, also
+// in previous runs if the store keeps it, sorted by URL.
+func (c *Client) RelayHealth() []RelayHealth {
+	c.health.mu.Lock()
+	defer c.health.mu.Unlock()
+
+	relays := make([]RelayHealth, 0, len(c.health.relays))
+	for _, h := range c.health.relays {
+		cp := *h
+		cp.Events = maps.Clone(h.Events)
+		relays = append(relays, cp)
+	}
+	sort.Slice(relays, func(i, j int) bool { return relays[i].URL < relays[j].URL })
+	return relays
+}
+
+// RelayHealthPolicy returns the policy deciding when relays are skipped.
+func (c *Client) RelayHealthPolicy() RelayHealthPolicy {
+	return c.health.policy
+}
+
+// isInvalid reports whether a rejected event failed verification. Stale events,
+// events which lost a tie and events of npubs which aren't bound are valid, but not
+// accepted.
+func isInvalid(err error) bool {
+	var rej *RejectedEvent
+	if !errors.As(err, &rej) {
+		return false
+	}
+	return rej.Reason != ReasonStale && rej.Reason != ReasonTieLost && rej.Reason != ReasonPubKeyMismatch
+}
+
+// trackPublish records the results of a publish and forwards them.
+func (c *Client) trackPublish(ctx context.Context, results chan nostr.PublishResult) chan nostr.PublishResult {
+	start := time.Now()
+	out := make(chan nostr.PublishResult)
+	go func() {
+		defer close(out)
+		for res := range results {
+			if res.Error != nil {
+				c.health.failure(res.RelayURL, res.Error)
+			} else {
+				c.health.published(res.RelayURL, time.Since(start))
+			}
+			select {
+			case out <- res:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,101 @@
+package clip
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestRelayHealthUsable(t *testing.T) {
+	tr := newRelayTracker()
+	tr.policy = RelayHealthPolicy{SkipAfter: 2, RetryAfter: time.Hour}
+	a, b := "wss://a.example.com", "wss://b.example.com"
+	var urls []string
+
+	a, b = nostr.NormalizeURL(a), nostr.NormalizeURL(b)
+	urls = []string{a, b}
+
+	tr.failure(a, errors.New("timeout"))
+	if got := tr.usable(urls); !slices.Equal(got, urls) {
+		t.Fatalf("relay skipped after one failure: %v", got)
+	}
+	tr.failure(a, errors.New("timeout"))
+	if got := tr.usable(urls); !slices.Equal(got, []string{b}) {
+		t.Fatalf("expected only %s, got %v", b, got)
+	}
+
+	// If all relays would be skipped, all are used.
+	tr.failure(b, errors.New("timeout"))
+	tr.failure(b, errors.New("timeout"))
+	if got := tr.usable(urls); !slices.Equal(got, urls) {
+		t.Fatalf("expected all relays, got %v", got)
+	}
+
+	// Skipped relays are tried again after RetryAfter, and a success resets them.
+	tr.update(a, func(h *RelayHealth) { h.LastFailure = time.Now().Add(-2 * time.Hour) })
+	if got := tr.usable([]string{a}); len(got) != 1 {
+		t.Fatal("relay still skipped after RetryAfter")
+	}
+	if s := tr.relays[a].Status(tr.policy, time.Now()); s != RelayDegraded {
+		t.Fatalf("expected %s, got %s", RelayDegraded, s)
+	}
+	tr.success(a, time.Second)
+	if h := tr.relays[a]; h.Failures != 0 || h.TotalFailures != 2 || h.Status(tr.policy, time.Now()) != RelayHealthy {
+		t.Fatalf("expected a healthy relay with 2 failures in total, got %+v", h)
+	}
+}
+
+func TestIsInvalid(t *testing.T) {
+	for _, tt := range []struct {
+		err     error
+		invalid bool
+	}{
+		{nil, false},
+		{errors.New("other"), false},
+		{&RejectedEvent{Reason: ReasonNostrSignature}, true},
+		{&RejectedEvent{Reason: ReasonLnSignature}, true},
+		{fmt.Errorf("wrapped: %w", &RejectedEvent{Reason: ReasonInvalidTagD}), true},
+		{&RejectedEvent{Reason: ReasonStale}, false},
+		{&RejectedEvent{Reason: ReasonTieLost}, false},
+		{&RejectedEvent{Reason: ReasonPubKeyMismatch}, false},
+	} {
+		if got := isInvalid(tt.err); got != tt.invalid {
+			t.Errorf("isInvalid(%v) = %v, expected %v", tt.err, got, tt.invalid)
+		}
+	}
+}
+
+// The health is written in batches and loaded by the next process.
+func TestRelayHealthPersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clip.db")
+	store, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := newRelayTracker()
+	tr.store = store
+	url := "wss://relay.example.com"
+	for range 3 {
+		tr.failure(url, errors.New("timeout"))
+	}
+	tr.published(url, time.Second)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := newTestBoltStoreAt(t, path)
+	tr = newRelayTracker()
+	tr.store = reopened
+	if err := tr.load(); err != nil {
+		t.Fatal(err)
+	}
+	h := tr.relays[nostr.NormalizeURL(url)]
+	if h == nil || h.TotalFailures != 3 || h.Failures != 0 || h.Published != 1 || h.LastError != "timeout" {
+		t.Fatalf("unexpected health after reload: %+v", h)
+	}
+}
@@ -0,0 +1,121 @@
+package clip
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/keyer"
+	"github.com/tv42/zbase32"
+)
+
+// testNode is a Lightning node with an in-memory key, signing like lnd.
+type testNode struct {
+	key *btcec.PrivateKey
+}
+
+func newTestNode(t *testing.T) *testNode {
+	t.Helper()
+	key, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testNode{key: key}
+}
+
+func (n *testNode) pubKey() string {
+	return hex.EncodeToString(n.key.PubKey().SerializeCompressed())
+}
+
+func (n *testNode) Close() error { return nil }
+
+func (n *testNode) GetAlias(context.Context, string) (string, error) { return "", nil }
+
+func (n *testNode) GetNodeInfo(context.Context) (NodeInfoResponse, error) {
+	return NodeInfoResponse{PubKey: n.pubKey(), Network: "mainnet"}, nil
+}
+
+func (n *testNode) SignMessage(_ context.Context, msg []byte) (string, error) {
+	digest := chainhash.DoubleHashB(append(signedMsgPrefix, msg...))
+	return zbase32.EncodeToString(ecdsa.SignCompact(n.key, digest, true)), nil
+}
+
+// testNpub is a nostr key.
+type testNpub struct {
+	sk, pk string
+}
+
+func newTestNpub(t *testing.T) testNpub {
+	t.Helper()
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	sk := hex.EncodeToString(b)
+	pk, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testNpub{sk: sk, pk: pk}
+}
+
+func (k testNpub) signer(t *testing.T) nostr.Signer {
+	t.Helper()
+	s, err := keyer.NewPlainKeySigner(k.sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// newTestEvent returns an event of the npub about the node, signed by both keys.
+func newTestEvent(t *testing.T, npub testNpub, node *testNode, kind Kind, createdAt nostr.Timestamp,
+	opts ...string) *nostr.Event {
+
+	t.Helper()
+	content := `{"about":"test node"}`
+	if kind == KindNodeAnnouncement {
+		content = "{}"
+	}
+	return newTestEventWithContent(t, npub, node, kind, createdAt, content, opts...)
+}
+
+func newTestEventWithContent(t *testing.T, npub testNpub, node *testNode, kind Kind,
+	createdAt nostr.Timestamp, content string, opts ...string) *nostr.Event {
+
+	t.Helper()
+	ev := &Event{NostrEvent: &nostr.Event{PubKey: npub.pk, CreatedAt: createdAt, Content: content}}
+	if err := ev.Finalize("mainnet", node.pubKey(), kind, opts); err != nil {
+		t.Fatal(err)
+	}
+	signer := &CombinedSigner{NostrSigner: npub.signer(t), LnSigner: node}
+	if err := signer.SignEvent(context.Background(), ev); err != nil {
+		t.Fatal(err)
+	}
+	return ev.NostrEvent
+}
+
+// forge returns a copy of the event with an invalid nostr signature.
+func forge(ev *nostr.Event) *nostr.Event {
+	forged := *ev
+	sig := []byte(forged.Sig)
+	sig[10] ^= 1
+	forged.Sig = string(sig)
+	return &forged
+}
+
+// newTestClient returns a client for the node and npub with an in-memory store.
+func newTestClient(t *testing.T, npub testNpub, node *testNode, opts ...ClientOption) *Client {
+	t.Helper()
+	c, err := NewClient(context.Background(), npub.signer(t), node, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
@@ -0,0 +1,192 @@
+package clip
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// HistoryStore is implemented by stores which keep every verified version of the
+// events, also the ones which have been replaced by newer events.
+type HistoryStore interface {
+	// GetHistory returns all versions of the events of a kind of a node, sorted by
+	// created_at.
+	GetHistory(kind Kind, pubKey string) ([]*Event, error)
+}
+
+// FieldChange is the change of a single field of a payload. Nested fields are
+// separated by dots, elements of lists are given by their index.
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   any    `json:"old,omitempty"`
+	New   any    `json:"new,omitempty"`
+}
+
+// NodeInfoVersion is a version of the node info of a node with the changes to the
+// previous version with the same 'd' tag, i.e. the same network and opts.
+type NodeInfoVersion struct {
+	EventEnvelope[NodeInfo]
+
+	// Whether the npub is bound to the node by its latest announcement
+	Bound bool `json:"bound"`
+
+	// Whether the npub was bound by the latest announcement created before the
+	// version. Versions of npubs which weren't bound are no previous versions of
+	// the following ones.
+	BoundAtCreation bool `json:"bound_at_creation"`
+
+	Changes []FieldChange `json:"changes"`
+}
+
+var errNoHistory = errors.New("store doesn't keep a history")
+
+// GetNodeInfoHistory returns all versions of the node info of a node which are in the
+// local store, grouped by 'd' tag and sorted by created_at. The versions may be signed
+// by different npubs, if the node has changed its key.
+func (c *Client) GetNodeInfoHistory(ctx context.Context, pubKey string) ([]NodeInfoVersion,
+	error, []error) {
+
+	hs, ok := c.store.(HistoryStore)
+	if !ok {
+		return nil, errNoHistory, nil
+	}
+
+	events, err := hs.GetHistory(KindNodeInfo, pubKey)
+	if err != nil {
+		return nil, fmt.Errorf("getting history: %w", err), nil
+	}
+	announcements, err := hs.GetHistory(KindNodeAnnouncement, pubKey)
+	if err != nil {
+		return nil, fmt.Errorf("getting announcement history: %w", err), nil
+	}
+
+	authors := make(map[string]*nostr.Event, len(events))
+	for _, ev := range events {
+		authors[ev.NostrEvent.ID] = ev.NostrEvent
+	}
+
+	state, _ := c.store.GetAnnouncementState(pubKey)
+	envelopes, fetchErrors := newEventEnvelopes[NodeInfo](c, ctx, events)
+	sort.SliceStable(envelopes, func(i, j int) bool {
+		return envelopes[i].Id.TagD < envelopes[j].Id.TagD
+	})
+
+	versions := make([]NodeInfoVersion, 0, len(envelopes))
+	prev := make(map[string]*NodeInfo)
+	for _, env := range envelopes {
+		ev := authors[env.NostrId]
+		boundAtCreation := boundAt(announcements, ev.CreatedAt) == ev.PubKey
+
+		old, ok := prev[env.Id.TagD]
+		if !ok {
+			old = &NodeInfo{}
+		}
+		changes, err := diffFields(old, env.Payload)
+		if err != nil {
+			fetchErrors = append(fetchErrors, fmt.Errorf("diff of %s: %v", env.NostrId, err))
+		}
+		versions = append(versions, NodeInfoVersion{
+			EventEnvelope:   env,
+			Bound:           ev.PubKey == state.PubKey,
+			BoundAtCreation: boundAtCreation,
+			Changes:         changes,
+		})
+		if boundAtCreation {
+			prev[env.Id.TagD] = env.Payload
+		}
+	}
+	return versions, nil, fetchErrors
+}
+
+// boundAt returns the npub of the latest announcement created at or before t. The
+// announcements are sorted by created_at.
+func boundAt(announcements []*Event, t nostr.Timestamp) string {
+	var latest *nostr.Event
+	for _, ann := range announcements {
+		ev := ann.NostrEvent
+		if ev.CreatedAt > t {
+			break
+		}
+		if latest == nil || Replaces(ev, latest) {
+			latest = ev
+		}
+	}
+	if latest == nil {
+		return ""
+	}
+	return latest.PubKey
+}
+
+// diffFields returns the changed fields of two payloads by comparing their JSON
+// representation.
+func diffFields(old, new any) ([]FieldChange, error) {
+	o, err := flattenJSON(old)
+	if err != nil {
+		return nil, err
+	}
+	n, err := flattenJSON(new)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []FieldChange
+	for field, ov := range o {
+		nv, ok := n[field]
+		if !ok || !reflect.DeepEqual(ov, nv) {
+			changes = append(changes, FieldChange{Field: field, Old: ov, New: nv})
+		}
+	}
+	for field, nv := range n {
+		if _, ok := o[field]; !ok {
+			changes = append(changes, FieldChange{Field: field, New: nv})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes, nil
+}
+
+// flattenJSON returns the leaf values of the JSON representation of v by their path.
+func flattenJSON(v any) (map[string]any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var tree any
+	if err := json.Unmarshal(b, &tree); err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]any)
+	var walk func(path string, v any)
+	walk = func(path string, v any) {
+		join := func(key string) string {
+			if path == "" {
+				return key
+			}
+			return path + "." + key
+		}
+		switch t := v.(type) {
+		case map[string]any:
+			for k, e := range t {
+				walk(join(k), e)
+			}
+		case []any:
+			for i, e := range t {
+				walk(join(strconv.Itoa(i)), e)
+			}
+		case nil:
+		default:
+			fields[path] = t
+		}
+	}
+	walk("", tree)
+	return fields, nil
+}
@@ -0,0 +1,62 @@
+package clip
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestNodeInfoHistory(t *testing.T) {
+	node, owner, other := newTestNode(t), newTestNpub(t), newTestNpub(t)
+	store, err := OpenBoltStore(filepath.Join(t.TempDir(), "clip.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newTestClient(t, owner, node, WithStore(store))
+
+	info := func(npub testNpub, createdAt nostr.Timestamp, about string, opts ...string) *nostr.Event {
+		return newTestEventWithContent(t, npub, node, KindNodeInfo, createdAt,
+			`{"about":"`+about+`"}`, opts...)
+	}
+	events := []*nostr.Event{
+		newTestEvent(t, owner, node, KindNodeAnnouncement, 100),
+		info(owner, 110, "first"),
+		info(owner, 120, "variant", "v1"),
+		info(other, 130, "spam"),
+		info(owner, 140, "second"),
+	}
+	for _, ev := range events {
+		_ = c.processEvent(ev)
+	}
+
+	versions, err, errs := c.GetNodeInfoHistory(t.Context(), node.pubKey())
+	if err != nil || len(errs) > 0 {
+		t.Fatal(err, errs)
+	}
+
+	type want struct {
+		about           string
+		boundAtCreation bool
+		old             any
+	}
+	wants := []want{
+		{"first", true, nil},
+		{"spam", false, "first"},
+		{"second", true, "first"},
+		{"variant", true, nil},
+	}
+	if len(versions) != len(wants) {
+		t.Fatalf("expected %d versions, got %d", len(wants), len(versions))
+	}
+	for i, w := range wants {
+		v := versions[i]
+		if *v.Payload.About != w.about || v.BoundAtCreation != w.boundAtCreation {
+			t.Errorf("version %d: expected %q bound %v, got %q bound %v", i, w.about,
+				w.boundAtCreation, *v.Payload.About, v.BoundAtCreation)
+		}
+		if len(v.Changes) != 1 || v.Changes[0].Field != "about" || v.Changes[0].Old != w.old {
+			t.Errorf("version %d: expected change of about from %v, got %+v", i, w.old, v.Changes)
+		}
+	}
+}
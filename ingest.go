@@ -0,0 +1,272 @@
+package clip
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+var ErrIngestLimit = errors.New("ingest limit exceeded")
+
+// IngestLimits bounds the events of the relays which are checked and stored, so
+// that a flood of events doesn't use up the CPU and the memory. The limits are
+// checked before any signature, but only events with a valid signature count
+// towards the quotas and the rate. The quotas of npubs and nodes without events
+// for a day are forgotten, and at most 100000 npubs and nodes are kept per quota.
+// Zero values disable a limit.
+type IngestLimits struct {
+	// Maximum number of events with a valid signature per npub and minute
+	MaxEventsPerMinute int `json:"max_events_per_minute" yaml:"max_events_per_minute" validate:"min=0"`
+
+	// Maximum number of addresses ('d' tags) per npub, over all nodes. Newer
+	// versions of an event count only once.
+	MaxEventsPerNpub int `json:"max_events_per_npub" yaml:"max_events_per_npub" validate:"min=0"`
+
+	// Maximum number of opts variants of a node per npub
+	MaxOptsPerNode int `json:"max_opts_per_node" yaml:"max_opts_per_node" validate:"min=0"`
+
+	// Maximum number of npubs with events about the same node. The bound npub and
+	// node announcements, which are signed by the node, are always checked.
+	MaxNpubsPerNode int `json:"max_npubs_per_node" yaml:"max_npubs_per_node" validate:"min=0"`
+}
+
+// DropReason is the limit because of which an event has been dropped.
+type DropReason string
+
+const (
+	DropRateLimited   DropReason = "rate_limited"
+	DropNpubQuota     DropReason = "npub_quota"
+	DropOptsQuota     DropReason = "opts_quota"
+	DropNodeNpubQuota DropReason = "node_npub_quota"
+)
+
+// IngestStats are the numbers of events dropped because of the ingest limits.
+// Dropped events aren't kept as rejected events.
+type IngestStats struct {
+	Dropped map[DropReason]uint64 `json:"dropped"`
+}
+
+// DropStore is implemented by stores which count the dropped events of all processes
+// using them, so that the drops of a long-running process can be shown by another.
+type DropStore interface {
+	AddDropped(reason DropReason) error
+	GetDropped() (map[DropReason]uint64, error)
+}
+
+// WithIngestLimits sets the limits of the events received from the relays.
+func WithIngestLimits(l IngestLimits) ClientOption {
+	return func(c *Client) {
+		c.ingest.limits = l
+	}
+}
+
+// IngestStats returns the numbers of dropped events. If the store implements
+// DropStore, these are the drops of all processes using it, otherwise the ones since
+// the client was created.
+func (c *Client) IngestStats() IngestStats {
+	if c.ingest.store != nil {
+		if dropped, err := c.ingest.store.GetDropped(); err == nil {
+			return IngestStats{Dropped: dropped}
+		}
+	}
+
+	c.ingest.mu.Lock()
+	defer c.ingest.mu.Unlock()
+	dropped := make(map[DropReason]uint64, len(c.ingest.dropped))
+	for r, n := range c.ingest.dropped {
+		dropped[r] = n
+	}
+	return IngestStats{Dropped: dropped}
+}
+
+const (
+	// The quotas of npubs and nodes without events for this time are forgotten.
+	ingestTrackerTTL = 24 * time.Hour
+
+	// Maximum number of npubs and nodes kept per quota. Above it, the ones without
+	// events for the longest time are forgotten.
+	maxIngestKeys = 100000
+)
+
+// ingestTracker keeps what counts towards the ingest limits.
+type ingestTracker struct {
+	limits IngestLimits
+
+	mu      sync.Mutex
+	dropped map[DropReason]uint64
+
+	// Counts the drops of all processes if set. Errors of the store are ignored.
+	store DropStore
+
+	// Events per npub in the current minute
+	window time.Time
+	rate   map[string]int
+
+	// Addresses per npub, opts variants per node and npub, npubs per node
+	addresses quotaSets
+	opts      quotaSets
+	npubs     quotaSets
+	pruned    time.Time
+}
+
+func newIngestTracker() *ingestTracker {
+	return &ingestTracker{
+		dropped:   make(map[DropReason]uint64),
+		rate:      make(map[string]int),
+		addresses: make(quotaSets),
+		opts:      make(quotaSets),
+		npubs:     make(quotaSets),
+	}
+}
+
+// admit checks the event of npub against the limits. The npub bound to the node is
+// passed as bound. A dropped event is counted and an error wrapping ErrIngestLimit
+// is returned.
+func (t *ingestTracker) admit(npub string, id *Identifier, bound string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	drop := func(reason DropReason, format string, args ...any) error {
+		t.dropped[reason]++
+		if t.store != nil {
+			_ = t.store.AddDropped(reason)
+		}
+		return fmt.Errorf("%w (%s): %s", ErrIngestLimit, reason, fmt.Sprintf(format, args...))
+	}
+
+	now := time.Now()
+	if limit := t.limits.MaxEventsPerMinute; limit > 0 {
+		t.advanceWindow(now)
+		if t.rate[npub] >= limit {
+			return drop(DropRateLimited, "npub %s sent more than %d events per minute", npub, limit)
+		}
+	}
+
+	// Newer versions of an address are always checked.
+	addresses := t.addresses.get(npub, now)
+	if _, ok := addresses[id.TagD]; ok {
+		return nil
+	}
+	if limit := t.limits.MaxEventsPerNpub; limit > 0 && len(addresses) >= limit {
+		return drop(DropNpubQuota, "npub %s has %d events", npub, limit)
+	}
+	key := id.PubKey + ":" + npub
+	if limit := t.limits.MaxOptsPerNode; limit > 0 && len(id.Opts) > 0 && len(t.opts.get(key, now)) >= limit {
+		return drop(DropOptsQuota, "npub %s has %d opts variants of node %s", npub, limit, id.PubKey)
+	}
+	npubs := t.npubs.get(id.PubKey, now)
+	_, known := npubs[npub]
+	if limit := t.limits.MaxNpubsPerNode; limit > 0 && !known && npub != bound &&
+		id.Kind != KindNodeAnnouncement && len(npubs) >= limit {
+		return drop(DropNodeNpubQuota, "node %s has events of %d npubs", id.PubKey, limit)
+	}
+	return nil
+}
+
+// within reports whether an event of npub is within the quota of the addresses of
+// npub, without counting it. Events already counted are within it.
+func (t *ingestTracker) within(npub string, id *Identifier) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	limit := t.limits.MaxEventsPerNpub
+	if limit == 0 {
+		return true
+	}
+	var addresses map[string]struct{}
+	if s, ok := t.addresses[npub]; ok {
+		addresses = s.values
+	}
+	if _, ok := addresses[id.TagD]; ok {
+		return true
+	}
+	return len(addresses) < limit
+}
+
+// record counts an event of npub with a valid signature towards the quotas and the
+// rate.
+func (t *ingestTracker) record(npub string, id *Identifier) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if t.limits.MaxEventsPerMinute > 0 {
+		t.advanceWindow(now)
+		t.rate[npub]++
+	}
+	t.addresses.add(npub, id.TagD, now)
+	if len(id.Opts) > 0 {
+		t.opts.add(id.PubKey+":"+npub, id.TagD, now)
+	}
+	if id.Kind != KindNodeAnnouncement {
+		t.npubs.add(id.PubKey, npub, now)
+	}
+
+	if now.Sub(t.pruned) >= time.Minute || len(t.addresses) > maxIngestKeys ||
+		len(t.opts) > maxIngestKeys || len(t.npubs) > maxIngestKeys {
+
+		t.addresses.prune(now)
+		t.opts.prune(now)
+		t.npubs.prune(now)
+		t.pruned = now
+	}
+}
+
+// advanceWindow starts a new minute of the rate limit if the current one is over.
+func (t *ingestTracker) advanceWindow(now time.Time) {
+	if now.Sub(t.window) >= time.Minute {
+		t.window = now
+		clear(t.rate)
+	}
+}
+
+// quotaSets are the values per key which count towards a quota, e.g. the addresses
+// per npub, with the time of the last event of each key.
+type quotaSets map[string]*quotaSet
+
+type quotaSet struct {
+	values map[string]struct{}
+	used   time.Time
+}
+
+// get returns the values of the key and marks the key as used.
+func (m quotaSets) get(key string, now time.Time) map[string]struct{} {
+	s, ok := m[key]
+	if !ok {
+		return nil
+	}
+	s.used = now
+	return s.values
+}
+
+func (m quotaSets) add(key, value string, now time.Time) {
+	s, ok := m[key]
+	if !ok {
+		s = &quotaSet{values: make(map[string]struct{})}
+		m[key] = s
+	}
+	s.values[value] = struct{}{}
+	s.used = now
+}
+
+// prune forgets the keys which haven't been used within ingestTrackerTTL. Above
+// maxIngestKeys, the least recently used keys are forgotten down to 90% of it, so
+// that the keys aren't sorted for every new one.
+func (m quotaSets) prune(now time.Time) {
+	for key, s := range m {
+		if now.Sub(s.used) >= ingestTrackerTTL {
+			delete(m, key)
+		}
+	}
+	if len(m) <= maxIngestKeys {
+		return
+	}
+	keys := slices.Collect(maps.Keys(m))
+	slices.SortFunc(keys, func(a, b string) int { return m[a].used.Compare(m[b].used) })
+	for _, key := range keys[:len(keys)-maxIngestKeys*9/10] {
+		delete(m, key)
+	}
+}
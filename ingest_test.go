@@ -0,0 +1,142 @@
+package clip
+
+import (
+	"errors"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Forged events of an npub don't use up its rate.
+func TestIngestRateCountsValidEvents(t *testing.T) {
+	node, npub := newTestNode(t), newTestNpub(t)
+	c := newTestClient(t, npub, node, WithIngestLimits(IngestLimits{MaxEventsPerMinute: 1}))
+
+	ann := newTestEvent(t, npub, node, KindNodeAnnouncement, nostr.Now()-100)
+	for range 3 {
+		if err := c.processEvent(forge(ann)); ReasonOf(err) != ReasonNostrSignature {
+			t.Fatalf("expected a bad_nostr_sig rejection, got %v", err)
+		}
+	}
+	if err := c.processEvent(ann); err != nil {
+		t.Fatalf("valid event not accepted: %v", err)
+	}
+
+	info := newTestEvent(t, npub, node, KindNodeInfo, nostr.Now()-100)
+	if err := c.processEvent(info); !errors.Is(err, ErrIngestLimit) {
+		t.Fatalf("expected the second valid event to be dropped, got %v", err)
+	}
+}
+
+// The sync point of a relay isn't advanced past dropped events.
+func TestSyncPointNotPastDroppedEvents(t *testing.T) {
+	node, npub := newTestNode(t), newTestNpub(t)
+	store := newTestBoltStore(t)
+	c := newTestClient(t, npub, node, WithStore(store),
+		WithIngestLimits(IngestLimits{MaxEventsPerNpub: 1}))
+
+	created := nostr.Now() - 3600
+	info := newTestEvent(t, npub, node, KindNodeInfo, created+10)
+	relay := newTestRelay(t)
+	relay.add(newTestEvent(t, npub, node, KindNodeAnnouncement, created), info)
+
+	_, err, fetchErrors := c.GetEvents(withTimeout(t), KindNodeInfo, nil, []string{relay.url}, time.Unix(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fetchErrors) != 1 || !errors.Is(fetchErrors[0], ErrIngestLimit) {
+		t.Fatalf("expected the node info to be dropped, got %v", fetchErrors)
+	}
+
+	key := func(kind Kind) string {
+		return syncKey(nostr.Filter{
+			Kinds: []int{KindLightningInformation},
+			Tags:  nostr.TagMap{"k": {strconv.Itoa(int(kind))}},
+		})
+	}
+	relayURL := nostr.NormalizeURL(relay.url)
+	if sp, ok := store.GetSyncPoint(relayURL, key(KindNodeAnnouncement)); !ok || sp.Until <= info.CreatedAt {
+		t.Fatalf("sync point of the announcements not advanced: %+v", sp)
+	}
+	if sp, ok := store.GetSyncPoint(relayURL, key(KindNodeInfo)); !ok || sp.Until != info.CreatedAt {
+		t.Fatalf("expected the sync point of the node info to end at %d, got %+v", info.CreatedAt, sp)
+	}
+}
+
+// The quotas forget unused keys and keep at most maxIngestKeys.
+func TestQuotaSetsPrune(t *testing.T) {
+	m := make(quotaSets)
+	start := time.Now()
+	for i := range maxIngestKeys + 1 {
+		m.add(strconv.Itoa(i), "d", start.Add(time.Duration(i)*time.Millisecond))
+	}
+
+	m.prune(start.Add(time.Hour))
+	if len(m) > maxIngestKeys {
+		t.Fatalf("%d keys kept, at most %d expected", len(m), maxIngestKeys)
+	}
+	if _, ok := m["0"]; ok {
+		t.Fatal("least recently used key kept")
+	}
+	if _, ok := m[strconv.Itoa(maxIngestKeys)]; !ok {
+		t.Fatal("most recently used key forgotten")
+	}
+
+	// Used keys are kept, the others expire.
+	last := strconv.Itoa(maxIngestKeys)
+	m.get(last, start.Add(ingestTrackerTTL))
+	m.prune(start.Add(ingestTrackerTTL + time.Hour))
+	if len(m) != 1 || m[last] == nil {
+		t.Fatalf("expected only the used key, got %d keys", len(m))
+	}
+}
+
+// The drops and evictions of a process are shown by another one using the database.
+func TestCountersPersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clip.db")
+	node, npub := newTestNode(t), newTestNpub(t)
+
+	store, err := OpenBoltStore(path, WithStoreLimits(StoreLimits{MaxNodes: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newTestClient(t, npub, node, WithStore(store),
+		WithIngestLimits(IngestLimits{MaxEventsPerNpub: 1}))
+
+	created := nostr.Now() - 100
+	if err := c.processEvent(newTestEvent(t, npub, node, KindNodeAnnouncement, created)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.processEvent(newTestEvent(t, npub, node, KindNodeInfo, created)); !errors.Is(err, ErrIngestLimit) {
+		t.Fatalf("expected the node info to be dropped, got %v", err)
+	}
+	other := newTestNode(t)
+	if err := c.processEvent(newTestEvent(t, newTestNpub(t), other, KindNodeAnnouncement, created+1)); err != nil {
+		t.Fatal(err)
+	}
+	store.Evict()
+	evicted := store.Stats().EvictedNodes[EvictNodeLimit]
+	if evicted == 0 {
+		t.Fatal("no node evicted")
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second close doesn't count again.
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := newTestBoltStoreAt(t, path)
+	c2 := newTestClient(t, npub, node, WithStore(reopened))
+	if got := c2.IngestStats().Dropped[DropNpubQuota]; got != 1 {
+		t.Fatalf("expected 1 dropped event, got %d", got)
+	}
+	if got := reopened.Stats().EvictedNodes[EvictNodeLimit]; got != evicted {
+		t.Fatalf("expected %d evicted nodes, got %d", evicted, got)
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"encoding/hex"
 	"fmt"
+	"net"
 	"os"
+	"strconv"
 
 	"github.com/lightningnetwork/lnd/lnrpc"
 	"google.golang.org/grpc"
@@ -33,8 +35,29 @@ type LND struct {
 	client lnrpc.LightningClient
 }
 
+// LNDOption configures optional behaviour of the connection to LND.
+type LNDOption func(*lndOptions)
+
+type lndOptions struct {
+	proxy *ProxyDialer
+}
+
+// WithLNDProxy connects to LND through the proxy. The host is resolved by the
+// proxy, so it can be an onion service. A local LND, e.g. on localhost or a private
+// address, is connected to directly.
+func WithLNDProxy(d *ProxyDialer) LNDOption {
+	return func(o *lndOptions) {
+		o.proxy = d
+	}
+}
+
 func NewLND(tlsCertPath string, macaroonPath string, host string,
-	port int) (*LND, error) {
+	port int, opts ...LNDOption) (*LND, error) {
+
+	var o lndOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
 
 	// Read TLS certificate
 	tlsCert, err := credentials.NewClientTLSFromFile(tlsCertPath, "")
@@ -48,14 +71,25 @@ func NewLND(tlsCertPath string, macaroonPath string, host string,
 		return nil, fmt.Errorf("reading macaroon: %w", err)
 	}
 
-	// Create gRPC connection // Dial is deprecated!
-	conn, err := grpc.NewClient(
-		fmt.Sprintf("%s:%d", host, port),
+	target := net.JoinHostPort(host, strconv.Itoa(port))
+	dialOpts := []grpc.DialOption{
 		grpc.WithTransportCredentials(tlsCert),
 		grpc.WithPerRPCCredentials(&MacaroonCredential{
 			MacaroonHex: hex.EncodeToString(macBytes),
 		}),
-	)
+	}
+	if o.proxy != nil {
+		// Passing the host name to the dialer instead of resolving it locally.
+		target = "passthrough:///" + target
+		dialOpts = append(dialOpts, grpc.WithContextDialer(func(ctx context.Context,
+			addr string) (net.Conn, error) {
+
+			return o.proxy.DialContext(ctx, "tcp", addr)
+		}))
+	}
+
+	// Create gRPC connection // Dial is deprecated!
+	conn, err := grpc.NewClient(target, dialOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("creating gRPC channel to LND: %w", err)
 	}
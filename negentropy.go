@@ -0,0 +1,378 @@
+package clip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip77"
+	"github.com/nbd-wtf/go-nostr/nip77/negentropy"
+	"github.com/nbd-wtf/go-nostr/nip77/negentropy/storage/vector"
+)
+
+const (
+	// Relays without NIP-77 support ignore NEG-OPEN or answer with a notice about
+	// it, so a relay which doesn't answer within this time is considered unsupported.
+	negentropyTimeout = 10 * time.Second
+
+	// Maximum size of a negentropy message, like go-nostr
+	negentropyFrameSize = 1024 * 1024
+
+	// Number of missing events requested per filter
+	negentropyBatchSize = 100
+
+	// Time after which NIP-77 is tried again with a relay found not to support it,
+	// in case it has been upgraded or the error has been transient
+	negentropyRetryAfter = time.Hour
+)
+
+var errNegentropyUnsupported = errors.New("relay doesn't support negentropy")
+
+// RawEventStore is implemented by stores which return all stored events, also the
+// ones which aren't accepted. They are used as the local set for the reconciliation,
+// otherwise only the accepted events are, and the others are downloaded on every sync.
+type RawEventStore interface {
+	GetRawEvents(filter nostr.Filter) []*nostr.Event
+}
+
+// WithNegentropy reconciles the events of the store with the relays using NIP-77
+// before fetching, so that only missing or newer events are downloaded. Relays
+// without NIP-77 support are synced by fetching all events of the filter.
+func WithNegentropy() ClientOption {
+	return func(c *Client) {
+		c.negentropy = true
+	}
+}
+
+// syncStoreNegentropy reconciles the local events matching the filter with every
+// relay and fetches the events which are missing locally. Relays which don't
+// support NIP-77 are remembered for negentropyRetryAfter and synced by syncStoreFetch.
+func (c *Client) syncStoreNegentropy(ctx context.Context, urls []string, filter nostr.Filter) (error, []error) {
+	local := c.localEvents(filter)
+	until := nostr.Now() - EventGracePeriodSeconds
+
+	type result struct {
+		relay  string
+		events []*nostr.Event
+		err    error
+	}
+	var (
+		results  = make(chan result, len(urls))
+		fallback []string
+		started  int
+	)
+	for _, u := range urls {
+		relay := nostr.NormalizeURL(u)
+		if c.negentropyUnsupported(relay) {
+			fallback = append(fallback, relay)
+			continue
+		}
+		started++
+		go func() {
+			events, err := c.fetchMissing(ctx, relay, filter, local)
+			results <- result{relay: relay, events: events, err: err}
+		}()
+	}
+
+	var (
+		fetchErrors []error
+		events      = make(map[string][]*nostr.Event, len(urls))
+		synced      []string
+	)
+	for range started {
+		r := <-results
+		switch {
+		case errors.Is(r.err, errNegentropyUnsupported):
+			c.negUnsupported.Store(r.relay, time.Now())
+			fallback = append(fallback, r.relay)
+		case r.err != nil:
+			c.health.failure(r.relay, r.err)
+			fetchErrors = append(fetchErrors, fmt.Errorf("reconciling with relay %s: %v", r.relay, r.err))
+		default:
+			synced = append(synced, r.relay)
+		}
+		events[r.relay] = r.events
+	}
+	if ctx.Err() != nil {
+		return ctx.Err(), nil
+	}
+	errs, dropped := c.processLatest(events)
+	fetchErrors = append(fetchErrors, errs...)
+
+	// The reconciliation covers the whole range of the filter, so an incremental
+	// sync can continue from here.
+	if c.syncState != nil {
+		sp := SyncPoint{Until: until}
+		if filter.Since != nil {
+			sp.From = *filter.Since
+		}
+		for _, relay := range synced {
+			sp := sp
+			if !limitSyncPoint(&sp, dropped, relay) {
+				continue
+			}
+			if err := c.syncState.SetSyncPoint(relay, syncKey(filter), sp); err != nil {
+				fetchErrors = append(fetchErrors, fmt.Errorf("saving sync point of relay %s: %v", relay, err))
+			}
+		}
+	}
+
+	if len(fallback) > 0 {
+		err, err2 := c.syncStoreFetch(ctx, fallback, filter)
+		if err != nil {
+			return err, nil
+		}
+		fetchErrors = append(fetchErrors, err2...)
+	}
+	return nil, fetchErrors
+}
+
+// negentropyUnsupported reports whether the relay has been found not to support
+// NIP-77 within the last negentropyRetryAfter.
+func (c *Client) negentropyUnsupported(relayURL string) bool {
+	v, ok := c.negUnsupported.Load(relayURL)
+	if !ok {
+		return false
+	}
+	if time.Since(v.(time.Time)) < negentropyRetryAfter {
+		return true
+	}
+	c.negUnsupported.Delete(relayURL)
+	return false
+}
+
+// localEvents returns the events of the store matching the filter.
+func (c *Client) localEvents(filter nostr.Filter) []*nostr.Event {
+	if cs, ok := c.store.(RawEventStore); ok {
+		return cs.GetRawEvents(filter)
+	}
+
+	var events []*nostr.Event
+	for _, k := range filter.Tags["k"] {
+		kind, err := strconv.Atoi(k)
+		if err != nil {
+			continue
+		}
+		for _, ev := range c.store.GetEvents(Kind(kind), nil) {
+			if filter.Matches(ev.NostrEvent) {
+				events = append(events, ev.NostrEvent)
+			}
+		}
+	}
+	return events
+}
+
+// fetchMissing reconciles the local events with the relay and fetches the events
+// which only the relay has.
+func (c *Client) fetchMissing(ctx context.Context, relayURL string, filter nostr.Filter,
+	local []*nostr.Event) ([]*nostr.Event, error) {
+
+	ids, err := c.reconcile(ctx, relayURL, filter, local)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []*nostr.Event
+	for i := 0; i < len(ids); i += negentropyBatchSize {
+		batch := ids[i:min(i+negentropyBatchSize, len(ids))]
+		evs, err := c.fetchFromRelay(ctx, relayURL, nostr.Filter{IDs: batch})
+		events = append(events, evs...)
+		if err != nil {
+			return events, fmt.Errorf("fetching missing events: %w", err)
+		}
+	}
+	return events, nil
+}
+
+// reconcile runs the NIP-77 protocol with the pool relay and returns the IDs of the
+// events matching the filter which the relay has, but which aren't in the local set.
+// It returns errNegentropyUnsupported if the relay doesn't support NIP-77.
+func (c *Client) reconcile(ctx context.Context, relayURL string, filter nostr.Filter,
+	local []*nostr.Event) ([]string, error) {
+
+	relay, err := c.pool.EnsureRelay(relayURL)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := c.reconcileOnce(ctx, relay, filter, local)
+	var closed *closedError
+	if errors.As(err, &closed) && isAuthRequired(closed.reason) {
+		// Reconciling again once after authenticating, like for subscriptions.
+		if err := c.authenticate(ctx, relay, closed.reason); err != nil {
+			return nil, err
+		}
+		ids, err = c.reconcileOnce(ctx, relay, filter, local)
+	}
+	return ids, err
+}
+
+// negSession is a running reconciliation with a relay.
+type negSession struct {
+	relay   *nostr.Relay
+	message func(env nostr.Envelope)
+	notice  func(notice string)
+}
+
+// negHandlers is a relay option of the pool, which passes the NIP-77 messages and
+// the notices of a relay to its running reconciliations.
+type negHandlers struct {
+	c *Client
+}
+
+func (h negHandlers) ApplyRelayOption(r *nostr.Relay) {
+	nostr.WithCustomHandler(func(data string) {
+		var subID string
+		env := nip77.ParseNegMessage(data)
+		switch env := env.(type) {
+		case *nip77.MessageEnvelope:
+			subID = env.SubscriptionID
+		case *nip77.ErrorEnvelope:
+			subID = env.SubscriptionID
+		default:
+			return
+		}
+		if s, ok := h.c.negSessions.Load(subID); ok && s.(*negSession).relay == r {
+			s.(*negSession).message(env)
+		}
+	}).ApplyRelayOption(r)
+
+	nostr.WithNoticeHandler(func(notice string) {
+		// Logging like go-nostr does without a notice handler.
+		log.Printf("NOTICE from %s: '%s'\n", r.URL, notice)
+		h.c.negSessions.Range(func(_, s any) bool {
+			if s.(*negSession).relay == r {
+				s.(*negSession).notice(notice)
+			}
+			return true
+		})
+	}).ApplyRelayOption(r)
+}
+
+func (c *Client) reconcileOnce(ctx context.Context, relay *nostr.Relay, filter nostr.Filter,
+	local []*nostr.Event) ([]string, error) {
+
+	vec := vector.New()
+	for _, ev := range local {
+		vec.Insert(ev.CreatedAt, ev.ID)
+	}
+	vec.Seal()
+	neg := negentropy.New(vec, negentropyFrameSize)
+
+	subID := fmt.Sprintf("clip-neg-%d", c.negSeq.Add(1))
+	var (
+		replied  = make(chan struct{})
+		done     = make(chan error, 1)
+		once     sync.Once
+		finished sync.Once
+	)
+	finish := func(err error) {
+		finished.Do(func() { done <- err })
+	}
+	// Any answer of the relay to NEG-OPEN shows that it supports NIP-77.
+	reply := func() { once.Do(func() { close(replied) }) }
+
+	c.negSessions.Store(subID, &negSession{
+		relay: relay,
+		notice: func(notice string) {
+			// Other notices, like a welcome message or a rate limit, don't tell
+			// whether the relay supports NIP-77.
+			if !strings.Contains(strings.ToUpper(notice), "NEG-OPEN") {
+				return
+			}
+			select {
+			case <-replied:
+			default:
+				finish(fmt.Errorf("%w: %s", errNegentropyUnsupported, notice))
+			}
+		},
+		message: func(env nostr.Envelope) {
+			switch env := env.(type) {
+			case *nip77.ErrorEnvelope:
+				if isAuthRequired(env.Reason) {
+					finish(&closedError{reason: env.Reason})
+					return
+				}
+				finish(fmt.Errorf("%w: %s", errNegentropyUnsupported, env.Reason))
+			case *nip77.MessageEnvelope:
+				reply()
+				next, err := neg.Reconcile(env.Message)
+				if err != nil {
+					finish(fmt.Errorf("reconciling: %w", err))
+					return
+				}
+				if next == "" {
+					finish(nil)
+					return
+				}
+				msg, _ := nip77.MessageEnvelope{SubscriptionID: subID, Message: next}.MarshalJSON()
+				relay.Write(msg)
+			}
+		},
+	})
+	defer c.negSessions.Delete(subID)
+
+	// Collecting the IDs while reconciling, because the channels are bounded.
+	var (
+		ids       []string
+		collected = make(chan struct{})
+		stop      = make(chan struct{})
+	)
+	go func() {
+		defer close(collected)
+		haves, haveNots := neg.Haves, neg.HaveNots
+		for haves != nil || haveNots != nil {
+			select {
+			case _, ok := <-haves:
+				if !ok {
+					haves = nil
+				}
+			case id, ok := <-haveNots:
+				if !ok {
+					haveNots = nil
+					continue
+				}
+				ids = append(ids, id)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	defer close(stop)
+
+	open, _ := nip77.OpenEnvelope{SubscriptionID: subID, Filter: filter, Message: neg.Start()}.MarshalJSON()
+	if err := <-relay.Write(open); err != nil {
+		return nil, fmt.Errorf("writing to relay: %w", err)
+	}
+	defer func() {
+		msg, _ := nip77.CloseEnvelope{SubscriptionID: subID}.MarshalJSON()
+		relay.Write(msg)
+	}()
+
+	timeout := time.NewTimer(negentropyTimeout)
+	defer timeout.Stop()
+	wait := replied
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-wait:
+			wait = nil
+			timeout.Stop()
+		case <-timeout.C:
+			return nil, fmt.Errorf("%w: no answer", errNegentropyUnsupported)
+		case err := <-done:
+			if err != nil {
+				return nil, err
+			}
+			<-collected
+			return ids, nil
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package clip
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// newNegentropyTest returns a client syncing with negentropy and a relay with an
+// announcement and node info of the node.
+func newNegentropyTest(t *testing.T) (*Client, *testRelay) {
+	t.Helper()
+	node, npub := newTestNode(t), newTestNpub(t)
+	c := newTestClient(t, npub, node, WithNegentropy())
+
+	created := nostr.Now() - 3600
+	relay := newTestRelay(t)
+	relay.negentropy = true
+	relay.add(
+		newTestEvent(t, npub, node, KindNodeAnnouncement, created),
+		newTestEvent(t, npub, node, KindNodeInfo, created),
+	)
+	return c, relay
+}
+
+// fetchedByID returns the number of filters by IDs, which are sent for the
+// events missing after reconciling.
+func fetchedByID(relay *testRelay) int {
+	var n int
+	for _, f := range relay.requested() {
+		if len(f.IDs) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func syncNodeInfo(t *testing.T, c *Client, relay *testRelay) []*Event {
+	t.Helper()
+	events, err, fetchErrors := c.GetEvents(withTimeout(t), KindNodeInfo, nil, []string{relay.url}, time.Unix(0, 0))
+	if err != nil || len(fetchErrors) > 0 {
+		t.Fatalf("fetching: %v %v", err, fetchErrors)
+	}
+	return events
+}
+
+func TestNegentropyFetchesMissingEvents(t *testing.T) {
+	c, relay := newNegentropyTest(t)
+
+	if events := syncNodeInfo(t, c, relay); len(events) != 1 {
+		t.Fatalf("expected the node info, got %d events", len(events))
+	}
+	if fetchedByID(relay) == 0 {
+		t.Fatal("missing events not fetched by ID")
+	}
+	if _, ok := c.negUnsupported.Load(nostr.NormalizeURL(relay.url)); ok {
+		t.Fatal("relay marked as unsupported")
+	}
+
+	// Nothing is missing anymore.
+	before := fetchedByID(relay)
+	if events := syncNodeInfo(t, c, relay); len(events) != 1 {
+		t.Fatalf("expected the node info, got %d events", len(events))
+	}
+	if after := fetchedByID(relay); after != before {
+		t.Fatalf("fetched %d filters by ID although nothing is missing", after-before)
+	}
+}
+
+// A notice which isn't about NEG-OPEN, like a welcome message, doesn't mark the
+// relay as unsupported.
+func TestNegentropyIgnoresOtherNotices(t *testing.T) {
+	c, relay := newNegentropyTest(t)
+	relay.notice = "welcome to the test relay"
+
+	if events := syncNodeInfo(t, c, relay); len(events) != 1 {
+		t.Fatalf("expected the node info, got %d events", len(events))
+	}
+	if _, ok := c.negUnsupported.Load(nostr.NormalizeURL(relay.url)); ok {
+		t.Fatal("relay marked as unsupported because of a notice")
+	}
+	if fetchedByID(relay) == 0 {
+		t.Fatal("missing events not fetched by ID")
+	}
+}
+
+// Relays without NIP-77 support are remembered and synced by fetching.
+func TestNegentropyFallback(t *testing.T) {
+	c, relay := newNegentropyTest(t)
+	relay.negentropy = false
+
+	if events := syncNodeInfo(t, c, relay); len(events) != 1 {
+		t.Fatalf("expected the node info, got %d events", len(events))
+	}
+	if _, ok := c.negUnsupported.Load(nostr.NormalizeURL(relay.url)); !ok {
+		t.Fatal("relay not marked as unsupported")
+	}
+
+	opened := relay.negOpens()
+	syncNodeInfo(t, c, relay)
+	if relay.negOpens() != opened {
+		t.Fatal("NEG-OPEN sent again to an unsupported relay")
+	}
+}
+
+// The reconciliation runs on the pool relay, which authenticates if NEG-OPEN is
+// answered with auth-required.
+func TestNegentropyAuth(t *testing.T) {
+	node, npub := newTestNode(t), newTestNpub(t)
+	relay := newTestRelay(t)
+	relay.negentropy = true
+	relay.requireAuth = true
+	created := nostr.Now() - 3600
+	relay.add(
+		newTestEvent(t, npub, node, KindNodeAnnouncement, created),
+		newTestEvent(t, npub, node, KindNodeInfo, created),
+	)
+	c := newTestClient(t, npub, node, WithNegentropy(), WithAuthRelays(relay.url))
+
+	if events := syncNodeInfo(t, c, relay); len(events) != 1 {
+		t.Fatalf("expected the node info, got %d events", len(events))
+	}
+	if _, ok := c.negUnsupported.Load(nostr.NormalizeURL(relay.url)); ok {
+		t.Fatal("relay requiring AUTH marked as unsupported")
+	}
+	if len(relay.authenticated()) == 0 {
+		t.Fatal("not authenticated")
+	}
+	if fetchedByID(relay) == 0 {
+		t.Fatal("missing events not fetched by ID")
+	}
+}
+
+// NIP-77 is tried again with an unsupported relay after negentropyRetryAfter.
+func TestNegentropyUnsupportedExpires(t *testing.T) {
+	c, relay := newNegentropyTest(t)
+	relay.negentropy = false
+	url := nostr.NormalizeURL(relay.url)
+
+	syncNodeInfo(t, c, relay)
+	if !c.negentropyUnsupported(url) {
+		t.Fatal("relay not marked as unsupported")
+	}
+
+	c.negUnsupported.Store(url, time.Now().Add(-negentropyRetryAfter-time.Minute))
+	opened := relay.negOpens()
+	if events := syncNodeInfo(t, c, relay); len(events) != 1 {
+		t.Fatalf("expected the node info, got %d events", len(events))
+	}
+	if relay.negOpens() == opened {
+		t.Fatal("NEG-OPEN not sent again after the mark has expired")
+	}
+	if !c.negentropyUnsupported(url) {
+		t.Fatal("relay not marked as unsupported again")
+	}
+}
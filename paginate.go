@@ -0,0 +1,115 @@
+package clip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	// Events requested per query if no page size is set for the relay
+	DefaultPageSize = 500
+
+	// Maximum number of pages fetched from a relay per filter
+	maxPages = 1000
+)
+
+var ErrRelayTruncates = errors.New("relay returns fewer events per query than requested")
+
+// Pagination sets the number of events requested per query. Zero values mean
+// DefaultPageSize.
+type Pagination struct {
+	PageSize int `json:"page_size" yaml:"page_size" validate:"min=0"`
+
+	// Page sizes of single relays, e.g. of relays with a lower cap
+	Relays map[string]int `json:"relays" yaml:"relays" validate:"dive,min=0"`
+}
+
+// WithPagination sets the page sizes of the queries of the sync.
+func WithPagination(p Pagination) ClientOption {
+	return func(c *Client) {
+		c.pagination = Pagination{PageSize: p.PageSize, Relays: make(map[string]int)}
+		for u, size := range p.Relays {
+			c.pagination.Relays[nostr.NormalizeURL(u)] = size
+		}
+	}
+}
+
+func (p Pagination) pageSize(relayURL string) int {
+	if size := p.Relays[nostr.NormalizeURL(relayURL)]; size > 0 {
+		return size
+	}
+	if p.PageSize > 0 {
+		return p.PageSize
+	}
+	return DefaultPageSize
+}
+
+// fetchPaged fetches the events matching the filter from the relay page by page,
+// walking backwards in time with 'until' until a page which isn't full has no new
+// events, because many relays cap the number of events per query silently. A relay
+// returning fewer events than requested, followed by a page with more events, caps
+// the queries, and a warning wrapping ErrRelayTruncates is returned in fetchErrors.
+func (c *Client) fetchPaged(ctx context.Context, relayURL string,
+	filter nostr.Filter) ([]*nostr.Event, error, []error) {
+
+	size := c.pagination.pageSize(relayURL)
+	filter.Limit = size
+
+	var (
+		events   []*nostr.Event
+		seen     = make(map[string]struct{})
+		short    int
+		warnings []error
+	)
+	for range maxPages {
+		page, err := c.fetchFromRelay(ctx, relayURL, filter)
+		var (
+			added  int
+			oldest nostr.Timestamp
+		)
+		for i, ev := range page {
+			if i == 0 || ev.CreatedAt < oldest {
+				oldest = ev.CreatedAt
+			}
+			if _, ok := seen[ev.ID]; ok {
+				continue
+			}
+			seen[ev.ID] = struct{}{}
+			events = append(events, ev)
+			added++
+		}
+		if err != nil {
+			return events, err, warnings
+		}
+		// A full page without new events may only contain events with the timestamp
+		// of the oldest one, so paging continues before it.
+		if added == 0 && len(page) < size {
+			return events, nil, warnings
+		}
+
+		if short > 0 && warnings == nil {
+			warnings = append(warnings, fmt.Errorf("%w: %s returned %d of %d events, set a lower page size for it",
+				ErrRelayTruncates, relayURL, short, size))
+		}
+		short = 0
+		if len(page) < size {
+			short = len(page)
+		}
+
+		// Events with the timestamp of the oldest one may be cut off, so they are
+		// requested again. If the whole page has the same timestamp, paging can only
+		// continue before it.
+		until := oldest
+		if added == 0 || (filter.Until != nil && *filter.Until == oldest) {
+			until--
+		}
+		if filter.Since != nil && until < *filter.Since {
+			return events, nil, warnings
+		}
+		filter.Until = &until
+	}
+	return events, fmt.Errorf("more than %d pages", maxPages), warnings
+}
@@ -0,0 +1,59 @@
+package clip
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// newPagingTest returns a client with the page size and a relay with the events of
+// new nodes created at the timestamps.
+func newPagingTest(t *testing.T, pageSize int, created ...nostr.Timestamp) (*Client, *testRelay) {
+	t.Helper()
+	c := newTestClient(t, newTestNpub(t), newTestNode(t), WithPagination(Pagination{PageSize: pageSize}))
+	relay := newTestRelay(t)
+	for _, ts := range created {
+		relay.add(newTestEvent(t, newTestNpub(t), newTestNode(t), KindNodeAnnouncement, ts))
+	}
+	return c, relay
+}
+
+func fetchAllPages(t *testing.T, c *Client, relay *testRelay) ([]*nostr.Event, []error) {
+	t.Helper()
+	events, err, warnings := c.fetchPaged(withTimeout(t), relay.url,
+		nostr.Filter{Kinds: []int{KindLightningInformation}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events, warnings
+}
+
+// A relay with a lower cap than the page size is paged through completely, with a
+// warning.
+func TestFetchPagedTruncatingRelay(t *testing.T) {
+	c, relay := newPagingTest(t, 5, 100, 101, 102, 103, 104, 105)
+	relay.maxLimit = 2
+
+	events, warnings := fetchAllPages(t, c, relay)
+	if len(events) != 6 {
+		t.Fatalf("expected 6 events, got %d", len(events))
+	}
+	if len(warnings) != 1 || !errors.Is(warnings[0], ErrRelayTruncates) {
+		t.Fatalf("expected a truncation warning, got %v", warnings)
+	}
+}
+
+// A full page of events with the same timestamp, which have all been seen, doesn't
+// end the paging.
+func TestFetchPagedSameTimestamp(t *testing.T) {
+	c, relay := newPagingTest(t, 3, 100, 100, 100, 90, 80)
+
+	events, warnings := fetchAllPages(t, c, relay)
+	if len(events) != 5 {
+		t.Fatalf("expected 5 events, got %d", len(events))
+	}
+	if len(warnings) != 0 {
+		t.Fatalf("expected no warnings, got %v", warnings)
+	}
+}
@@ -2,6 +2,7 @@ package clip
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/go-playground/validator/v10"
 )
@@ -43,3 +44,57 @@ func (n *NodeInfo) Validate() error {
 	}
 	return nil
 }
+
+// ChannelOpenRequest is sent encrypted to the operator of another node before
+// opening a channel. The request ID is carried in the 'd' tag of the event.
+type ChannelOpenRequest struct {
+	AmountSat     uint64  `json:"amount_sat" validate:"required"`
+	PushAmountSat uint64  `json:"push_amount_sat,omitempty" validate:"ltfield=AmountSat"`
+	ChannelType   string  `json:"channel_type,omitempty"`
+	NodePubKey    string  `json:"node_pubkey" validate:"required"`
+	NodeAddress   string  `json:"node_address,omitempty"`
+	Message       *string `json:"message,omitempty"`
+}
+
+func (r *ChannelOpenRequest) Validate() error {
+	return validate.Struct(r)
+}
+
+// CheckLimits checks the requested channel size against the limits published
+// by the recipient in its node info.
+func (r *ChannelOpenRequest) CheckLimits(info *NodeInfo) error {
+	if info == nil {
+		return nil
+	}
+	if info.MinChannelSizeSat != nil && r.AmountSat < *info.MinChannelSizeSat {
+		return fmt.Errorf("requested amount %d sat is below the minimum channel size %d sat",
+			r.AmountSat, *info.MinChannelSizeSat)
+	}
+	if info.MaxChannelSizeSat != nil && r.AmountSat > *info.MaxChannelSizeSat {
+		return fmt.Errorf("requested amount %d sat is above the maximum channel size %d sat",
+			r.AmountSat, *info.MaxChannelSizeSat)
+	}
+	return nil
+}
+
+type ChannelResponseStatus string
+
+const (
+	ChannelResponseAccept  ChannelResponseStatus = "accept"
+	ChannelResponseDecline ChannelResponseStatus = "decline"
+	ChannelResponseCounter ChannelResponseStatus = "counter"
+)
+
+// ChannelOpenResponse answers a ChannelOpenRequest. It is linked to the request by
+// the request ID in the 'd' tag. A counter-offer carries the proposed values.
+type ChannelOpenResponse struct {
+	Status               ChannelResponseStatus `json:"status" validate:"required,oneof=accept decline counter"`
+	CounterAmountSat     *uint64               `json:"counter_amount_sat,omitempty" validate:"required_if=Status counter"`
+	CounterPushAmountSat *uint64               `json:"counter_push_amount_sat,omitempty"`
+	CounterChannelType   *string               `json:"counter_channel_type,omitempty"`
+	Message              *string               `json:"message,omitempty"`
+}
+
+func (r *ChannelOpenResponse) Validate() error {
+	return validate.Struct(r)
+}
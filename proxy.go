@@ -0,0 +1,160 @@
+package clip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/proxy"
+)
+
+var errProxyDown = errors.New("proxy unreachable")
+
+// ProxyDialer connects through a SOCKS5 proxy like Tor. Host names are resolved by
+// the proxy, so that onion services can be reached and no DNS query leaks.
+type ProxyDialer struct {
+	proxy  proxy.ContextDialer
+	direct net.Dialer
+
+	// Called for every direct connection to a remote host because the proxy is
+	// down. If nil, no such connection is made.
+	fallback func(addr string, err error)
+
+	// Transport of the websocket connections to the relays
+	transport *http.Transport
+}
+
+// NewProxyDialer returns a dialer for a proxy URL like socks5://127.0.0.1:9050. If
+// the proxy can't be reached, no connection is made, unless fallback is set: then
+// remote hosts except .onion addresses are connected to directly, which reveals the
+// IP address, and fallback is called for every such connection, e.g. to log it.
+// Local addresses are always connected to directly.
+func NewProxyDialer(proxyURL string, fallback func(addr string, err error)) (*ProxyDialer, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing proxy URL: %w", err)
+	}
+	if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+		return nil, fmt.Errorf("unsupported proxy scheme %q, only socks5 is supported", u.Scheme)
+	}
+
+	var auth *proxy.Auth
+	if u.User != nil {
+		password, _ := u.User.Password()
+		auth = &proxy.Auth{User: u.User.Username(), Password: password}
+	}
+	d, err := proxy.SOCKS5("tcp", u.Host, auth, proxyForward{})
+	if err != nil {
+		return nil, fmt.Errorf("creating socks5 dialer: %w", err)
+	}
+	return newProxyDialer(d.(proxy.ContextDialer), fallback), nil
+}
+
+func newProxyDialer(p proxy.ContextDialer, fallback func(addr string, err error)) *ProxyDialer {
+	d := &ProxyDialer{proxy: p, fallback: fallback}
+	d.transport = &http.Transport{DialContext: d.DialContext}
+	return d
+}
+
+// DialContext connects to addr through the proxy. Loopback and private addresses
+// can't be reached through a proxy like Tor, so they are connected to directly.
+func (d *ProxyDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if isLocal(addr) {
+		return d.direct.DialContext(ctx, network, addr)
+	}
+	conn, err := d.proxy.DialContext(ctx, network, addr)
+	if err == nil || !errors.Is(err, errProxyDown) || d.fallback == nil || isOnion(addr) {
+		return conn, err
+	}
+	d.fallback(addr, err)
+	return d.direct.DialContext(ctx, network, addr)
+}
+
+// WithRelayProxy connects to the relays through the dialer. go-nostr connects with
+// http.DefaultClient and has no option for another client, so this changes
+// http.DefaultClient for the whole process, once: its transport is wrapped, and the
+// requests of the websocket connections of the client are sent through the dialer.
+// All other requests are passed to the previous transport unchanged. Create the
+// client before other goroutines use http.DefaultClient.
+func WithRelayProxy(d *ProxyDialer) ClientOption {
+	return func(c *Client) {
+		wrapDefaultClient.Do(func() {
+			client := *http.DefaultClient
+			next := client.Transport
+			if next == nil {
+				next = http.DefaultTransport
+			}
+			client.Transport = dialerTransport{next: next}
+			http.DefaultClient = &client
+		})
+		c.relayProxy = d
+	}
+}
+
+// relayDialerKey is the context key of the dialer of the relay connections.
+type relayDialerKey struct{}
+
+var wrapDefaultClient sync.Once
+
+// withRelayDialer returns a context in which the websocket connections to the relays
+// are made with the dialer, see WithRelayProxy.
+func withRelayDialer(ctx context.Context, d *ProxyDialer) context.Context {
+	return context.WithValue(ctx, relayDialerKey{}, d)
+}
+
+// dialerTransport sends the requests with the transport of the dialer in their
+// context, or else with next.
+type dialerTransport struct {
+	next http.RoundTripper
+}
+
+func (t dialerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if d, ok := req.Context().Value(relayDialerKey{}).(*ProxyDialer); ok {
+		return d.transport.RoundTrip(req)
+	}
+	return t.next.RoundTrip(req)
+}
+
+// proxyForward connects to the proxy itself and marks the errors, so that a proxy
+// which is down can be told apart from an unreachable destination.
+type proxyForward struct{}
+
+func (proxyForward) Dial(network, addr string) (net.Conn, error) {
+	return proxyForward{}.DialContext(context.Background(), network, addr)
+}
+
+func (proxyForward) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errProxyDown, err)
+	}
+	return conn, nil
+}
+
+func isOnion(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return strings.HasSuffix(strings.TrimSuffix(host, "."), ".onion")
+}
+
+// isLocal reports whether addr is localhost or a loopback, private or link-local
+// IP address. Host names are resolved by the proxy and aren't considered local.
+func isLocal(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if strings.EqualFold(strings.TrimSuffix(host, "."), "localhost") {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && (ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast())
+}
@@ -0,0 +1,176 @@
+package clip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestIsLocal(t *testing.T) {
+	tests := map[string]bool{
+		"localhost:10009":      true,
+		"127.0.0.1:10009":      true,
+		"[::1]:10009":          true,
+		"192.168.1.20:10009":   true,
+		"10.0.0.5":             true,
+		"fe80::1":              true,
+		"93.184.216.34:10009":  false,
+		"lnd.example.com:443":  false,
+		"abcdefghijkl.onion:9": false,
+	}
+	for addr, want := range tests {
+		if got := isLocal(addr); got != want {
+			t.Errorf("isLocal(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+// A local LND is reached directly, also with the proxy down.
+func TestProxyDialerLocalDirect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// Nothing listens on the proxy address.
+	down, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyAddr := down.Addr().String()
+	down.Close()
+
+	d, err := NewProxyDialer("socks5://"+proxyAddr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := d.DialContext(context.Background(), "tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dialing a local address: %v", err)
+	}
+	conn.Close()
+
+	if _, err := d.DialContext(context.Background(), "tcp", "93.184.216.34:10009"); err == nil {
+		t.Fatal("remote address dialed directly without a fallback")
+	}
+}
+
+// downDialer stands in for a proxy which can't be reached.
+type downDialer struct{}
+
+func (downDialer) DialContext(context.Context, string, string) (net.Conn, error) {
+	return nil, fmt.Errorf("%w: connection refused", errProxyDown)
+}
+
+// Without a fallback, nothing is connected to directly if the proxy is down. With
+// it, every direct connection is reported, but onion services are never dialed.
+func TestProxyDialerFallback(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	strict := newProxyDialer(downDialer{}, nil)
+	if _, err := strict.DialContext(ctx, "tcp", "93.184.216.34:443"); !errors.Is(err, errProxyDown) {
+		t.Fatalf("expected the proxy error, got %v", err)
+	}
+
+	var fallbacks []string
+	d := newProxyDialer(downDialer{}, func(addr string, err error) {
+		fallbacks = append(fallbacks, addr)
+	})
+	if conn, err := d.DialContext(ctx, "tcp", "93.184.216.34:443"); err == nil {
+		conn.Close()
+	}
+	if _, err := d.DialContext(ctx, "tcp", "abcdefghijkl.onion:443"); !errors.Is(err, errProxyDown) {
+		t.Fatalf("expected the proxy error for an onion service, got %v", err)
+	}
+	if len(fallbacks) != 1 || fallbacks[0] != "93.184.216.34:443" {
+		t.Fatalf("expected one reported fallback, got %v", fallbacks)
+	}
+}
+
+func TestIsRelayURL(t *testing.T) {
+	tests := map[string]bool{
+		"wss://relay.example.com":   true,
+		"ws://relay.example.com:80": true,
+		"wss://93.184.216.34":       true,
+		"https://relay.example.com": false,
+		"wss://":                    false,
+		"ws://localhost:7777":       false,
+		"ws://127.0.0.1:7777":       false,
+		"wss://[::1]":               false,
+		"wss://192.168.1.20":        false,
+		"wss://10.0.0.5:443":        false,
+		"wss://[fe80::1]:443":       false,
+		"wss://relay.example.onion": true,
+	}
+	for u, want := range tests {
+		if got := isRelayURL(u); got != want {
+			t.Errorf("isRelayURL(%q) = %v, want %v", u, got, want)
+		}
+	}
+}
+
+// redirectDialer stands in for a proxy and connects to a fixed address.
+type redirectDialer struct {
+	to string
+
+	mu     sync.Mutex
+	dialed []string
+}
+
+func (d *redirectDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.mu.Lock()
+	d.dialed = append(d.dialed, addr)
+	d.mu.Unlock()
+	var direct net.Dialer
+	return direct.DialContext(ctx, network, d.to)
+}
+
+func (d *redirectDialer) count() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.dialed)
+}
+
+// The relay proxy is only used by the client it is passed to.
+func TestRelayProxyPerClient(t *testing.T) {
+	node, npub := newTestNode(t), newTestNpub(t)
+	relay := newTestRelay(t)
+	relay.add(newTestEvent(t, npub, node, KindNodeAnnouncement, nostr.Now()-3600))
+	u, err := url.Parse(relay.url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := &redirectDialer{to: u.Host}
+	ctx := withTimeout(t)
+
+	// The host is only known to the proxy.
+	proxied := newTestClient(t, npub, node, WithRelayProxy(newProxyDialer(proxy, nil)))
+	events, err, fetchErrors := proxied.GetEvents(ctx, KindNodeAnnouncement, nil,
+		[]string{"ws://relay.example.com:" + u.Port()}, time.Unix(0, 0))
+	if err != nil || len(fetchErrors) > 0 {
+		t.Fatalf("fetching through the proxy: %v %v", err, fetchErrors)
+	}
+	if len(events) != 1 || proxy.count() == 0 {
+		t.Fatalf("expected the announcement through the proxy, got %d events and %d dials",
+			len(events), proxy.count())
+	}
+
+	before := proxy.count()
+	direct := newTestClient(t, npub, node)
+	if _, err, fetchErrors := direct.GetEvents(ctx, KindNodeAnnouncement, nil, []string{relay.url},
+		time.Unix(0, 0)); err != nil || len(fetchErrors) > 0 {
+		t.Fatalf("fetching directly: %v %v", err, fetchErrors)
+	}
+	if proxy.count() != before {
+		t.Fatal("proxy of another client used")
+	}
+}
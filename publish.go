@@ -0,0 +1,224 @@
+package clip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	defaultPublishAttempts = 3
+	defaultRetryBackoff    = time.Second
+
+	// Maximum time to wait for the OK of a relay per attempt
+	publishAttemptTimeout = 10 * time.Second
+)
+
+var (
+	ErrQuorumNotMet = errors.New("event not accepted by enough relays")
+
+	errConnectionLost = errors.New("connection lost before the relay answered")
+)
+
+// PublishPolicy decides how often publishing to a relay is tried and how many
+// relays have to accept an event.
+type PublishPolicy struct {
+	// Attempts per relay for transient failures like connection errors, timeouts
+	// or rate limits. Zero means 3.
+	Attempts int `json:"attempts" yaml:"attempts" validate:"min=0"`
+
+	// Delay before the second attempt, doubled for every further one. Zero means 1s.
+	RetryBackoff time.Duration `json:"retry_backoff" yaml:"retry_backoff" validate:"min=0"`
+
+	// Number of relays which have to accept an event. Zero means 1.
+	MinSuccessfulRelays int `json:"min_successful_relays" yaml:"min_successful_relays" validate:"min=0"`
+}
+
+func (p PublishPolicy) attempts() int {
+	if p.Attempts <= 0 {
+		return defaultPublishAttempts
+	}
+	return p.Attempts
+}
+
+func (p PublishPolicy) backoff() time.Duration {
+	if p.RetryBackoff <= 0 {
+		return defaultRetryBackoff
+	}
+	return p.RetryBackoff
+}
+
+func (p PublishPolicy) quorum() int {
+	return max(p.MinSuccessfulRelays, 1)
+}
+
+// WithPublishPolicy sets the retries and the quorum of published events.
+func WithPublishPolicy(p PublishPolicy) ClientOption {
+	return func(c *Client) {
+		c.publishPolicy = p
+	}
+}
+
+// RelayPublishStatus is the final result of publishing an event to a relay.
+type RelayPublishStatus struct {
+	RelayURL   string `json:"relay_url"`
+	Error      string `json:"error,omitempty"`
+	Successful bool   `json:"successful"`
+}
+
+// PublishReport summarizes the results of publishing an event to all relays.
+type PublishReport struct {
+	Event  *nostr.Event         `json:"event"`
+	Status []RelayPublishStatus `json:"status"`
+
+	SuccessfulRelays    int `json:"successful_relays"`
+	MinSuccessfulRelays int `json:"min_successful_relays"`
+}
+
+// QuorumMet reports whether enough relays have accepted the event.
+func (r *PublishReport) QuorumMet() bool {
+	return r.SuccessfulRelays >= r.MinSuccessfulRelays
+}
+
+// Err returns an error wrapping ErrQuorumNotMet if not enough relays have accepted
+// the event.
+func (r *PublishReport) Err() error {
+	if r.QuorumMet() {
+		return nil
+	}
+	return fmt.Errorf("%w: accepted by %d, required %d", ErrQuorumNotMet,
+		r.SuccessfulRelays, r.MinSuccessfulRelays)
+}
+
+// Wait collects the results of all relays into a report, sorted by relay URL.
+func (r PublishResult) Wait() *PublishReport {
+	report := &PublishReport{Event: r.Event, MinSuccessfulRelays: r.quorum}
+	for pr := range r.Channel {
+		s := RelayPublishStatus{RelayURL: pr.RelayURL, Successful: pr.Error == nil}
+		if pr.Error != nil {
+			s.Error = pr.Error.Error()
+		} else {
+			report.SuccessfulRelays++
+		}
+		report.Status = append(report.Status, s)
+	}
+	sort.Slice(report.Status, func(i, j int) bool {
+		return report.Status[i].RelayURL < report.Status[j].RelayURL
+	})
+	return report
+}
+
+// publishEvent publishes a signed event to the usable relays, retrying transient
+// failures with exponential backoff.
+func (c *Client) publishEvent(ctx context.Context, ev *nostr.Event, urls []string) PublishResult {
+	urls = c.health.usable(urls)
+	results := make(chan nostr.PublishResult, len(urls))
+
+	var wg sync.WaitGroup
+	for _, u := range urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- c.publishToRelay(ctx, u, *ev)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return PublishResult{
+		Event:   ev,
+		Channel: c.trackPublish(ctx, results),
+		quorum:  c.publishPolicy.quorum(),
+	}
+}
+
+func (c *Client) publishToRelay(ctx context.Context, url string, ev nostr.Event) nostr.PublishResult {
+	backoff := c.publishPolicy.backoff()
+	attempts := c.publishPolicy.attempts()
+
+	for attempt := 1; ; attempt++ {
+		relay, err := c.publishOnce(ctx, url, ev)
+		if err == nil {
+			return nostr.PublishResult{RelayURL: url, Relay: relay}
+		}
+		if attempt == attempts || !isTransient(err) {
+			if attempt > 1 {
+				err = fmt.Errorf("%w (after %d attempts)", err, attempt)
+			}
+			return nostr.PublishResult{Error: err, RelayURL: url, Relay: relay}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nostr.PublishResult{Error: err, RelayURL: url, Relay: relay}
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) publishOnce(ctx context.Context, url string, ev nostr.Event) (*nostr.Relay, error) {
+	relay, err := c.pool.EnsureRelay(url)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, publishAttemptTimeout)
+	defer cancel()
+	err = publishWait(ctx, relay, ev)
+	if err == nil || !isAuthRequired(err.Error()) {
+		return relay, err
+	}
+
+	if err := c.authenticate(ctx, relay, err.Error()); err != nil {
+		return relay, err
+	}
+	return relay, publishWait(ctx, relay, ev)
+}
+
+// publishWait publishes the event and waits for the OK of the relay. go-nostr
+// returns no error if the connection is lost before the OK, so a closed connection
+// counts as a failure, even if the OK has arrived just before.
+func publishWait(ctx context.Context, relay *nostr.Relay, ev nostr.Event) error {
+	err := relay.Publish(ctx, ev)
+	if err == nil && !relay.IsConnected() {
+		return errConnectionLost
+	}
+	return err
+}
+
+// checkQuorum returns an error wrapping ErrQuorumNotMet if fewer relays are usable
+// than have to accept an event.
+func (c *Client) checkQuorum(urls []string) error {
+	relays := make(map[string]struct{}, len(urls))
+	for _, u := range c.health.usable(urls) {
+		relays[nostr.NormalizeURL(u)] = struct{}{}
+	}
+	if quorum := c.publishPolicy.quorum(); len(relays) < quorum {
+		return fmt.Errorf("%w: %d relays required, but only %d usable", ErrQuorumNotMet,
+			quorum, len(relays))
+	}
+	return nil
+}
+
+// isTransient reports whether publishing may succeed when tried again. Rejections
+// of the relay and failed authentication are final, except for rate limits and
+// internal errors (NIP-01).
+func isTransient(err error) bool {
+	if errors.Is(err, ErrAuthRequired) || errors.Is(err, ErrAuthFailed) {
+		return false
+	}
+	reason, rejected := strings.CutPrefix(err.Error(), "msg: ")
+	if !rejected {
+		return true
+	}
+	return strings.HasPrefix(reason, "rate-limited:") || strings.HasPrefix(reason, "error:")
+}
@@ -0,0 +1,145 @@
+package clip
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsTransient(t *testing.T) {
+	for _, tt := range []struct {
+		err       error
+		transient bool
+	}{
+		{errors.New("failed to connect: dial tcp: connection refused"), true},
+		{errConnectionLost, true},
+		{errors.New("msg: rate-limited: slow down"), true},
+		{errors.New("msg: error: database unavailable"), true},
+		{errors.New("msg: blocked: not allowed"), false},
+		{errors.New("msg: invalid: bad event"), false},
+		{errors.New("msg: duplicate: already have it"), false},
+		{fmt.Errorf("%w (auth-required: x)", ErrAuthRequired), false},
+		{fmt.Errorf("%w: bad challenge", ErrAuthFailed), false},
+	} {
+		if got := isTransient(tt.err); got != tt.transient {
+			t.Errorf("%v: expected transient %v, got %v", tt.err, tt.transient, got)
+		}
+	}
+}
+
+// publishNodeInfo publishes a node info with the policy and returns the report.
+func publishNodeInfo(t *testing.T, policy PublishPolicy, relays ...*testRelay) *PublishReport {
+	t.Helper()
+	c := newTestClient(t, newTestNpub(t), newTestNode(t), WithPublishPolicy(policy))
+	var urls []string
+	for _, r := range relays {
+		urls = append(urls, r.url)
+	}
+	res, err := c.Publish(withTimeout(t), NodeInfo{}, KindNodeInfo, urls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.Wait()
+}
+
+func TestPublishRetries(t *testing.T) {
+	policy := PublishPolicy{Attempts: 3, RetryBackoff: 50 * time.Millisecond}
+
+	t.Run("transient rejections", func(t *testing.T) {
+		relay := newTestRelay(t)
+		relay.rejections = []string{"rate-limited: slow down", "error: try again"}
+		start := time.Now()
+		report := publishNodeInfo(t, policy, relay)
+		if err := report.Err(); err != nil {
+			t.Fatalf("%v %+v", err, report.Status)
+		}
+		if n := relay.published(); n != 3 {
+			t.Fatalf("expected 3 attempts, got %d", n)
+		}
+		// Waiting 50ms before the second attempt and 100ms before the third.
+		if d := time.Since(start); d < 150*time.Millisecond {
+			t.Fatalf("retried without backoff after %v", d)
+		}
+	})
+
+	t.Run("attempts exhausted", func(t *testing.T) {
+		relay := newTestRelay(t)
+		relay.rejections = []string{"rate-limited: 1", "rate-limited: 2", "rate-limited: 3"}
+		report := publishNodeInfo(t, policy, relay)
+		if report.QuorumMet() || !strings.Contains(report.Status[0].Error, "after 3 attempts") {
+			t.Fatalf("expected a failure after 3 attempts, got %+v", report.Status)
+		}
+		if n := relay.published(); n != 3 {
+			t.Fatalf("expected 3 attempts, got %d", n)
+		}
+	})
+
+	t.Run("final rejection", func(t *testing.T) {
+		relay := newTestRelay(t)
+		relay.rejections = []string{"blocked: not allowed"}
+		report := publishNodeInfo(t, policy, relay)
+		if report.QuorumMet() || !strings.Contains(report.Status[0].Error, "blocked") {
+			t.Fatalf("expected the rejection, got %+v", report.Status)
+		}
+		if n := relay.published(); n != 1 {
+			t.Fatalf("final rejection tried %d times", n)
+		}
+	})
+
+	t.Run("closed connection", func(t *testing.T) {
+		relay := newTestRelay(t)
+		relay.dropEvents = 1
+		report := publishNodeInfo(t, policy, relay)
+		if err := report.Err(); err != nil {
+			t.Fatalf("%v %+v", err, report.Status)
+		}
+		if n := relay.published(); n != 2 || len(relay.stored()) != 1 {
+			t.Fatalf("expected the event stored on the second attempt, got %d attempts", n)
+		}
+	})
+
+	t.Run("closed connection on every attempt", func(t *testing.T) {
+		relay := newTestRelay(t)
+		relay.dropEvents = 3
+		report := publishNodeInfo(t, policy, relay)
+		if report.QuorumMet() || !strings.Contains(report.Status[0].Error, "after 3 attempts") {
+			t.Fatalf("expected a failure after 3 attempts, got %+v", report.Status)
+		}
+	})
+}
+
+func TestPublishQuorum(t *testing.T) {
+	accepting, rejecting := newTestRelay(t), newTestRelay(t)
+	rejecting.rejections = []string{"blocked: not allowed"}
+
+	report := publishNodeInfo(t, PublishPolicy{MinSuccessfulRelays: 2}, accepting, rejecting)
+	if report.SuccessfulRelays != 1 || report.MinSuccessfulRelays != 2 || len(report.Status) != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if err := report.Err(); !errors.Is(err, ErrQuorumNotMet) {
+		t.Fatalf("expected ErrQuorumNotMet, got %v", err)
+	}
+
+	report = publishNodeInfo(t, PublishPolicy{MinSuccessfulRelays: 1}, newTestRelay(t), rejecting)
+	if err := report.Err(); err != nil {
+		t.Fatalf("quorum of 1 not met: %v", err)
+	}
+}
+
+// A quorum above the number of relays fails before anything is signed or published.
+func TestPublishQuorumAboveRelays(t *testing.T) {
+	relay := newTestRelay(t)
+	c := newTestClient(t, newTestNpub(t), newTestNode(t),
+		WithPublishPolicy(PublishPolicy{MinSuccessfulRelays: 2}))
+
+	// The same relay twice counts once.
+	_, err := c.Publish(withTimeout(t), NodeInfo{}, KindNodeInfo, []string{relay.url, relay.url + "/"})
+	if !errors.Is(err, ErrQuorumNotMet) {
+		t.Fatalf("expected ErrQuorumNotMet, got %v", err)
+	}
+	if relay.published() != 0 || len(c.store.GetEvents(KindNodeInfo, nil)) != 0 {
+		t.Fatal("event published or stored although the quorum can't be met")
+	}
+}
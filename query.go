@@ -0,0 +1,116 @@
+package clip
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+// Query filters the accepted events of the store. Empty fields match all events.
+// The filters on the payload only match node info events.
+type Query struct {
+	// Lightning node pubkeys
+	PubKeys map[string]struct{}
+
+	// Hex encoded nostr pubkeys of the authors
+	Npubs map[string]struct{}
+
+	// Network of the 'd' tag. Node announcements have no network and match every
+	// network, because they bind the npub of a node on all networks.
+	Network string
+
+	// Type of a contact info, e.g. telegram
+	ContactType string
+
+	// Key of a custom record, or key=value to match the value as well
+	CustomRecord string
+
+	// Case-insensitive text in the about field
+	About string
+}
+
+func (q *Query) hasPayloadFilter() bool {
+	return q.ContactType != "" || q.CustomRecord != "" || q.About != ""
+}
+
+// Match reports whether the event matches the query.
+func (q *Query) Match(ev *Event) bool {
+	id, err := ev.GetIdentifier()
+	if err != nil {
+		return false
+	}
+	if !newInFilter(q.PubKeys)(id.PubKey) || !newInFilter(q.Npubs)(ev.NostrEvent.PubKey) {
+		return false
+	}
+	if q.Network != "" && id.Kind != KindNodeAnnouncement && id.Network != q.Network {
+		return false
+	}
+	if !q.hasPayloadFilter() {
+		return true
+	}
+	if id.Kind != KindNodeInfo {
+		return false
+	}
+
+	var info NodeInfo
+	if err := json.Unmarshal([]byte(ev.NostrEvent.Content), &info); err != nil {
+		return false
+	}
+	return q.matchNodeInfo(&info)
+}
+
+func (q *Query) matchNodeInfo(info *NodeInfo) bool {
+	if q.ContactType != "" {
+		found := false
+		for _, c := range info.ContactInfo {
+			if strings.EqualFold(c.Type, q.ContactType) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if q.CustomRecord != "" {
+		key, value, withValue := strings.Cut(q.CustomRecord, "=")
+		v, ok := info.CustomRecords[key]
+		if !ok || (withValue && v != value) {
+			return false
+		}
+	}
+
+	if q.About != "" {
+		if info.About == nil ||
+			!strings.Contains(strings.ToLower(*info.About), strings.ToLower(q.About)) {
+			return false
+		}
+	}
+	return true
+}
+
+// QueryEvents returns the accepted events of a kind in the store matching the query.
+func QueryEvents(s Store, kind Kind, q Query) []*Event {
+	var events []*Event
+	for _, ev := range s.GetEvents(kind, q.PubKeys) {
+		if q.Match(ev) {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+// QueryLocalEvents returns the events of a kind in the local store matching the query,
+// without fetching from relays.
+func (c *Client) QueryLocalEvents(kind Kind, q Query) []*Event {
+	return QueryEvents(c.store, kind, q)
+}
+
+// QueryLocalEventEnvelopes is like GetLocalEventEnvelopes, but returns only the events
+// matching the query.
+func QueryLocalEventEnvelopes[T any](c *Client, ctx context.Context, kind Kind,
+	q Query) ([]EventEnvelope[T], []error) {
+
+	return newEventEnvelopes[T](c, ctx, c.QueryLocalEvents(kind, q))
+}
@@ -0,0 +1,168 @@
+package clip
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// localEvent returns the event as received from a relay.
+func localEvent(t *testing.T, nev *nostr.Event) *Event {
+	t.Helper()
+	ev, err := NewEventFromNostrRelay(nev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ev
+}
+
+// newTestEventOn returns an event like newTestEventWithContent on the network.
+func newTestEventOn(t *testing.T, npub testNpub, node *testNode, kind Kind, network, content string,
+	opts ...string) *nostr.Event {
+
+	t.Helper()
+	ev := &Event{NostrEvent: &nostr.Event{PubKey: npub.pk, CreatedAt: nostr.Now() - 3600, Content: content}}
+	if err := ev.Finalize(network, node.pubKey(), kind, opts); err != nil {
+		t.Fatal(err)
+	}
+	signer := &CombinedSigner{NostrSigner: npub.signer(t), LnSigner: node}
+	if err := signer.SignEvent(context.Background(), ev); err != nil {
+		t.Fatal(err)
+	}
+	return ev.NostrEvent
+}
+
+// Node announcements have no network and match every network. Events of older
+// versions without 'n' tag are matched by the network of their 'd' tag.
+func TestQueryMatchNetwork(t *testing.T) {
+	node, npub := newTestNode(t), newTestNpub(t)
+	created := nostr.Now() - 3600
+
+	ann := localEvent(t, newTestEvent(t, npub, node, KindNodeAnnouncement, created))
+	info := localEvent(t, newTestEvent(t, npub, node, KindNodeInfo, created))
+	old := newTestEvent(t, npub, node, KindNodeInfo, created)
+	old.Tags = old.Tags.FilterOut([]string{"n"})
+	if err := old.Sign(npub.sk); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, network := range []string{"", "mainnet", "testnet", "signet"} {
+		q := Query{Network: network}
+		if !q.Match(ann) {
+			t.Errorf("announcement doesn't match network %q", network)
+		}
+		want := network == "" || network == "mainnet"
+		if got := q.Match(info); got != want {
+			t.Errorf("node info on mainnet matches network %q: %v, want %v", network, got, want)
+		}
+		if got := q.Match(localEvent(t, old)); got != want {
+			t.Errorf("node info without 'n' tag matches network %q: %v, want %v", network, got, want)
+		}
+	}
+}
+
+func TestQueryMatch(t *testing.T) {
+	node, other := newTestNode(t), newTestNode(t)
+	npub, otherNpub := newTestNpub(t), newTestNpub(t)
+	content := `{"about":"Routing Node in Berlin","contact_info":[{"type":"telegram","value":"@node"}],` +
+		`"custom_records":{"policy":"open"}}`
+
+	ann := localEvent(t, newTestEventOn(t, npub, node, KindNodeAnnouncement, "mainnet", "{}"))
+	info := localEvent(t, newTestEventOn(t, npub, node, KindNodeInfo, "mainnet", content))
+	testnet := localEvent(t, newTestEventOn(t, npub, node, KindNodeInfo, "testnet", content))
+	request := localEvent(t, newTestEventOn(t, npub, node, KindChannelRequest, "mainnet", content, "abc"))
+	invalid := localEvent(t, newTestEventOn(t, npub, node, KindNodeInfo, "mainnet", "not json"))
+	otherInfo := localEvent(t, newTestEventOn(t, otherNpub, other, KindNodeInfo, "mainnet", "{}"))
+
+	set := func(keys ...string) map[string]struct{} {
+		m := make(map[string]struct{})
+		for _, k := range keys {
+			m[k] = struct{}{}
+		}
+		return m
+	}
+	for _, tt := range []struct {
+		name  string
+		q     Query
+		ev    *Event
+		match bool
+	}{
+		{"empty query", Query{}, otherInfo, true},
+		{"empty query, opts", Query{}, request, true},
+		{"pubkey", Query{PubKeys: set(node.pubKey())}, info, true},
+		{"other pubkey", Query{PubKeys: set(node.pubKey())}, otherInfo, false},
+		{"pubkey, opts", Query{PubKeys: set(node.pubKey())}, request, true},
+		{"npub", Query{Npubs: set(npub.pk)}, ann, true},
+		{"other npub", Query{Npubs: set(otherNpub.pk)}, ann, false},
+		{"network", Query{Network: "mainnet"}, info, true},
+		{"other network", Query{Network: "mainnet"}, testnet, false},
+		{"network, announcement", Query{Network: "testnet"}, ann, true},
+		{"network, opts", Query{Network: "testnet"}, request, false},
+		{"contact type", Query{ContactType: "Telegram"}, info, true},
+		{"missing contact type", Query{ContactType: "email"}, info, false},
+		{"custom record", Query{CustomRecord: "policy"}, info, true},
+		{"custom record value", Query{CustomRecord: "policy=open"}, info, true},
+		{"other custom record value", Query{CustomRecord: "policy=closed"}, info, false},
+		{"about", Query{About: "berlin"}, info, true},
+		{"other about", Query{About: "paris"}, info, false},
+		{"payload filter, announcement", Query{About: "berlin"}, ann, false},
+		{"payload filter, opts", Query{About: "berlin"}, request, false},
+		{"payload filter, invalid content", Query{About: "berlin"}, invalid, false},
+		{"all filters", Query{PubKeys: set(node.pubKey()), Npubs: set(npub.pk), Network: "mainnet",
+			ContactType: "telegram", CustomRecord: "policy=open", About: "routing"}, info, true},
+	} {
+		if got := tt.q.Match(tt.ev); got != tt.match {
+			t.Errorf("%s: expected match %v, got %v", tt.name, tt.match, got)
+		}
+	}
+}
+
+func TestQueryLocalEventEnvelopes(t *testing.T) {
+	c := newTestClient(t, newTestNpub(t), newTestNode(t))
+	berlin, paris := newTestNode(t), newTestNode(t)
+	berlinNpub, parisNpub := newTestNpub(t), newTestNpub(t)
+	for _, ev := range []*nostr.Event{
+		newTestEventOn(t, berlinNpub, berlin, KindNodeAnnouncement, "mainnet", "{}"),
+		newTestEventOn(t, berlinNpub, berlin, KindNodeInfo, "mainnet", `{"about":"Berlin"}`),
+		newTestEventOn(t, parisNpub, paris, KindNodeAnnouncement, "mainnet", "{}"),
+		newTestEventOn(t, parisNpub, paris, KindNodeInfo, "testnet", `{"about":"Paris"}`),
+	} {
+		if err := c.processEvent(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		name string
+		q    Query
+		want []string
+	}{
+		{"all", Query{}, []string{"Berlin", "Paris"}},
+		{"network", Query{Network: "testnet"}, []string{"Paris"}},
+		{"npub", Query{Npubs: map[string]struct{}{berlinNpub.pk: {}}}, []string{"Berlin"}},
+		{"about", Query{About: "PAR"}, []string{"Paris"}},
+		{"no match", Query{About: "Rome"}, nil},
+	} {
+		envs, errs := QueryLocalEventEnvelopes[NodeInfo](c, withTimeout(t), KindNodeInfo, tt.q)
+		if len(errs) > 0 {
+			t.Fatalf("%s: %v", tt.name, errs)
+		}
+		var got []string
+		for _, env := range envs {
+			got = append(got, *env.Payload.About)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	// Announcements have no network and match every network.
+	envs, _ := QueryLocalEventEnvelopes[NodeInfo](c, withTimeout(t), KindNodeAnnouncement,
+		Query{Network: "signet", PubKeys: map[string]struct{}{paris.pubKey(): {}}})
+	if len(envs) != 1 || envs[0].Id.PubKey != paris.pubKey() {
+		t.Fatalf("expected the announcement of the node, got %d", len(envs))
+	}
+}
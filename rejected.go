@@ -0,0 +1,125 @@
+package clip
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Errors of the checks of the client before and after storing an event.
+var (
+	ErrPubKeyMismatch  = errors.New("pubkey not bound to the node")
+	ErrInsufficientPow = errors.New("insufficient proof of work")
+
+	// The store has kept an event with the same created_at and a lower ID
+	ErrTieLost = errors.New("tie lost")
+)
+
+// RejectReason is the code of the reason why an event has been rejected.
+type RejectReason string
+
+const (
+	ReasonFutureEvent     RejectReason = "future_timestamp"
+	ReasonIDMismatch      RejectReason = "id_mismatch"
+	ReasonContentTooLarge RejectReason = "oversize"
+	ReasonInvalidNetwork  RejectReason = "invalid_network"
+	ReasonInvalidTagD     RejectReason = "malformed_d_tag"
+	ReasonInvalidTagK     RejectReason = "malformed_k_tag"
+	ReasonInvalidTagN     RejectReason = "malformed_n_tag"
+	ReasonNostrSignature  RejectReason = "bad_nostr_sig"
+	ReasonLnSignature     RejectReason = "bad_ln_sig"
+	ReasonStale           RejectReason = "stale"
+	ReasonTieLost         RejectReason = "tie_lost"
+	ReasonPubKeyMismatch  RejectReason = "npub_mismatch"
+	ReasonInsufficientPow RejectReason = "insufficient_pow"
+	ReasonUnknown         RejectReason = "unknown"
+)
+
+// ReasonOf maps an error of Event.Verify, Store.StoreEvent or the checks of the
+// client to a reason.
+func ReasonOf(err error) RejectReason {
+	reasons := []struct {
+		err    error
+		reason RejectReason
+	}{
+		{ErrFutureEvent, ReasonFutureEvent},
+		{ErrIDMismatch, ReasonIDMismatch},
+		{ErrContentTooLarge, ReasonContentTooLarge},
+		{ErrInvalidNetwork, ReasonInvalidNetwork},
+		{ErrInvalidTagD, ReasonInvalidTagD},
+		{ErrInvalidTagK, ReasonInvalidTagK},
+		{ErrInvalidTagN, ReasonInvalidTagN},
+		{ErrNostrSignature, ReasonNostrSignature},
+		{ErrLnSignature, ReasonLnSignature},
+		{ErrStaleEvent, ReasonStale},
+		{ErrTieLost, ReasonTieLost},
+		{ErrPubKeyMismatch, ReasonPubKeyMismatch},
+		{ErrInsufficientPow, ReasonInsufficientPow},
+	}
+	for _, r := range reasons {
+		if errors.Is(err, r.err) {
+			return r.reason
+		}
+	}
+	return ReasonUnknown
+}
+
+// RejectedEvent is the error of an event received from a relay which hasn't been
+// accepted.
+type RejectedEvent struct {
+	Reason  RejectReason `json:"reason"`
+	EventID string       `json:"event_id"`
+	// Nostr pubkey of the author
+	Author string `json:"author"`
+	// Lightning pubkey of the node, empty if the 'd' tag is malformed
+	PubKey    string          `json:"pub_key,omitempty"`
+	CreatedAt nostr.Timestamp `json:"created_at"`
+	Message   string          `json:"message"`
+
+	// Time of the last rejection
+	RejectedAt nostr.Timestamp `json:"rejected_at"`
+
+	// Not available after loading from a store
+	Err error `json:"-"`
+}
+
+func newRejectedEvent(ev *nostr.Event, err error) *RejectedEvent {
+	r := &RejectedEvent{
+		Reason:     ReasonOf(err),
+		EventID:    ev.ID,
+		Author:     ev.PubKey,
+		CreatedAt:  ev.CreatedAt,
+		Message:    err.Error(),
+		RejectedAt: nostr.Now(),
+		Err:        err,
+	}
+	if id, err := (&Event{NostrEvent: ev}).GetIdentifier(); err == nil {
+		r.PubKey = id.PubKey
+	}
+	return r
+}
+
+func (r *RejectedEvent) Error() string {
+	return fmt.Sprintf("event %s rejected (%s): %s", r.EventID, r.Reason, r.Message)
+}
+
+func (r *RejectedEvent) Unwrap() error {
+	return r.Err
+}
+
+// RejectionStore is implemented by stores which keep the rejected events.
+type RejectionStore interface {
+	StoreRejected(r *RejectedEvent) error
+	GetRejected() ([]*RejectedEvent, error)
+}
+
+var errNoRejections = errors.New("store doesn't keep rejected events")
+
+// GetRejected returns the rejected events kept by the store.
+func (c *Client) GetRejected() ([]*RejectedEvent, error) {
+	if c.rejections == nil {
+		return nil, errNoRejections
+	}
+	return c.rejections.GetRejected()
+}
@@ -0,0 +1,84 @@
+package clip
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func newTestBoltStore(t *testing.T) *BoltStore {
+	t.Helper()
+	return newTestBoltStoreAt(t, filepath.Join(t.TempDir(), "clip.db"))
+}
+
+func newTestBoltStoreAt(t *testing.T, path string) *BoltStore {
+	t.Helper()
+	s, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// Rejections of events which are accepted later are removed.
+func TestRejectedRemovedWhenAccepted(t *testing.T) {
+	node, npub := newTestNode(t), newTestNpub(t)
+	store := newTestBoltStore(t)
+	c := newTestClient(t, npub, node, WithStore(store))
+
+	info := newTestEvent(t, npub, node, KindNodeInfo, 110)
+	if ReasonOf(c.processEvent(info)) != ReasonPubKeyMismatch {
+		t.Fatal("expected the node info to be rejected without announcement")
+	}
+	if rejected, err := c.GetRejected(); err != nil || len(rejected) != 1 {
+		t.Fatalf("expected one rejection, got %v: %v", rejected, err)
+	}
+
+	if err := c.processEvent(newTestEvent(t, npub, node, KindNodeAnnouncement, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if rejected, err := c.GetRejected(); err != nil || len(rejected) != 0 {
+		t.Fatalf("expected no rejections, got %v: %v", rejected, err)
+	}
+}
+
+// The number of rejections is capped and expired ones are removed.
+func TestRejectedBounded(t *testing.T) {
+	store := newTestBoltStore(t)
+	now := nostr.Now()
+
+	expired := &RejectedEvent{Reason: ReasonIDMismatch, EventID: "expired",
+		RejectedAt: now - nostr.Timestamp(rejectedTTL/time.Second) - 1}
+	if err := store.StoreRejected(expired); err != nil {
+		t.Fatal(err)
+	}
+	for i := range MaxRejectedEvents + 50 {
+		r := &RejectedEvent{Reason: ReasonIDMismatch, EventID: fmt.Sprintf("%064d", i),
+			RejectedAt: now + nostr.Timestamp(i)}
+		if err := store.StoreRejected(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rejected, err := store.GetRejected()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rejected) != MaxRejectedEvents {
+		t.Fatalf("expected %d rejections, got %d", MaxRejectedEvents, len(rejected))
+	}
+	oldest := rejected[0].RejectedAt
+	for _, r := range rejected {
+		if r.EventID == "expired" {
+			t.Fatal("expired rejection kept")
+		}
+		oldest = min(oldest, r.RejectedAt)
+	}
+	if oldest != now+50 {
+		t.Fatalf("expected the oldest rejections to be removed, oldest is %d", oldest-now)
+	}
+}
@@ -0,0 +1,343 @@
+package clip
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip77/negentropy"
+	"github.com/nbd-wtf/go-nostr/nip77/negentropy/storage/vector"
+)
+
+// testRelay is an in-process relay, which stores the published events in memory
+// and answers REQ, AUTH and optionally NEG-OPEN (NIP-77). Subscriptions stay open
+// after EOSE until CLOSE.
+type testRelay struct {
+	url string
+
+	mu      sync.Mutex
+	events  []*nostr.Event
+	filters []nostr.Filter
+	negOpen int
+	authed  []string
+	reqs    int
+	pubs    int
+	subs    map[*testSub]struct{}
+
+	// Configuration, set before the first connection
+	requireAuth bool
+	negentropy  bool
+	notice      string
+
+	// Maximum number of events per REQ, in addition to the limit of the filter
+	maxLimit int
+
+	// REQs after this number are never answered, if set
+	stallAfter int
+
+	// The connection is closed without an answer on this number of first EVENTs,
+	// and the following EVENTs are answered with these OK false reasons, one each.
+	dropEvents int
+	rejections []string
+}
+
+func newTestRelay(t *testing.T) *testRelay {
+	t.Helper()
+	r := &testRelay{}
+	srv := httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(srv.Close)
+	r.url = "ws" + strings.TrimPrefix(srv.URL, "http")
+	return r
+}
+
+// testSub is an open subscription, which gets the events added after its EOSE.
+type testSub struct {
+	filters []nostr.Filter
+	send    func(ev *nostr.Event)
+}
+
+// add stores events and sends them to the open subscriptions they match.
+func (r *testRelay) add(events ...*nostr.Event) {
+	r.mu.Lock()
+	r.events = append(r.events, events...)
+	subs := make([]*testSub, 0, len(r.subs))
+	for sub := range r.subs {
+		subs = append(subs, sub)
+	}
+	r.mu.Unlock()
+
+	for _, ev := range events {
+		for _, sub := range subs {
+			if nostr.Filters(sub.filters).Match(ev) {
+				sub.send(ev)
+			}
+		}
+	}
+}
+
+func (r *testRelay) subscribe(sub *testSub) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.subs == nil {
+		r.subs = make(map[*testSub]struct{})
+	}
+	r.subs[sub] = struct{}{}
+}
+
+func (r *testRelay) unsubscribe(sub *testSub) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.subs, sub)
+}
+
+// published returns the number of EVENTs sent to the relay, also the rejected ones.
+func (r *testRelay) published() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.pubs
+}
+
+// stored returns the events of the relay.
+func (r *testRelay) stored() []*nostr.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*nostr.Event(nil), r.events...)
+}
+
+// requested returns the filters of all REQ messages.
+func (r *testRelay) requested() []nostr.Filter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]nostr.Filter(nil), r.filters...)
+}
+
+// authenticated returns the pubkeys of all successful AUTH messages.
+func (r *testRelay) authenticated() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.authed...)
+}
+
+// negOpens returns the number of NEG-OPEN messages.
+func (r *testRelay) negOpens() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.negOpen
+}
+
+func (r *testRelay) matching(filter nostr.Filter) []*nostr.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var events []*nostr.Event
+	for _, ev := range r.events {
+		if filter.Matches(ev) {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+// limited returns the newest events matching the filter up to its limit and maxLimit.
+func (r *testRelay) limited(filter nostr.Filter) []*nostr.Event {
+	events := r.matching(filter)
+	sort.SliceStable(events, func(i, j int) bool { return events[i].CreatedAt > events[j].CreatedAt })
+	limit := filter.Limit
+	if r.maxLimit > 0 && (limit == 0 || r.maxLimit < limit) {
+		limit = r.maxLimit
+	}
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
+	}
+	return events
+}
+
+func (r *testRelay) serve(w http.ResponseWriter, req *http.Request) {
+	conn, err := websocket.Accept(w, req, nil)
+	if err != nil {
+		return
+	}
+	defer conn.CloseNow()
+	ctx := req.Context()
+
+	send := func(msg ...any) {
+		b, _ := json.Marshal(msg)
+		conn.Write(ctx, websocket.MessageText, b)
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	challenge := hex.EncodeToString(b)
+	send("AUTH", challenge)
+	if r.notice != "" {
+		send("NOTICE", r.notice)
+	}
+
+	var (
+		authed string
+		negs   = make(map[string]*negentropy.Negentropy)
+		subs   = make(map[string]*testSub)
+	)
+	defer func() {
+		for _, sub := range subs {
+			r.unsubscribe(sub)
+		}
+	}()
+	for {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			return
+		}
+		var msg []json.RawMessage
+		if err := json.Unmarshal(data, &msg); err != nil || len(msg) < 2 {
+			send("NOTICE", "invalid message")
+			continue
+		}
+		var typ, subID string
+		json.Unmarshal(msg[0], &typ)
+		json.Unmarshal(msg[1], &subID)
+
+		switch typ {
+		case "EVENT":
+			var ev nostr.Event
+			if err := json.Unmarshal(msg[1], &ev); err != nil {
+				send("NOTICE", "invalid event")
+				continue
+			}
+			if r.requireAuth && authed == "" {
+				send("OK", ev.ID, false, "auth-required: publishing needs authentication")
+				continue
+			}
+			r.mu.Lock()
+			r.pubs++
+			n := r.pubs - r.dropEvents
+			r.mu.Unlock()
+			if n <= 0 {
+				return
+			}
+			if n <= len(r.rejections) {
+				send("OK", ev.ID, false, r.rejections[n-1])
+				continue
+			}
+			r.add(&ev)
+			send("OK", ev.ID, true, "")
+
+		case "REQ":
+			var filters []nostr.Filter
+			for _, raw := range msg[2:] {
+				var f nostr.Filter
+				if err := json.Unmarshal(raw, &f); err == nil {
+					filters = append(filters, f)
+				}
+			}
+			r.mu.Lock()
+			r.filters = append(r.filters, filters...)
+			r.reqs++
+			stalled := r.stallAfter > 0 && r.reqs > r.stallAfter
+			r.mu.Unlock()
+			if stalled {
+				continue
+			}
+			if r.requireAuth && authed == "" {
+				send("CLOSED", subID, "auth-required: reading needs authentication")
+				continue
+			}
+			for _, f := range filters {
+				for _, ev := range r.limited(f) {
+					send("EVENT", subID, ev)
+				}
+			}
+			send("EOSE", subID)
+			if prev := subs[subID]; prev != nil {
+				r.unsubscribe(prev)
+			}
+			subs[subID] = &testSub{filters: filters, send: func(ev *nostr.Event) { send("EVENT", subID, ev) }}
+			r.subscribe(subs[subID])
+
+		case "CLOSE":
+			if sub := subs[subID]; sub != nil {
+				r.unsubscribe(sub)
+				delete(subs, subID)
+			}
+
+		case "AUTH":
+			var ev nostr.Event
+			if err := json.Unmarshal(msg[1], &ev); err != nil {
+				continue
+			}
+			tag := ev.Tags.Find("challenge")
+			ok, _ := ev.CheckSignature()
+			if ok && ev.Kind == nostr.KindClientAuthentication && tag != nil && tag[1] == challenge {
+				authed = ev.PubKey
+				r.mu.Lock()
+				r.authed = append(r.authed, ev.PubKey)
+				r.mu.Unlock()
+				send("OK", ev.ID, true, "")
+			} else {
+				send("OK", ev.ID, false, "invalid: bad auth event")
+			}
+
+		case "NEG-OPEN":
+			r.mu.Lock()
+			r.negOpen++
+			r.mu.Unlock()
+			if !r.negentropy {
+				send("NOTICE", "ERROR: unknown message type NEG-OPEN")
+				continue
+			}
+			if r.requireAuth && authed == "" {
+				send("NEG-ERR", subID, "auth-required: reading needs authentication")
+				continue
+			}
+			var (
+				filter  nostr.Filter
+				initial string
+			)
+			if len(msg) < 4 || json.Unmarshal(msg[2], &filter) != nil || json.Unmarshal(msg[3], &initial) != nil {
+				send("NEG-ERR", subID, "invalid NEG-OPEN")
+				continue
+			}
+			vec := vector.New()
+			for _, ev := range r.matching(filter) {
+				vec.Insert(ev.CreatedAt, ev.ID)
+			}
+			vec.Seal()
+			negs[subID] = negentropy.New(vec, negentropyFrameSize)
+			fallthrough
+
+		case "NEG-MSG":
+			neg := negs[subID]
+			var in string
+			if neg == nil || json.Unmarshal(msg[len(msg)-1], &in) != nil {
+				send("NEG-ERR", subID, "closed: unknown subscription")
+				continue
+			}
+			out, err := neg.Reconcile(in)
+			if err != nil {
+				send("NEG-ERR", subID, "error: "+err.Error())
+				continue
+			}
+			send("NEG-MSG", subID, out)
+
+		case "NEG-CLOSE":
+			delete(negs, subID)
+		}
+	}
+}
+
+// withTimeout returns a context which is canceled at the end of the test.
+func withTimeout(t *testing.T) context.Context {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
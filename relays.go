@@ -0,0 +1,253 @@
+package clip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	// Maximum number of relays taken from a single node announcement.
+	MaxRelayHintsPerNode = 10
+
+	// Maximum number of announced relays queried in addition to the configured ones.
+	// Relays announced by more nodes are preferred.
+	MaxRelayHints = 50
+
+	// Maximum number of authors in a single filter of a relay hint query.
+	maxAuthorsPerFilter = 250
+)
+
+// rankRelayHints returns the relays announced by the nodes which aren't part of urls,
+// with the nostr pubkeys announcing them. The relays announced by more pubkeys come
+// first, and at most MaxRelayHints are returned.
+func (c *Client) rankRelayHints(pubkeys map[string]struct{},
+	urls []string) ([]string, map[string]map[string]struct{}) {
+
+	queried := make(map[string]struct{}, len(urls))
+	for _, u := range urls {
+		queried[nostr.NormalizeURL(u)] = struct{}{}
+	}
+
+	authors := make(map[string]map[string]struct{})
+	for _, ann := range c.store.GetEvents(KindNodeAnnouncement, pubkeys) {
+		for _, relay := range c.countedRelayHints(ann) {
+			if _, ok := queried[relay]; ok {
+				continue
+			}
+			if authors[relay] == nil {
+				authors[relay] = make(map[string]struct{})
+			}
+			authors[relay][ann.NostrEvent.PubKey] = struct{}{}
+		}
+	}
+
+	relays := make([]string, 0, len(authors))
+	for relay := range authors {
+		relays = append(relays, relay)
+	}
+	relays = c.health.usable(relays)
+	sort.Slice(relays, func(i, j int) bool {
+		if len(authors[relays[i]]) != len(authors[relays[j]]) {
+			return len(authors[relays[i]]) > len(authors[relays[j]])
+		}
+		return relays[i] < relays[j]
+	})
+	if len(relays) > MaxRelayHints {
+		relays = relays[:MaxRelayHints]
+	}
+	return relays, authors
+}
+
+// countedRelayHints returns the relay hints of a stored announcement if it is the
+// bound announcement of its node and its npub is within the ingest limits, e.g. it
+// hasn't been loaded from the store after the npub has used up its quota. Other
+// announcements don't count towards the rank of a relay.
+func (c *Client) countedRelayHints(ann *Event) []string {
+	id, err := ann.GetIdentifier()
+	if err != nil {
+		return nil
+	}
+	state, ok := c.store.GetAnnouncementState(id.PubKey)
+	if !ok || state.PubKey != ann.NostrEvent.PubKey {
+		return nil
+	}
+	if !c.ingest.within(ann.NostrEvent.PubKey, id) {
+		return nil
+	}
+	return ann.RelayHints()
+}
+
+// relayHintTags returns the 'r' tags announcing the given relays.
+func relayHintTags(urls []string) nostr.Tags {
+	var tags nostr.Tags
+	seen := make(map[string]struct{})
+	for _, u := range urls {
+		if !isRelayURL(u) {
+			continue
+		}
+		nm := nostr.NormalizeURL(u)
+		if _, ok := seen[nm]; ok {
+			continue
+		}
+		seen[nm] = struct{}{}
+		tags = append(tags, nostr.Tag{"r", nm})
+	}
+	return tags
+}
+
+// RelayHints returns the relays announced in the 'r' tags of the event. Invalid
+// URLs are skipped, and at most MaxRelayHintsPerNode relays are returned.
+func (e *Event) RelayHints() []string {
+	var hints []string
+	seen := make(map[string]struct{})
+	for tag := range e.NostrEvent.Tags.FindAll("r") {
+		if len(hints) >= MaxRelayHintsPerNode {
+			break
+		}
+		if !isRelayURL(tag[1]) {
+			continue
+		}
+		nm := nostr.NormalizeURL(tag[1])
+		if _, ok := seen[nm]; ok {
+			continue
+		}
+		seen[nm] = struct{}{}
+		hints = append(hints, nm)
+	}
+	return hints
+}
+
+// isRelayURL reports whether s is a websocket URL of a remote relay. Relays found in
+// events of others mustn't be local, since local addresses are connected to without
+// the proxy.
+func isRelayURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "wss" || u.Scheme == "ws") && u.Host != "" && !isLocal(u.Host)
+}
+
+// syncRelayHints fetches the events of the given kind from the relays announced by
+// the nodes. Only the events of the nostr pubkeys bound to the nodes are requested
+// from their relays. The queries are batched by relay, and relays which are already
+// part of urls are skipped.
+func (c *Client) syncRelayHints(ctx context.Context, kind Kind, pubkeys map[string]struct{},
+	urls []string, since *nostr.Timestamp) (error, []error) {
+
+	relays, authors := c.rankRelayHints(pubkeys, urls)
+
+	var dfs []nostr.DirectedFilter
+	for _, relay := range relays {
+		list := make([]string, 0, len(authors[relay]))
+		for author := range authors[relay] {
+			list = append(list, author)
+		}
+		sort.Strings(list)
+
+		for start := 0; start < len(list); start += maxAuthorsPerFilter {
+			end := min(start+maxAuthorsPerFilter, len(list))
+			dfs = append(dfs, nostr.DirectedFilter{
+				Relay: relay,
+				Filter: nostr.Filter{
+					Kinds:   []int{KindLightningInformation},
+					Authors: list[start:end],
+					Since:   since,
+					Tags:    nostr.TagMap{"k": {strconv.Itoa(int(kind))}},
+				},
+			})
+		}
+	}
+	if len(dfs) == 0 {
+		return nil, nil
+	}
+
+	events := make(map[string][]*nostr.Event)
+	for ie := range c.pool.BatchedSubManyEose(ctx, dfs) {
+		events[ie.Relay.URL] = append(events[ie.Relay.URL], ie.Event)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err(), nil
+	}
+	errs, _ := c.processLatest(events)
+	return nil, errs
+}
+
+// fetchFromRelay fetches the stored events matching the filter from a single relay.
+// Unlike the pool queries, it returns an error if the relay didn't answer with EOSE.
+// The result is recorded in the health of the relay.
+func (c *Client) fetchFromRelay(ctx context.Context, relayURL string,
+	filter nostr.Filter) ([]*nostr.Event, error) {
+
+	start := time.Now()
+	events, err := c.subscribeUntilEOSE(ctx, relayURL, filter)
+	if err != nil {
+		c.health.failure(relayURL, err)
+	} else {
+		c.health.success(relayURL, time.Since(start))
+	}
+	return events, err
+}
+
+func (c *Client) subscribeUntilEOSE(ctx context.Context, relayURL string,
+	filter nostr.Filter) ([]*nostr.Event, error) {
+
+	relay, err := c.pool.EnsureRelay(relayURL)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := subscribeOnce(ctx, relay, filter)
+	var closed *closedError
+	if errors.As(err, &closed) && isAuthRequired(closed.reason) {
+		// Subscribing again once after authenticating, like the pool does.
+		if err := c.authenticate(ctx, relay, closed.reason); err != nil {
+			return nil, err
+		}
+		events, err = subscribeOnce(ctx, relay, filter)
+	}
+	return events, err
+}
+
+// closedError is returned if the relay has closed the subscription before EOSE.
+type closedError struct {
+	reason string
+}
+
+func (e *closedError) Error() string {
+	return "closed by relay: " + e.reason
+}
+
+func subscribeOnce(ctx context.Context, relay *nostr.Relay,
+	filter nostr.Filter) ([]*nostr.Event, error) {
+
+	sub, err := relay.Subscribe(ctx, nostr.Filters{filter})
+	if err != nil {
+		return nil, fmt.Errorf("subscribing: %w", err)
+	}
+	defer sub.Unsub()
+
+	var events []*nostr.Event
+	for {
+		select {
+		case <-ctx.Done():
+			return events, ctx.Err()
+		case <-sub.EndOfStoredEvents:
+			return events, nil
+		case reason := <-sub.ClosedReason:
+			return events, &closedError{reason: reason}
+		case ev, more := <-sub.Events:
+			if !more {
+				return events, errors.New("subscription ended before EOSE")
+			}
+			events = append(events, ev)
+		}
+	}
+}
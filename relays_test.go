@@ -0,0 +1,69 @@
+package clip
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// newTestAnnouncement returns an announcement of the node with the 'r' tags.
+func newTestAnnouncement(t *testing.T, npub testNpub, node *testNode, createdAt nostr.Timestamp,
+	relays ...string) *nostr.Event {
+
+	t.Helper()
+	ev := &Event{NostrEvent: &nostr.Event{PubKey: npub.pk, CreatedAt: createdAt, Content: "{}"}}
+	for _, r := range relays {
+		ev.NostrEvent.Tags = append(ev.NostrEvent.Tags, nostr.Tag{"r", r})
+	}
+	if err := ev.Finalize("mainnet", node.pubKey(), KindNodeAnnouncement, nil); err != nil {
+		t.Fatal(err)
+	}
+	signer := &CombinedSigner{NostrSigner: npub.signer(t), LnSigner: node}
+	if err := signer.SignEvent(context.Background(), ev); err != nil {
+		t.Fatal(err)
+	}
+	return ev.NostrEvent
+}
+
+// The relay hints are ranked by the number of npubs announcing them. Local relays,
+// configured relays and announcements beyond the ingest limits don't count.
+func TestRankRelayHints(t *testing.T) {
+	c := newTestClient(t, newTestNpub(t), newTestNode(t),
+		WithIngestLimits(IngestLimits{MaxEventsPerNpub: 1}))
+	created := nostr.Now() - 3600
+	a, b, other := newTestNpub(t), newTestNpub(t), newTestNpub(t)
+
+	for _, ev := range []*nostr.Event{
+		newTestAnnouncement(t, a, newTestNode(t), created,
+			"wss://popular.example.com", "wss://single.example.com", "ws://127.0.0.1:7777",
+			"wss://configured.example.com"),
+		newTestAnnouncement(t, b, newTestNode(t), created, "wss://popular.example.com"),
+		newTestAnnouncement(t, other, newTestNode(t), created, "wss://popular.example.com"),
+	} {
+		if err := c.processEvent(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Another node of an npub which has used up its quota, e.g. loaded from the
+	// store, doesn't count.
+	beyond, err := NewEventFromNostrRelay(newTestAnnouncement(t, other, newTestNode(t), created,
+		"wss://beyond.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.store.StoreEvent(beyond); err != nil {
+		t.Fatal(err)
+	}
+
+	relays, authors := c.rankRelayHints(nil, []string{"wss://configured.example.com"})
+	want := []string{"wss://popular.example.com", "wss://single.example.com"}
+	if !slices.Equal(relays, want) {
+		t.Fatalf("expected %v, got %v", want, relays)
+	}
+	if n := len(authors["wss://popular.example.com"]); n != 3 {
+		t.Fatalf("expected 3 npubs announcing the popular relay, got %d", n)
+	}
+}
@@ -0,0 +1,108 @@
+package clip
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Kinds written to a snapshot. Channel requests and responses are private and not
+// exported.
+var snapshotKinds = []Kind{KindNodeAnnouncement, KindNodeInfo}
+
+// ExportSnapshot writes the accepted events of the store to w, one raw signed nostr
+// event per line. It returns the number of exported events.
+func (c *Client) ExportSnapshot(w io.Writer) (int, error) {
+	var events []*Event
+	for _, kind := range snapshotKinds {
+		events = append(events, c.store.GetEvents(kind, nil)...)
+	}
+
+	// Sorting to get the same file for the same store. The announcements come first,
+	// so that the node info of the bound npubs is accepted when importing in order.
+	sort.Slice(events, func(i, j int) bool {
+		a, b := events[i].NostrEvent, events[j].NostrEvent
+		if ai, bi := isAnnouncement(a), isAnnouncement(b); ai != bi {
+			return ai
+		}
+		if a.CreatedAt != b.CreatedAt {
+			return a.CreatedAt < b.CreatedAt
+		}
+		return a.ID < b.ID
+	})
+
+	bw := bufio.NewWriter(w)
+	for _, ev := range events {
+		b, err := json.Marshal(ev.NostrEvent)
+		if err != nil {
+			return 0, fmt.Errorf("marshaling event %s: %w", ev.NostrEvent.ID, err)
+		}
+		bw.Write(b)
+		bw.WriteByte('\n')
+	}
+	if err := bw.Flush(); err != nil {
+		return 0, fmt.Errorf("writing snapshot: %w", err)
+	}
+	return len(events), nil
+}
+
+// ImportSnapshot reads a snapshot written by ExportSnapshot and stores its events.
+// Every event is verified and stored like an event received from a relay, so that a
+// snapshot can't add events which wouldn't be accepted from a relay. It can be used
+// instead of syncing with relays, the events are then returned by GetLocalEvents.
+// The node announcements are stored first, whatever the order of the lines.
+// Like GetEvents, it returns the number of imported events, an error if reading
+// fails, and the rejected events in fetchErrors.
+func (c *Client) ImportSnapshot(r io.Reader) (int, error, []error) {
+	type snapshotLine struct {
+		line int
+		ev   *nostr.Event
+	}
+	var (
+		lines       []snapshotLine
+		fetchErrors []error
+	)
+
+	// Lines may contain events up to the maximum content size.
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 2*MaxContentSize)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var ev nostr.Event
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			fetchErrors = append(fetchErrors, fmt.Errorf("line %d: parsing event: %v", line, err))
+			continue
+		}
+		lines = append(lines, snapshotLine{line: line, ev: &ev})
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		return isAnnouncement(lines[i].ev) && !isAnnouncement(lines[j].ev)
+	})
+	var imported int
+	for _, l := range lines {
+		if err := c.processEvent(l.ev); err != nil {
+			fetchErrors = append(fetchErrors, fmt.Errorf("line %d: %v", l.line, err))
+			continue
+		}
+		imported++
+	}
+	if err := scanner.Err(); err != nil {
+		return imported, fmt.Errorf("reading snapshot: %w", err), fetchErrors
+	}
+	return imported, nil, fetchErrors
+}
+
+// isAnnouncement reports whether the 'k' tag of the event is the one of a node
+// announcement.
+func isAnnouncement(ev *nostr.Event) bool {
+	k := ev.Tags.Find("k")
+	return len(k) >= 2 && k[1] == strconv.Itoa(int(KindNodeAnnouncement))
+}
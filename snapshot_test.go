@@ -0,0 +1,33 @@
+package clip
+
+import (
+	"bytes"
+	"testing"
+)
+
+// A node info written before a newer announcement of a new npub has to be imported
+// without a rejection.
+func TestSnapshotAnnouncementsFirst(t *testing.T) {
+	node, old, renewed := newTestNode(t), newTestNpub(t), newTestNpub(t)
+	src := newTestClient(t, old, node)
+	_ = src.processEvent(newTestEvent(t, old, node, KindNodeAnnouncement, 100))
+	_ = src.processEvent(newTestEvent(t, renewed, node, KindNodeInfo, 110))
+	_ = src.processEvent(newTestEvent(t, renewed, node, KindNodeAnnouncement, 120))
+
+	var buf bytes.Buffer
+	if n, err := src.ExportSnapshot(&buf); err != nil || n != 2 {
+		t.Fatalf("expected 2 exported events, got %d: %v", n, err)
+	}
+
+	// Reversed, so that the node info comes first also in old snapshots.
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	reversed := bytes.Join([][]byte{lines[1], lines[0]}, []byte("\n"))
+
+	for name, snapshot := range map[string][]byte{"export": buf.Bytes(), "reversed": reversed} {
+		dst := newTestClient(t, old, node)
+		n, err, errs := dst.ImportSnapshot(bytes.NewReader(snapshot))
+		if err != nil || n != 2 || len(errs) > 0 {
+			t.Errorf("%s: expected 2 imported events, got %d: %v %v", name, n, err, errs)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package clip
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// EventState is the state of one of the events of our node on a relay.
+type EventState string
+
+const (
+	// The relay has the latest event
+	EventCurrent EventState = "current"
+
+	// The relay has an older event of the same npub
+	EventStale EventState = "stale"
+
+	// The relay has no event of the npub bound to our node
+	EventMissing EventState = "missing"
+
+	// The relay has a valid event of another npub, which isn't older than the
+	// event of the bound npub on the relay
+	EventConflictingNpub EventState = "conflicting_npub"
+)
+
+// RelayEventState is the state of an event on a relay.
+type RelayEventState struct {
+	Kind  Kind       `json:"kind"`
+	TagD  string     `json:"d"`
+	State EventState `json:"state"`
+
+	// The event found on the relay, empty if missing
+	EventID   string          `json:"event_id,omitempty"`
+	CreatedAt nostr.Timestamp `json:"created_at,omitempty"`
+	Npub      string          `json:"npub,omitempty"`
+}
+
+// RelayOwnStatus is the state of the events of our node on a relay.
+type RelayOwnStatus struct {
+	URL    string            `json:"url"`
+	Error  string            `json:"error,omitempty"`
+	Events []RelayEventState `json:"events,omitempty"`
+}
+
+// OwnStatus is the state of the events of our node on every relay.
+type OwnStatus struct {
+	// Latest accepted events of our node, the reference for the relays
+	Latest []*nostr.Event   `json:"latest"`
+	Relays []RelayOwnStatus `json:"relays"`
+}
+
+// Outdated returns the relays which don't have the latest event, per event ID.
+// Relays which couldn't be queried aren't included.
+func (s *OwnStatus) Outdated() map[string][]string {
+	outdated := make(map[string][]string)
+	for _, r := range s.Relays {
+		if r.Error != "" {
+			continue
+		}
+		for i, st := range r.Events {
+			if st.State != EventCurrent {
+				outdated[s.Latest[i].ID] = append(outdated[s.Latest[i].ID], r.URL)
+			}
+		}
+	}
+	return outdated
+}
+
+var errNoOwnAnnouncement = errors.New("no node announcement of our node found")
+
+// CheckOwnEvents queries every relay separately for the node announcement and the
+// node info of our node, and compares the events with the latest accepted ones. The
+// events received are verified and stored before, so that a newer event on any
+// relay becomes the reference. Rejected events are returned in fetchErrors.
+func (c *Client) CheckOwnEvents(ctx context.Context, urls []string) (*OwnStatus, error, []error) {
+	tags := c.ownTagsD()
+	filter := nostr.Filter{
+		Kinds: []int{KindLightningInformation},
+		Tags:  nostr.TagMap{"d": tags},
+	}
+
+	type result struct {
+		relay  string
+		events []*nostr.Event
+		err    error
+	}
+	results := make([]result, len(urls))
+	var wg sync.WaitGroup
+	for i, u := range urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			relay := nostr.NormalizeURL(u)
+			events, err := c.fetchFromRelay(ctx, relay, filter)
+			results[i] = result{relay: relay, events: events, err: err}
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err(), nil
+	}
+
+	// Every distinct event is processed, also the older ones, to know which are valid.
+	var fetchErrors []error
+	valid := make(map[string]bool)
+	for _, r := range results {
+		for _, ev := range r.events {
+			if _, ok := valid[ev.ID]; ok {
+				continue
+			}
+			err := c.processEvent(ev)
+			if err != nil {
+				fetchErrors = append(fetchErrors, err)
+			}
+			valid[ev.ID] = err == nil || !isInvalid(err)
+		}
+	}
+
+	latest, err := c.LatestOwnEvents()
+	if err != nil {
+		return nil, err, fetchErrors
+	}
+	status := &OwnStatus{Latest: latest}
+
+	for _, r := range results {
+		rs := RelayOwnStatus{URL: r.relay}
+		if r.err != nil {
+			rs.Error = r.err.Error()
+			status.Relays = append(status.Relays, rs)
+			continue
+		}
+		for _, latest := range status.Latest {
+			var found []*nostr.Event
+			for _, ev := range r.events {
+				if valid[ev.ID] && ev.Tags.GetD() == latest.Tags.GetD() {
+					found = append(found, ev)
+				}
+			}
+			rs.Events = append(rs.Events, eventState(latest, found))
+		}
+		status.Relays = append(status.Relays, rs)
+	}
+	sort.Slice(status.Relays, func(i, j int) bool { return status.Relays[i].URL < status.Relays[j].URL })
+	return status, nil, fetchErrors
+}
+
+// eventState compares the valid events of a relay with the latest event of the
+// same 'd' tag.
+func eventState(latest *nostr.Event, found []*nostr.Event) RelayEventState {
+	id, _ := (&Event{NostrEvent: latest}).GetIdentifier()
+	st := RelayEventState{Kind: id.Kind, TagD: id.TagD, State: EventMissing}
+
+	var own, other *nostr.Event
+	for _, ev := range found {
+		switch {
+		case ev.PubKey != latest.PubKey:
+			if other == nil || Replaces(ev, other) {
+				other = ev
+			}
+		case own == nil || Replaces(ev, own):
+			own = ev
+		}
+	}
+
+	shown := own
+	switch {
+	case other != nil && (own == nil || other.CreatedAt >= own.CreatedAt):
+		st.State, shown = EventConflictingNpub, other
+	case own == nil:
+		return st
+	case own.ID == latest.ID:
+		st.State = EventCurrent
+	default:
+		st.State = EventStale
+	}
+	st.EventID, st.CreatedAt, st.Npub = shown.ID, shown.CreatedAt, shown.PubKey
+	return st
+}
+
+// ownTagsD returns the 'd' tags of the node announcement and the node info of our
+// node.
+func (c *Client) ownTagsD() []string {
+	pk := map[string]struct{}{c.info.PubKey: {}}
+	return append([]string{c.info.PubKey}, tagsD(KindNodeInfo, pk, c.info.Network)...)
+}
+
+// LatestOwnEvents returns the latest accepted node announcement of our node and the
+// node info, if there is one, from the local store. It returns an error if there is
+// no announcement.
+func (c *Client) LatestOwnEvents() ([]*nostr.Event, error) {
+	var latest []*nostr.Event
+	for _, tagD := range c.ownTagsD() {
+		if ev := c.acceptedByTagD(tagD); ev != nil {
+			latest = append(latest, ev)
+		}
+	}
+	if len(latest) == 0 || latest[0].Tags.GetD() != c.info.PubKey {
+		return nil, errNoOwnAnnouncement
+	}
+	return latest, nil
+}
+
+// acceptedByTagD returns the accepted event of our node with the 'd' tag.
+func (c *Client) acceptedByTagD(tagD string) *nostr.Event {
+	kind := KindNodeInfo
+	if tagD == c.info.PubKey {
+		kind = KindNodeAnnouncement
+	}
+	for _, ev := range c.store.GetEvents(kind, map[string]struct{}{c.info.PubKey: {}}) {
+		if ev.NostrEvent.Tags.GetD() == tagD {
+			return ev.NostrEvent
+		}
+	}
+	return nil
+}
+
+// Republish publishes an already signed event again, e.g. to relays which have lost
+// it. The event isn't changed, so its signatures stay valid.
+func (c *Client) Republish(ctx context.Context, ev *nostr.Event, urls []string) PublishResult {
+	return c.publishEvent(ctx, ev, urls)
+}
+
+// Heal republishes the latest events to the relays of the status which don't have
+// them. It returns a result for every republished event.
+func (c *Client) Heal(ctx context.Context, status *OwnStatus) []PublishResult {
+	outdated := status.Outdated()
+
+	var results []PublishResult
+	for _, ev := range status.Latest {
+		urls := outdated[ev.ID]
+		if len(urls) == 0 {
+			continue
+		}
+		results = append(results, c.Republish(ctx, ev, urls))
+	}
+	return results
+}
@@ -0,0 +1,95 @@
+package clip
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// checkOwnEvents returns the states of the events per relay URL.
+func checkOwnEvents(t *testing.T, c *Client, urls []string) (*OwnStatus, map[string][]EventState) {
+	t.Helper()
+	status, err, _ := c.CheckOwnEvents(withTimeout(t), urls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := make(map[string][]EventState)
+	for _, r := range status.Relays {
+		for _, st := range r.Events {
+			states[r.URL] = append(states[r.URL], st.State)
+		}
+	}
+	return status, states
+}
+
+func TestCheckOwnEventsAndHeal(t *testing.T) {
+	node, npub, other := newTestNode(t), newTestNpub(t), newTestNpub(t)
+	c := newTestClient(t, npub, node)
+	created := nostr.Now() - 100
+
+	ann := newTestEvent(t, npub, node, KindNodeAnnouncement, created)
+	info := newTestEventWithContent(t, npub, node, KindNodeInfo, created+50, `{"about":"new"}`)
+	oldInfo := newTestEventWithContent(t, npub, node, KindNodeInfo, created, `{"about":"old"}`)
+	otherInfo := newTestEventWithContent(t, other, node, KindNodeInfo, created+40, `{"about":"other"}`)
+
+	current, stale, missing, conflicting := newTestRelay(t), newTestRelay(t), newTestRelay(t), newTestRelay(t)
+	current.add(ann, info)
+	stale.add(ann, oldInfo)
+	missing.add(ann)
+	conflicting.add(ann, otherInfo)
+	down := "ws://127.0.0.1:1"
+
+	relays := []*testRelay{current, stale, missing, conflicting}
+	var urls []string
+	for _, r := range relays {
+		urls = append(urls, r.url)
+	}
+
+	status, states := checkOwnEvents(t, c, append(urls, down))
+	if len(status.Latest) != 2 || status.Latest[0].ID != ann.ID || status.Latest[1].ID != info.ID {
+		t.Fatalf("expected the announcement and the newest node info as reference, got %v", status.Latest)
+	}
+	for _, tt := range []struct {
+		relay *testRelay
+		want  []EventState
+	}{
+		{current, []EventState{EventCurrent, EventCurrent}},
+		{stale, []EventState{EventCurrent, EventStale}},
+		{missing, []EventState{EventCurrent, EventMissing}},
+		{conflicting, []EventState{EventCurrent, EventConflictingNpub}},
+	} {
+		if got := states[nostr.NormalizeURL(tt.relay.url)]; !slices.Equal(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.relay.url, tt.want, got)
+		}
+	}
+	if i := slices.IndexFunc(status.Relays, func(r RelayOwnStatus) bool { return r.URL == down }); i < 0 ||
+		status.Relays[i].Error == "" || len(status.Relays[i].Events) != 0 {
+		t.Fatalf("expected an error for the unreachable relay, got %+v", status.Relays)
+	}
+
+	// Only the node info is republished, and only to the relays without it.
+	results := c.Heal(withTimeout(t), status)
+	if len(results) != 1 || results[0].Event.ID != info.ID {
+		t.Fatalf("expected the node info to be republished, got %d results", len(results))
+	}
+	report := results[0].Wait()
+	if report.SuccessfulRelays != 3 || len(report.Status) != 3 {
+		t.Fatalf("expected 3 relays, got %+v", report.Status)
+	}
+	if current.published() != 0 {
+		t.Fatal("republished to a relay with the latest events")
+	}
+	for _, r := range []*testRelay{stale, missing, conflicting} {
+		if r.published() != 1 {
+			t.Fatalf("%s: expected 1 republished event, got %d", r.url, r.published())
+		}
+	}
+
+	_, states = checkOwnEvents(t, c, urls)
+	for _, u := range urls {
+		if got := states[nostr.NormalizeURL(u)]; !slices.Equal(got, []EventState{EventCurrent, EventCurrent}) {
+			t.Errorf("%s: expected all events current after healing, got %v", u, got)
+		}
+	}
+}
@@ -1,100 +1,361 @@
 package clip
 
 import (
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/nbd-wtf/go-nostr"
 )
 
-type announcementState struct {
-	createdAt nostr.Timestamp
-	pub       string
+// ErrStaleEvent is returned by StoreEvent. It is wrapped with more details.
+var ErrStaleEvent = errors.New("stale event")
+
+// StoreResult is the outcome of storing an event which hasn't been rejected.
+type StoreResult int
+
+const (
+	// The event has been stored.
+	StoreResultStored StoreResult = iota
+
+	// The same event is already stored.
+	StoreResultDuplicate
+
+	// The event replaced a stored event with the same created_at and a higher ID.
+	StoreResultTieWon
+
+	// A stored event with the same created_at and a lower ID has been kept.
+	StoreResultTieLost
+)
+
+func (r StoreResult) String() string {
+	switch r {
+	case StoreResultStored:
+		return "stored"
+	case StoreResultDuplicate:
+		return "duplicate"
+	case StoreResultTieWon:
+		return "tie_won"
+	case StoreResultTieLost:
+		return "tie_lost"
+	default:
+		return "unknown"
+	}
+}
+
+// Replaces reports whether ev replaces cur by the rule of NIP-01 for replaceable
+// events: the newer event wins, and of events with the same created_at the one with
+// the lowest ID.
+func Replaces(ev, cur *nostr.Event) bool {
+	if ev.CreatedAt != cur.CreatedAt {
+		return ev.CreatedAt > cur.CreatedAt
+	}
+	return ev.ID < cur.ID
+}
+
+// Store stores verified events and applies the binding rules of the node
+// announcements. Implementations have to pass the conformance suite in package
+// storetest.
+type Store interface {
+	// StoreEvent stores a verified event. It returns an error if a newer event of
+	// the author with the same address is already stored. Events with the same
+	// created_at are resolved by Replaces and reported by the result. The accepted
+	// events must not depend on the order in which the events are stored.
+	StoreEvent(ev *Event) (StoreResult, error)
+
+	// GetEvents returns the accepted events of a kind. If pubKeys is not empty, only
+	// the events of these nodes are returned.
+	GetEvents(kind Kind, pubKeys map[string]struct{}) []*Event
+
+	// GetAnnouncementState returns the state of the last accepted announcement
+	// of a node.
+	GetAnnouncementState(pubKey string) (AnnouncementState, bool)
+}
+
+// SyncPoint describes the time range of a filter which has been synced with a relay.
+type SyncPoint struct {
+	From  nostr.Timestamp
+	Until nostr.Timestamp
 }
 
+// SyncState stores the sync points of filters per relay, so that only newer events
+// need to be fetched.
+type SyncState interface {
+	GetSyncPoint(relay string, key string) (SyncPoint, bool)
+	SetSyncPoint(relay string, key string, sp SyncPoint) error
+}
+
+// AnnouncementState binds a node to the nostr pubkey of its last announcement.
+type AnnouncementState struct {
+	CreatedAt nostr.Timestamp `json:"created_at"`
+	PubKey    string          `json:"pub_key"`
+}
+
+// nodeState keeps the candidate events of a node. The accepted events are always
+// derived from all candidates, so that the result doesn't depend on the order in
+// which the events arrive.
 type nodeState struct {
-	mu               sync.RWMutex
-	lastAnnouncement announcementState
+	mu sync.RWMutex
+
+	// Latest announcement per nostr pubkey
+	announcements map[string]*Event
+
+	// Latest event per nostr pubkey and 'd' tag
+	events map[string]map[string]*Event
+
+	// Derived from the announcements, empty pubkey if the node has none
+	lastAnnouncement AnnouncementState
+
+	// Last time an event of the node has been received, also a known one
+	lastRefresh nostr.Timestamp
 
-	// map with 'd' tag as key
-	events map[string]*Event
+	// Number and size of the candidates
+	count int
+	size  int64
+
+	// Set when the node is removed from the store
+	evicted bool
 }
 
 func newNodeState() *nodeState {
 	return &nodeState{
-		events: make(map[string]*Event),
+		announcements: make(map[string]*Event),
+		events:        make(map[string]map[string]*Event),
 	}
 }
 
+// MapStore is an in-memory Store. It keeps the latest announcement and the latest
+// events of every nostr pubkey as candidates. The latest announcement of a node
+// binds it to a nostr pubkey, and only the candidates of this pubkey are accepted.
+// Events of other pubkeys become accepted if their pubkey gets bound later.
+//
+// The memory can be bounded with WithStoreLimits, see Evict for the policy.
 type MapStore struct {
 	mu sync.RWMutex
 	// map with node pubkey as key
 	records map[string]*nodeState
+
+	limits StoreLimits
+	pinned map[string]struct{}
+
+	// Total number and size of the candidates
+	events atomic.Int64
+	bytes  atomic.Int64
+
+	stats evictionStats
+
+	// Notified of the changes of the candidates, if set
+	journal storeJournal
 }
 
-func NewMapStore() *MapStore {
-	return &MapStore{
+// storeJournal is notified of the changes of the candidates while the lock of the node
+// is held, so that a persistent store can write them in the same order.
+type storeJournal interface {
+	// The event has become a candidate.
+	stored(ev *Event)
+
+	// The event is only kept in the history, because it has been replaced or is
+	// stale.
+	superseded(ev *Event)
+
+	// The candidate has been dropped because of MaxEventsPerNode.
+	removed(ev *Event)
+
+	// The node has been evicted with its candidates.
+	evicted(pubKey string, events []*Event)
+
+	// An event of the node has been received at t.
+	seen(pubKey string, t nostr.Timestamp)
+}
+
+type MapStoreOption func(*MapStore)
+
+func NewMapStore(opts ...MapStoreOption) *MapStore {
+	s := &MapStore{
 		records: make(map[string]*nodeState),
+		pinned:  make(map[string]struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
-func (s *MapStore) StoreEvent(ev *Event) error {
+func (s *MapStore) StoreEvent(ev *Event) (StoreResult, error) {
 	id, err := ev.GetIdentifier()
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	res, err := s.storeInNode(id, ev)
+	if err == nil && (res == StoreResultStored || res == StoreResultTieWon) && s.overLimits() {
+		s.evict(time.Now(), evictTarget)
+	}
+	return res, err
+}
+
+func (s *MapStore) storeInNode(id *Identifier, ev *Event) (StoreResult, error) {
+	for {
+		ns := s.getNodeState(id.PubKey)
+
+		ns.mu.Lock()
+		// The node has been evicted after getting it, trying again with a new one.
+		if ns.evicted {
+			ns.mu.Unlock()
+			continue
+		}
+		res, err := s.storeCandidate(ns, id, ev)
+		ns.mu.Unlock()
+		return res, err
+	}
+}
+
+// storeCandidate stores the event in the node. The caller has to hold the lock.
+func (s *MapStore) storeCandidate(ns *nodeState, id *Identifier, ev *Event) (StoreResult, error) {
+	npub := ev.NostrEvent.PubKey
+	cur := ns.candidate(npub, id)
+
+	received := ev.received
+	if received == 0 {
+		received = nostr.Now()
 	}
 
-	ns := s.getNodeState(id.PubKey)
+	// The same event may be received from several relays, and is sent again by nodes
+	// which are still online.
+	if cur != nil && cur.NostrEvent.ID == ev.NostrEvent.ID {
+		s.refresh(ns, id.PubKey, received)
+		return StoreResultDuplicate, nil
+	}
 
-	ns.mu.Lock()
-	defer ns.mu.Unlock()
+	res := StoreResultStored
+	if cur != nil {
+		tie := cur.NostrEvent.CreatedAt == ev.NostrEvent.CreatedAt
+		switch {
+		case tie && Replaces(ev.NostrEvent, cur.NostrEvent):
+			res = StoreResultTieWon
+		case tie:
+			s.superseded(ev)
+			s.refresh(ns, id.PubKey, received)
+			return StoreResultTieLost, nil
+		case cur.NostrEvent.CreatedAt > ev.NostrEvent.CreatedAt:
+			s.superseded(ev)
+			return 0, fmt.Errorf("%w: existing event is newer: %d > %d",
+				ErrStaleEvent, cur.NostrEvent.CreatedAt, ev.NostrEvent.CreatedAt)
+		}
+	}
+
+	if cur != nil {
+		s.account(ns, -1, -eventSize(cur))
+		s.superseded(cur)
+	}
+	s.account(ns, 1, eventSize(ev))
+	if s.journal != nil {
+		s.journal.stored(ev)
+	}
+	s.refresh(ns, id.PubKey, received)
 
 	if ev.kind == KindNodeAnnouncement {
-		return s.storeAnnouncement(ns, ev, id)
+		ns.announcements[npub] = ev
+		ns.bind()
+	} else {
+		if ns.events[npub] == nil {
+			ns.events[npub] = make(map[string]*Event)
+		}
+		ns.events[npub][id.TagD] = ev
 	}
 
-	return s.storeRegularEvent(ns, ev, id)
+	if limit := s.limits.MaxEventsPerNode; limit > 0 {
+		for ns.count > limit && s.dropCandidate(ns) {
+		}
+	}
+	return res, nil
 }
 
-func (s *MapStore) storeAnnouncement(ns *nodeState, ev *Event, id *Identifier) error {
-	// Skip if existing announcement is newer or same
-	if ns.lastAnnouncement.createdAt >= ev.NostrEvent.CreatedAt {
-		return fmt.Errorf("existing announcement is newer or same: %d >= %d",
-			ns.lastAnnouncement.createdAt, ev.NostrEvent.CreatedAt)
-	}
+// account changes the number and size of the candidates of the node and the store.
+func (s *MapStore) account(ns *nodeState, count int, size int64) {
+	ns.count += count
+	ns.size += size
+	s.events.Add(int64(count))
+	s.bytes.Add(size)
+}
 
-	// Purge old events if pubkey changed (potential nsec compromise)
-	if ns.lastAnnouncement.pub != ev.NostrEvent.PubKey {
-		ns.events = make(map[string]*Event)
+// refresh sets the last time an event of the node has been received. The caller has
+// to hold the lock of the node.
+func (s *MapStore) refresh(ns *nodeState, pubKey string, t nostr.Timestamp) {
+	if t <= ns.lastRefresh {
+		return
+	}
+	ns.lastRefresh = t
+	if s.journal != nil {
+		s.journal.seen(pubKey, t)
 	}
+}
 
-	// Store the new announcement
-	ns.events[id.TagD] = ev
-	ns.lastAnnouncement = announcementState{
-		createdAt: ev.NostrEvent.CreatedAt,
-		pub:       ev.NostrEvent.PubKey,
+func (s *MapStore) superseded(ev *Event) {
+	if s.journal != nil {
+		s.journal.superseded(ev)
 	}
+}
 
-	return nil
+// candidate returns the stored candidate with the same address as the event.
+func (ns *nodeState) candidate(npub string, id *Identifier) *Event {
+	if id.Kind == KindNodeAnnouncement {
+		return ns.announcements[npub]
+	}
+	return ns.events[npub][id.TagD]
 }
 
-func (s *MapStore) storeRegularEvent(ns *nodeState, ev *Event, id *Identifier) error {
-	// Only accept events matching the last announcement pubkey
-	if ns.lastAnnouncement.pub != ev.NostrEvent.PubKey {
-		return fmt.Errorf("event pubkey %s does not match last announcement pubkey %s",
-			ev.NostrEvent.PubKey, ns.lastAnnouncement.pub)
+// candidates returns all candidates of the node. The caller has to hold the lock.
+func (ns *nodeState) candidates() []*Event {
+	events := make([]*Event, 0, ns.count)
+	for _, ev := range ns.announcements {
+		events = append(events, ev)
 	}
+	for _, byTagD := range ns.events {
+		for _, ev := range byTagD {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
 
-	// Skip if existing record is newer or same
-	if lastRecord, exists := ns.events[id.TagD]; exists {
-		if lastRecord.NostrEvent.CreatedAt >= ev.NostrEvent.CreatedAt {
-			return fmt.Errorf("existing record is newer or same: %d >= %d",
-				lastRecord.NostrEvent.CreatedAt, ev.NostrEvent.CreatedAt)
+// bind derives the announcement state from the latest announcement of all pubkeys.
+// Events of the other pubkeys are kept, but not returned until their pubkey is bound.
+func (ns *nodeState) bind() {
+	var best *Event
+	for _, ann := range ns.announcements {
+		if best == nil || Replaces(ann.NostrEvent, best.NostrEvent) {
+			best = ann
 		}
 	}
+	if best == nil {
+		ns.lastAnnouncement = AnnouncementState{}
+		return
+	}
+	ns.lastAnnouncement = AnnouncementState{
+		CreatedAt: best.NostrEvent.CreatedAt,
+		PubKey:    best.NostrEvent.PubKey,
+	}
+}
 
-	ns.events[id.TagD] = ev
-	return nil
+// accepted returns the accepted events of the node. The caller has to hold the lock.
+func (ns *nodeState) accepted(kind Kind) []*Event {
+	npub := ns.lastAnnouncement.PubKey
+	if npub == "" {
+		return nil
+	}
+	if kind == KindNodeAnnouncement {
+		return []*Event{ns.announcements[npub]}
+	}
+	var events []*Event
+	for _, ev := range ns.events[npub] {
+		if ev.kind == kind {
+			events = append(events, ev)
+		}
+	}
+	return events
 }
 
 func (s *MapStore) getNodeState(pubkey string) *nodeState {
@@ -141,17 +402,51 @@ func (s *MapStore) GetEvents(kind Kind, pubKeys map[string]struct{}) []*Event {
 
 	for _, ns := range nodes {
 		ns.mu.RLock()
-		for _, ev := range ns.events {
-			if ev.kind != kind {
-				continue
+		events = append(events, ns.accepted(kind)...)
+		ns.mu.RUnlock()
+	}
+	return events
+}
+
+// GetRawEvents returns the nostr events of all candidates matching the filter, also
+// the ones of npubs which aren't bound.
+func (s *MapStore) GetRawEvents(filter nostr.Filter) []*nostr.Event {
+	s.mu.RLock()
+	nodes := make([]*nodeState, 0, len(s.records))
+	for _, ns := range s.records {
+		nodes = append(nodes, ns)
+	}
+	s.mu.RUnlock()
+
+	var events []*nostr.Event
+	for _, ns := range nodes {
+		ns.mu.RLock()
+		for _, ev := range ns.candidates() {
+			if filter.Matches(ev.NostrEvent) {
+				events = append(events, ev.NostrEvent)
 			}
-			events = append(events, ev)
 		}
 		ns.mu.RUnlock()
 	}
 	return events
 }
 
+func (s *MapStore) GetAnnouncementState(pubKey string) (AnnouncementState, bool) {
+	s.mu.RLock()
+	ns, exists := s.records[pubKey]
+	s.mu.RUnlock()
+	if !exists {
+		return AnnouncementState{}, false
+	}
+
+	ns.mu.RLock()
+	defer ns.mu.RUnlock()
+	if ns.lastAnnouncement.PubKey == "" {
+		return AnnouncementState{}, false
+	}
+	return ns.lastAnnouncement, true
+}
+
 // newInFilter returns a filter function that checks if an item is in the provided set.
 // If the set is empty, all items are considered to be in the set.
 func newInFilter[T comparable](set map[T]struct{}) func(T) bool {
@@ -163,3 +458,5 @@ func newInFilter[T comparable](set map[T]struct{}) func(T) bool {
 		return exists
 	}
 }
+
+var _ Store = (*MapStore)(nil)
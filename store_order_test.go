@@ -0,0 +1,79 @@
+package clip
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"sort"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// The accepted events of a MapStore have to be the same for every arrival order.
+// Random sets of announcements and node info of competing npubs, also with equal
+// timestamps, are stored in random orders.
+func TestMapStoreOrderIndependent(t *testing.T) {
+	const (
+		sets   = 30
+		orders = 20
+	)
+	nodes := []*testNode{newTestNode(t), newTestNode(t)}
+	npubs := []testNpub{newTestNpub(t), newTestNpub(t), newTestNpub(t)}
+
+	rnd := rand.New(rand.NewSource(1))
+	for i := range sets {
+		var events []*nostr.Event
+		for range 4 + rnd.Intn(8) {
+			npub := npubs[rnd.Intn(len(npubs))]
+			node := nodes[rnd.Intn(len(nodes))]
+			createdAt := nostr.Timestamp(1700000000 + rnd.Intn(5))
+
+			kind := KindNodeInfo
+			if rnd.Intn(2) == 0 {
+				kind = KindNodeAnnouncement
+			}
+			var opts []string
+			if kind == KindNodeInfo && rnd.Intn(3) == 0 {
+				opts = []string{"variant"}
+			}
+			events = append(events, newTestEvent(t, npub, node, kind, createdAt, opts...))
+		}
+
+		want := acceptedState(t, events, nodes)
+		for range orders {
+			rnd.Shuffle(len(events), func(i, j int) { events[i], events[j] = events[j], events[i] })
+			if got := acceptedState(t, events, nodes); got != want {
+				t.Fatalf("set %d: result depends on the order\nwant %s\ngot  %s", i, want, got)
+			}
+		}
+	}
+}
+
+// acceptedState stores the events in order in a new MapStore and describes the bound
+// npubs and the accepted events.
+func acceptedState(t *testing.T, events []*nostr.Event, nodes []*testNode) string {
+	t.Helper()
+	s := NewMapStore()
+	for _, ev := range events {
+		lev, err := NewEventFromNostrRelay(ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = s.StoreEvent(lev)
+	}
+
+	var state []string
+	for _, node := range nodes {
+		as, _ := s.GetAnnouncementState(node.pubKey())
+		state = append(state, fmt.Sprintf("%s bound to %s at %d", node.pubKey()[:8], as.PubKey, as.CreatedAt))
+	}
+	var ids []string
+	for _, kind := range []Kind{KindNodeAnnouncement, KindNodeInfo} {
+		for _, ev := range s.GetEvents(kind, nil) {
+			ids = append(ids, ev.NostrEvent.ID)
+		}
+	}
+	sort.Strings(ids)
+	return fmt.Sprint(slices.Concat(state, ids))
+}
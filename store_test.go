@@ -0,0 +1,14 @@
+package clip_test
+
+import (
+	"testing"
+
+	"github.com/feelancer21/clip"
+	"github.com/feelancer21/clip/storetest"
+)
+
+func TestMapStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) clip.Store {
+		return clip.NewMapStore()
+	})
+}
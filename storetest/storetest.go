@@ -0,0 +1,49 @@
+// Package storetest implements a conformance suite for implementations of clip.Store.
+// It runs the store vectors of package vectors, which cover the binding rules: events
+// of a pubkey are only accepted while it is bound to the node, stale events are
+// rejected, and the result doesn't depend on the order in which events arrive.
+//
+// Usage in a test of an implementation:
+//
+//	func TestStore(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) clip.Store {
+//			return NewMyStore()
+//		})
+//	}
+package storetest
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/feelancer21/clip"
+	"github.com/feelancer21/clip/vectors"
+)
+
+// Run runs all store vectors as subtests, in the given order and in random orders.
+// newStore is called for every run and has to return an empty store.
+func Run(t *testing.T, newStore func(t *testing.T) clip.Store) {
+	t.Helper()
+
+	set, err := vectors.Load()
+	if err != nil {
+		t.Fatalf("loading vectors: %v", err)
+	}
+
+	for i, v := range set.Store {
+		t.Run(v.Name, func(t *testing.T) {
+			if err := vectors.RunStoreVector(v, newStore(t)); err != nil {
+				t.Error(err)
+			}
+
+			rnd := rand.New(rand.NewSource(int64(i)))
+			newStoreErr := func() (clip.Store, error) {
+				return newStore(t), nil
+			}
+			err := vectors.RunStoreVectorShuffled(v, newStoreErr, rnd, vectors.ShuffleRounds)
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package clip
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// ChangeType is the type of a change of the accepted events of a node.
+type ChangeType string
+
+const (
+	// First accepted announcement of a node
+	ChangeNewNode ChangeType = "new_node"
+
+	// Newer announcement with the same npub
+	ChangeAnnouncementUpdated ChangeType = "announcement_updated"
+
+	// Newer announcement binding the node to another npub
+	ChangeKeyChanged ChangeType = "key_changed"
+
+	// New or updated node info
+	ChangeInfoUpdated ChangeType = "info_updated"
+
+	// Event which hasn't been accepted
+	ChangeRejected ChangeType = "rejected"
+)
+
+// Change is emitted by Subscribe for every event changing the accepted events of a
+// node, and for every rejected event.
+type Change struct {
+	Type   ChangeType `json:"type"`
+	PubKey string     `json:"pub_key"`
+
+	// Hex encoded nostr pubkey of the author, and of the previously bound one if the
+	// key has changed
+	Npub         string `json:"npub"`
+	PreviousNpub string `json:"previous_npub,omitempty"`
+
+	Event    *nostr.Event   `json:"event,omitempty"`
+	Rejected *RejectedEvent `json:"rejected,omitempty"`
+}
+
+// Subscribe keeps subscriptions for node announcements and node info open on the
+// relays until ctx is done. Incoming events are verified and stored, also the ones
+// not matching the query, and the changes matching the query are sent to the
+// returned channel. The channel is closed when ctx is done.
+func (c *Client) Subscribe(ctx context.Context, q Query, urls []string) <-chan Change {
+	now := nostr.Now()
+	filter := nostr.Filter{
+		Kinds: []int{KindLightningInformation},
+		Since: &now,
+		Tags: nostr.TagMap{"k": {
+			strconv.Itoa(int(KindNodeAnnouncement)),
+			strconv.Itoa(int(KindNodeInfo)),
+		}},
+	}
+
+	changes := make(chan Change)
+	go func() {
+		defer close(changes)
+		for ie := range c.pool.SubscribeMany(ctx, urls, filter) {
+			change, ok := c.processChange(ie.Event, q)
+			if !ok {
+				continue
+			}
+			select {
+			case changes <- change:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return changes
+}
+
+// processChange processes the event and returns the change of the accepted events.
+// Events are processed one by one, so that the state before and after can be compared.
+// Every event is stored, and the query is applied to the change: it matches if the
+// event or the accepted event it replaces matches, so that e.g. a key change away
+// from a watched npub or node info which no longer matches is reported.
+func (c *Client) processChange(ev *nostr.Event, q Query) (Change, bool) {
+	lev, err := NewEventFromNostrRelay(ev)
+
+	var (
+		before, after AnnouncementState
+		prev          *Event
+		id            *Identifier
+	)
+	if err == nil {
+		id, _ = lev.GetIdentifier()
+		before, _ = c.store.GetAnnouncementState(id.PubKey)
+		prev = c.acceptedEvent(id)
+	}
+	matches := func() bool {
+		// Events with a malformed 'd' tag can't be matched by node.
+		if err != nil {
+			return len(q.PubKeys) == 0
+		}
+		return q.Match(lev) || (prev != nil && q.Match(prev))
+	}
+
+	change := Change{Npub: ev.PubKey, Event: ev}
+	if err := c.processEvent(ev); err != nil {
+		var rej *RejectedEvent
+		if !errors.As(err, &rej) || rej.Reason == ReasonStale || rej.Reason == ReasonTieLost || !matches() {
+			return Change{}, false
+		}
+		change.Type, change.PubKey, change.Rejected = ChangeRejected, rej.PubKey, rej
+		return change, true
+	}
+	if !matches() {
+		return Change{}, false
+	}
+
+	change.PubKey = id.PubKey
+	after, _ = c.store.GetAnnouncementState(id.PubKey)
+	switch {
+	case id.Kind != KindNodeAnnouncement:
+		if cur := c.acceptedEvent(id); cur == nil || (prev != nil && cur.NostrEvent.ID == prev.NostrEvent.ID) {
+			return Change{}, false
+		}
+		change.Type = ChangeInfoUpdated
+	case before.PubKey == "":
+		change.Type = ChangeNewNode
+	case before.PubKey != after.PubKey:
+		change.Type, change.PreviousNpub = ChangeKeyChanged, before.PubKey
+	case before.CreatedAt != after.CreatedAt:
+		change.Type = ChangeAnnouncementUpdated
+	default:
+		return Change{}, false
+	}
+	return change, true
+}
+
+// acceptedEvent returns the accepted event with the same address, or nil.
+func (c *Client) acceptedEvent(id *Identifier) *Event {
+	for _, ev := range c.store.GetEvents(id.Kind, map[string]struct{}{id.PubKey: {}}) {
+		if evID, err := ev.GetIdentifier(); err == nil && evID.TagD == id.TagD {
+			return ev
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package clip
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// nextChange returns the next change of the subscription, or nil if there is none
+// within a short time.
+func nextChange(t *testing.T, changes <-chan Change) *Change {
+	t.Helper()
+	select {
+	case change, ok := <-changes:
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return &change
+	case <-time.After(500 * time.Millisecond):
+		return nil
+	}
+}
+
+// waitFor waits until cond is true.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+	}
+}
+
+// newSubscribeTest returns a client subscribed to a relay with the query, once the
+// subscription is open, so that the events arrive live.
+func newSubscribeTest(t *testing.T, q Query) (*Client, *testRelay, <-chan Change) {
+	t.Helper()
+	c := newTestClient(t, newTestNpub(t), newTestNode(t))
+	relay := newTestRelay(t)
+	changes := c.Subscribe(withTimeout(t), q, []string{relay.url})
+	waitFor(t, func() bool { return len(relay.requested()) > 0 })
+	return c, relay, changes
+}
+
+// Node info not matching the query is stored as well, and its change is reported if
+// the previous node info matched.
+func TestSubscribeInfoLeavingQuery(t *testing.T) {
+	c, relay, changes := newSubscribeTest(t, Query{About: "lightning"})
+	node, npub := newTestNode(t), newTestNpub(t)
+
+	// go-nostr doesn't keep the order of the events, so the node info is sent after
+	// the announcement has been stored. Announcements don't match the payload filter.
+	now := nostr.Now()
+	relay.add(newTestEvent(t, npub, node, KindNodeAnnouncement, now))
+	waitFor(t, func() bool {
+		_, ok := c.store.GetAnnouncementState(node.pubKey())
+		return ok
+	})
+	relay.add(newTestEventWithContent(t, npub, node, KindNodeInfo, now, `{"about":"lightning node"}`))
+	if change := nextChange(t, changes); change == nil || change.Type != ChangeInfoUpdated {
+		t.Fatalf("expected the matching node info, got %+v", change)
+	}
+
+	relay.add(newTestEventWithContent(t, npub, node, KindNodeInfo, now+1, `{"about":"routing"}`))
+	if change := nextChange(t, changes); change == nil || change.Type != ChangeInfoUpdated {
+		t.Fatalf("expected the update of the matching node info, got %+v", change)
+	}
+	infos := c.GetLocalEvents(KindNodeInfo, map[string]struct{}{node.pubKey(): {}})
+	if len(infos) != 1 || infos[0].NostrEvent.CreatedAt != now+1 {
+		t.Fatal("node info not matching the query not stored")
+	}
+
+	relay.add(newTestEventWithContent(t, npub, node, KindNodeInfo, now+2, `{"about":"still routing"}`))
+	if change := nextChange(t, changes); change != nil {
+		t.Fatalf("expected no change, got %+v", change)
+	}
+}
+
+// A key change away from a watched npub is reported, and announcements of other
+// npubs are stored without a change.
+func TestSubscribeKeyChange(t *testing.T) {
+	watched, other := newTestNpub(t), newTestNpub(t)
+	c, relay, changes := newSubscribeTest(t, Query{Npubs: map[string]struct{}{watched.pk: {}}})
+	node := newTestNode(t)
+
+	now := nostr.Now()
+	relay.add(newTestEvent(t, watched, node, KindNodeAnnouncement, now))
+	if change := nextChange(t, changes); change == nil || change.Type != ChangeNewNode {
+		t.Fatalf("expected the new node, got %+v", change)
+	}
+
+	relay.add(newTestEvent(t, other, node, KindNodeAnnouncement, now+1))
+	change := nextChange(t, changes)
+	if change == nil || change.Type != ChangeKeyChanged || change.PreviousNpub != watched.pk {
+		t.Fatalf("expected the key change, got %+v", change)
+	}
+	if state, _ := c.store.GetAnnouncementState(node.pubKey()); state.PubKey != other.pk {
+		t.Fatalf("expected the node bound to the new npub, got %q", state.PubKey)
+	}
+
+	unrelated := newTestNode(t)
+	relay.add(newTestEvent(t, other, unrelated, KindNodeAnnouncement, now))
+	if change := nextChange(t, changes); change != nil {
+		t.Fatalf("expected no change, got %+v", change)
+	}
+	if _, ok := c.store.GetAnnouncementState(unrelated.pubKey()); !ok {
+		t.Fatal("announcement not matching the query not stored")
+	}
+}
@@ -0,0 +1,91 @@
+// Command gen generates the CLIP conformance vectors. With -check it verifies that
+// the vectors file is up to date and that all vectors pass.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/feelancer21/clip"
+	"github.com/feelancer21/clip/vectors"
+)
+
+func main() {
+	out := flag.String("out", "vectors.json", "name of the vectors file.")
+	check := flag.Bool("check", false, "check the vectors file instead of writing it.")
+	flag.Parse()
+
+	if err := run(*out, *check); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(out string, check bool) error {
+	set, err := vectors.Generate()
+	if err != nil {
+		return fmt.Errorf("generating vectors: %w", err)
+	}
+
+	b, err := json.MarshalIndent(set, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	if !check {
+		return os.WriteFile(out, b, 0o644)
+	}
+
+	existing, err := os.ReadFile(out)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(existing, b) {
+		return fmt.Errorf("%s is outdated, run go generate", out)
+	}
+
+	var loaded vectors.Set
+	if err := json.Unmarshal(existing, &loaded); err != nil {
+		return err
+	}
+	errs := vectors.Run(&loaded)
+
+	// Every store implementation of this repo has to pass the store vectors.
+	dir, err := os.MkdirTemp("", "clip-vectors")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	var stores []*clip.BoltStore
+	defer func() {
+		for _, s := range stores {
+			s.Close()
+		}
+	}()
+	newBoltStore := func() (clip.Store, error) {
+		s, err := clip.OpenBoltStore(filepath.Join(dir, fmt.Sprintf("%d.db", len(stores))))
+		if err != nil {
+			return nil, err
+		}
+		stores = append(stores, s)
+		return s, nil
+	}
+	for _, err := range vectors.RunStore(&loaded, newBoltStore) {
+		errs = append(errs, fmt.Errorf("bolt store: %w", err))
+	}
+
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d vectors failed", len(errs))
+	}
+	fmt.Printf("%d verify and %d store vectors passed\n", len(loaded.Verify), len(loaded.Store))
+	return nil
+}
@@ -0,0 +1,476 @@
+package vectors
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/feelancer21/clip"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/tv42/zbase32"
+)
+
+const (
+	// Base timestamp of all vectors
+	baseTime = 1700000000
+
+	// Timestamp far in the future (2100-01-01)
+	futureTime = 4102444800
+
+	network = "mainnet"
+)
+
+var (
+	// Prefix used by lnd.
+	signedMsgPrefix = []byte("Lightning Signed Message:")
+
+	nodeInfoContent = `{"about":"CLIP test vector node","min_channel_size_sat":100000}`
+)
+
+// lnKeySigner signs messages like lnd's SignMessage.
+type lnKeySigner struct {
+	key *btcec.PrivateKey
+}
+
+func (s lnKeySigner) SignMessage(_ context.Context, msg []byte) (string, error) {
+	digest := chainhash.DoubleHashB(append(signedMsgPrefix, msg...))
+	sig := ecdsa.SignCompact(s.key, digest, true)
+	return zbase32.EncodeToString(sig), nil
+}
+
+// nostrKeySigner signs nostr events with a private key.
+type nostrKeySigner struct {
+	sk string
+	pk string
+}
+
+func (s nostrKeySigner) GetPublicKey(context.Context) (string, error) {
+	return s.pk, nil
+}
+
+func (s nostrKeySigner) SignEvent(_ context.Context, ev *nostr.Event) error {
+	return ev.Sign(s.sk)
+}
+
+type generator struct {
+	set   *Set
+	ln    map[string]lnKeySigner
+	nostr map[string]nostrKeySigner
+}
+
+// Generate creates the vectors deterministically from fixed keys.
+func Generate() (*Set, error) {
+	g := &generator{
+		set: &Set{
+			Version: Version,
+			Description: "CLIP protocol conformance vectors. Verify vectors are checked " +
+				"one by one with Event.Verify. Store vectors are stored in order in an " +
+				"empty MapStore. The accepted events and the bound npubs have to be the " +
+				"same for every order of the steps.",
+		},
+		ln:    make(map[string]lnKeySigner),
+		nostr: make(map[string]nostrKeySigner),
+	}
+
+	for _, name := range []string{"node_a", "node_b"} {
+		g.addLnKey(name)
+	}
+	for _, name := range []string{"npub_1", "npub_2", "npub_attacker"} {
+		if err := g.addNostrKey(name); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := g.verifyVectors(); err != nil {
+		return nil, err
+	}
+	if err := g.storeVectors(); err != nil {
+		return nil, err
+	}
+	return g.set, nil
+}
+
+func seed(name string) []byte {
+	h := sha256.Sum256([]byte("clip-vectors/" + name))
+	return h[:]
+}
+
+func (g *generator) addLnKey(name string) {
+	key, pub := btcec.PrivKeyFromBytes(seed(name))
+	g.ln[name] = lnKeySigner{key: key}
+	g.set.Keys = append(g.set.Keys, Key{
+		Name:       name,
+		Type:       "lightning",
+		PrivateKey: hex.EncodeToString(key.Serialize()),
+		PublicKey:  hex.EncodeToString(pub.SerializeCompressed()),
+	})
+}
+
+func (g *generator) addNostrKey(name string) error {
+	sk := hex.EncodeToString(seed(name))
+	pk, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		return err
+	}
+	g.nostr[name] = nostrKeySigner{sk: sk, pk: pk}
+	g.set.Keys = append(g.set.Keys, Key{
+		Name:       name,
+		Type:       "nostr",
+		PrivateKey: sk,
+		PublicKey:  pk,
+	})
+	return nil
+}
+
+func (g *generator) lnPub(name string) string {
+	return hex.EncodeToString(g.ln[name].key.PubKey().SerializeCompressed())
+}
+
+// spec describes an event to create.
+type spec struct {
+	npub      string
+	node      string
+	kind      clip.Kind
+	createdAt int64
+	network   string
+	opts      []string
+	tags      nostr.Tags
+
+	// Nodes whose keys create the Lightning signatures of an announcement.
+	// Defaults to node if nil.
+	lnSigners []string
+
+	// Applied after finalizing and before signing
+	beforeSign func(ev *clip.Event) error
+	// Applied after signing
+	afterSign func(ev *clip.Event)
+}
+
+func (g *generator) event(s spec) (*clip.Event, error) {
+	content := nodeInfoContent
+	if s.kind == clip.KindNodeAnnouncement {
+		content = "{}"
+	}
+	if s.network == "" {
+		s.network = network
+	}
+	if s.lnSigners == nil {
+		s.lnSigners = []string{s.node}
+	}
+
+	nostrSigner := g.nostr[s.npub]
+	ev := &clip.Event{NostrEvent: &nostr.Event{
+		PubKey:    nostrSigner.pk,
+		CreatedAt: nostr.Timestamp(s.createdAt),
+		Tags:      s.tags,
+		Content:   content,
+	}}
+	if err := ev.Finalize(s.network, g.lnPub(s.node), s.kind, s.opts); err != nil {
+		return nil, err
+	}
+	if s.beforeSign != nil {
+		if err := s.beforeSign(ev); err != nil {
+			return nil, err
+		}
+	}
+
+	// Signing like the CombinedSigner, but allowing any number of Lightning
+	// signatures.
+	if s.kind == clip.KindNodeAnnouncement {
+		hash := ev.Hash()
+		for _, node := range s.lnSigners {
+			sig, err := g.ln[node].SignMessage(context.Background(), hash)
+			if err != nil {
+				return nil, err
+			}
+			ev.NostrEvent.Tags = append(ev.NostrEvent.Tags, nostr.Tag{"sig", sig})
+		}
+	}
+	if err := nostrSigner.SignEvent(context.Background(), ev.NostrEvent); err != nil {
+		return nil, err
+	}
+	if s.afterSign != nil {
+		s.afterSign(ev)
+	}
+	return ev, nil
+}
+
+func (g *generator) addVerify(name, description string, s spec) error {
+	ev, err := g.event(s)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", name, err)
+	}
+
+	v := VerifyVector{
+		Name:        name,
+		Description: description,
+		Event:       ev.NostrEvent,
+		Hash:        string(ev.Hash()),
+	}
+	if id, err := ev.GetIdentifier(); err == nil {
+		v.TagD, v.TagK = id.TagD, fmt.Sprint(int(id.Kind))
+	}
+	v.Valid, v.Reason = verify(ev.NostrEvent)
+
+	g.set.Verify = append(g.set.Verify, v)
+	return nil
+}
+
+// withoutTag returns the tags without the ones with the key.
+func withoutTag(tags nostr.Tags, key string) nostr.Tags {
+	var out nostr.Tags
+	for _, tag := range tags {
+		if tag[0] != key {
+			out = append(out, tag)
+		}
+	}
+	return out
+}
+
+func (g *generator) verifyVectors() error {
+	vectors := []struct {
+		name        string
+		description string
+		spec        spec
+	}{
+		{
+			name:        "valid_announcement",
+			description: "Node announcement signed by the Lightning node and the nostr key.",
+			spec:        spec{npub: "npub_1", node: "node_a", kind: clip.KindNodeAnnouncement, createdAt: baseTime},
+		},
+		{
+			name:        "valid_announcement_relay_hints",
+			description: "Node announcement with relay hints in 'r' tags, which are covered by the Lightning signature.",
+			spec: spec{npub: "npub_1", node: "node_a", kind: clip.KindNodeAnnouncement, createdAt: baseTime,
+				tags: nostr.Tags{{"r", "wss://relay.example.com"}, {"r", "wss://nos.example.org"}}},
+		},
+		{
+			name:        "valid_node_info",
+			description: "Node info without Lightning signature.",
+			spec:        spec{npub: "npub_1", node: "node_a", kind: clip.KindNodeInfo, createdAt: baseTime},
+		},
+		{
+			name:        "valid_node_info_opts",
+			description: "Node info with additional options in the 'd' tag.",
+			spec: spec{npub: "npub_1", node: "node_a", kind: clip.KindNodeInfo, createdAt: baseTime,
+				opts: []string{"en"}},
+		},
+		{
+			name:        "node_info_without_n_tag",
+			description: "Node info of an older version without 'n' tag, the network is only in the 'd' tag.",
+			spec: spec{npub: "npub_1", node: "node_a", kind: clip.KindNodeInfo, createdAt: baseTime,
+				beforeSign: func(ev *clip.Event) error {
+					ev.NostrEvent.Tags = withoutTag(ev.NostrEvent.Tags, "n")
+					return nil
+				}},
+		},
+		{
+			name:        "node_info_n_tag_mismatch",
+			description: "Node info whose 'n' tag names another network than the 'd' tag.",
+			spec: spec{npub: "npub_1", node: "node_a", kind: clip.KindNodeInfo, createdAt: baseTime,
+				beforeSign: func(ev *clip.Event) error {
+					ev.NostrEvent.Tags.Find("n")[1] = "testnet"
+					return nil
+				}},
+		},
+		{
+			name:        "announcement_wrong_ln_key",
+			description: "Announcement for node_a signed by the Lightning key of node_b.",
+			spec: spec{npub: "npub_1", node: "node_a", kind: clip.KindNodeAnnouncement, createdAt: baseTime,
+				lnSigners: []string{"node_b"}},
+		},
+		{
+			name:        "announcement_missing_ln_sig",
+			description: "Announcement without 'sig' tag.",
+			spec: spec{npub: "npub_1", node: "node_a", kind: clip.KindNodeAnnouncement, createdAt: baseTime,
+				lnSigners: []string{}},
+		},
+		{
+			name:        "announcement_two_ln_sigs",
+			description: "Announcement with two 'sig' tags.",
+			spec: spec{npub: "npub_1", node: "node_a", kind: clip.KindNodeAnnouncement, createdAt: baseTime,
+				lnSigners: []string{"node_a", "node_a"}},
+		},
+		{
+			name:        "node_info_id_mismatch",
+			description: "Node info whose content was changed after signing.",
+			spec: spec{npub: "npub_1", node: "node_a", kind: clip.KindNodeInfo, createdAt: baseTime,
+				afterSign: func(ev *clip.Event) {
+					ev.NostrEvent.Content = `{"about":"changed"}`
+				}},
+		},
+		{
+			name:        "node_info_bad_nostr_sig",
+			description: "Node info with a correct ID but a nostr signature of another key.",
+			spec: spec{npub: "npub_1", node: "node_a", kind: clip.KindNodeInfo, createdAt: baseTime,
+				afterSign: func(ev *clip.Event) {
+					ev.NostrEvent.PubKey = g.nostr["npub_2"].pk
+					ev.NostrEvent.ID = ev.NostrEvent.GetID()
+				}},
+		},
+		{
+			name:        "node_info_future",
+			description: "Node info with a created_at far in the future.",
+			spec:        spec{npub: "npub_1", node: "node_a", kind: clip.KindNodeInfo, createdAt: futureTime},
+		},
+		{
+			name:        "node_info_invalid_network",
+			description: "Node info with an unknown network in the 'd' tag.",
+			spec: spec{npub: "npub_1", node: "node_a", kind: clip.KindNodeInfo, createdAt: baseTime,
+				network: "bitcoin"},
+		},
+		{
+			name:        "node_info_malformed_d_tag",
+			description: "Node info with a 'd' tag without network.",
+			spec: spec{npub: "npub_1", node: "node_a", kind: clip.KindNodeInfo, createdAt: baseTime,
+				beforeSign: func(ev *clip.Event) error {
+					tagD := ev.NostrEvent.Tags.Find("d")
+					tagD[1] = fmt.Sprintf("%d:%s", clip.KindNodeInfo, g.lnPub("node_a"))
+					return nil
+				}},
+		},
+		{
+			name:        "node_info_missing_k_tag",
+			description: "Node info without 'k' tag.",
+			spec: spec{npub: "npub_1", node: "node_a", kind: clip.KindNodeInfo, createdAt: baseTime,
+				beforeSign: func(ev *clip.Event) error {
+					ev.NostrEvent.Tags = withoutTag(ev.NostrEvent.Tags, "k")
+					return nil
+				}},
+		},
+	}
+
+	for _, v := range vectors {
+		if err := g.addVerify(v.name, v.description, v.spec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *generator) addStore(name, description string, specs ...spec) error {
+	v := StoreVector{Name: name, Description: description}
+
+	store := clip.NewMapStore()
+	for _, s := range specs {
+		ev, err := g.event(s)
+		if err != nil {
+			return fmt.Errorf("creating %s: %w", name, err)
+		}
+		if ok, err := ev.Verify(); !ok || err != nil {
+			return fmt.Errorf("creating %s: invalid event: %v", name, err)
+		}
+
+		step := StoreStep{Event: ev.NostrEvent}
+		step.Result, step.Reason = storeResult(store.StoreEvent(ev))
+		v.Steps = append(v.Steps, step)
+	}
+	v.Accepted = acceptedIDs(store)
+	sort.Strings(v.Accepted)
+	v.Bound = boundPubKeys(store)
+
+	g.set.Store = append(g.set.Store, v)
+	return nil
+}
+
+func (g *generator) storeVectors() error {
+	ann := func(npub string, createdAt int64) spec {
+		return spec{npub: npub, node: "node_a", kind: clip.KindNodeAnnouncement, createdAt: createdAt}
+	}
+	info := func(npub string, createdAt int64) spec {
+		return spec{npub: npub, node: "node_a", kind: clip.KindNodeInfo, createdAt: createdAt}
+	}
+	annB := func(npub string, createdAt int64) spec {
+		return spec{npub: npub, node: "node_b", kind: clip.KindNodeAnnouncement, createdAt: createdAt}
+	}
+	infoB := func(npub string, createdAt int64) spec {
+		return spec{npub: npub, node: "node_b", kind: clip.KindNodeInfo, createdAt: createdAt}
+	}
+	// Adds a tag to get different events with the same created_at
+	tagged := func(s spec, value string) spec {
+		s.tags = nostr.Tags{{"t", value}}
+		return s
+	}
+
+	vectors := []struct {
+		name        string
+		description string
+		specs       []spec
+	}{
+		{
+			name:        "announcement_then_info",
+			description: "Node info signed by the announced npub is accepted.",
+			specs:       []spec{ann("npub_1", baseTime), info("npub_1", baseTime+1)},
+		},
+		{
+			name:        "info_before_announcement",
+			description: "Node info arriving before the announcement of its npub is accepted.",
+			specs:       []spec{info("npub_1", baseTime+1), ann("npub_1", baseTime)},
+		},
+		{
+			name:        "info_wrong_npub",
+			description: "Node info signed by an npub which is not announced is not accepted.",
+			specs:       []spec{ann("npub_1", baseTime), info("npub_attacker", baseTime+1)},
+		},
+		{
+			name:        "key_change_hides_events",
+			description: "A newer announcement with another npub hides the events of the old npub.",
+			specs: []spec{ann("npub_1", baseTime), info("npub_1", baseTime+1),
+				ann("npub_2", baseTime+2), info("npub_1", baseTime+3), info("npub_2", baseTime+4)},
+		},
+		{
+			name: "rebind_restores_events",
+			description: "The events of an npub are accepted again, if a newer announcement " +
+				"binds the node to it again.",
+			specs: []spec{ann("npub_1", baseTime), info("npub_1", baseTime+1),
+				ann("npub_attacker", baseTime+2), ann("npub_1", baseTime+3)},
+		},
+		{
+			name:        "older_announcement_not_bound",
+			description: "An announcement older than the latest one doesn't bind the node.",
+			specs:       []spec{ann("npub_2", baseTime+1), ann("npub_1", baseTime)},
+		},
+		{
+			name: "same_timestamp_announcement",
+			description: "Of two announcements with the same created_at, the one with " +
+				"the lowest ID binds the node.",
+			specs: []spec{ann("npub_1", baseTime), ann("npub_2", baseTime)},
+		},
+		{
+			name: "same_timestamp_info",
+			description: "Of two node infos with the same created_at and 'd' tag, the " +
+				"one with the lowest ID is accepted.",
+			specs: []spec{ann("npub_1", baseTime), tagged(info("npub_1", baseTime+1), "c"),
+				tagged(info("npub_1", baseTime+1), "a"), tagged(info("npub_1", baseTime+1), "b")},
+		},
+		{
+			name:        "duplicate_event",
+			description: "Storing the same event twice is not an error.",
+			specs:       []spec{ann("npub_1", baseTime), ann("npub_1", baseTime)},
+		},
+		{
+			name:        "two_nodes",
+			description: "The binding rules apply per node, one npub may be bound to several nodes.",
+			specs: []spec{ann("npub_1", baseTime), annB("npub_1", baseTime), info("npub_1", baseTime+1),
+				annB("npub_2", baseTime+2), infoB("npub_1", baseTime+3), infoB("npub_2", baseTime+4)},
+		},
+		{
+			name: "older_info_rejected",
+			description: "Node info older than the stored one of the same npub with the " +
+				"same 'd' tag is rejected.",
+			specs: []spec{ann("npub_1", baseTime), info("npub_1", baseTime+2), info("npub_1", baseTime+1)},
+		},
+	}
+
+	for _, v := range vectors {
+		if err := g.addStore(v.name, v.description, v.specs...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
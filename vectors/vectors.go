@@ -0,0 +1,345 @@
+// Package vectors contains the CLIP protocol conformance test vectors and a reference
+// verifier, which runs them against Event.Verify and MapStore.
+//
+// The vectors are generated deterministically by Generate. Other implementations can
+// use vectors.json to check that they compute the event hash, the 'd' and 'k' tags
+// and the Lightning signature in the same way and that they apply the same binding
+// rules when storing events.
+package vectors
+
+//go:generate go run ./gen -out vectors.json
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/feelancer21/clip"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Version of the vector format and the protocol rules covered by the vectors.
+// It has to be increased with every change of the rules.
+const Version = 4
+
+// Reasons why an event is rejected.
+const (
+	ReasonFutureEvent     = string(clip.ReasonFutureEvent)
+	ReasonIDMismatch      = string(clip.ReasonIDMismatch)
+	ReasonContentTooLarge = string(clip.ReasonContentTooLarge)
+	ReasonInvalidNetwork  = string(clip.ReasonInvalidNetwork)
+	ReasonInvalidTagD     = string(clip.ReasonInvalidTagD)
+	ReasonInvalidTagK     = string(clip.ReasonInvalidTagK)
+	ReasonNostrSignature  = string(clip.ReasonNostrSignature)
+	ReasonLnSignature     = string(clip.ReasonLnSignature)
+	ReasonStale           = string(clip.ReasonStale)
+	ReasonUnknown         = string(clip.ReasonUnknown)
+)
+
+// ResultRejected is the result of a store step which returned an error.
+const ResultRejected = "rejected"
+
+//go:embed vectors.json
+var vectorsJSON []byte
+
+type Set struct {
+	Version     int            `json:"version"`
+	Description string         `json:"description"`
+	Keys        []Key          `json:"keys"`
+	Verify      []VerifyVector `json:"verify"`
+	Store       []StoreVector  `json:"store"`
+}
+
+// Key is a private key used to create the vectors.
+type Key struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"` // lightning or nostr
+	PrivateKey string `json:"private_key"`
+	PublicKey  string `json:"public_key"`
+}
+
+// VerifyVector is a single event checked with Event.Verify.
+type VerifyVector struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Event       *nostr.Event `json:"event"`
+
+	// Expected hash signed by the Lightning node (Event.Hash)
+	Hash string `json:"hash"`
+	// Expected 'd' and 'k' tag, empty if the tags are malformed
+	TagD string `json:"tag_d,omitempty"`
+	TagK string `json:"tag_k,omitempty"`
+
+	Valid  bool   `json:"valid"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// StoreVector is a sequence of valid events stored in an empty store in the given order.
+type StoreVector struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Steps       []StoreStep `json:"steps"`
+
+	// Nostr IDs of all events in the store after the last step, sorted
+	Accepted []string `json:"accepted"`
+
+	// Nostr pubkey bound to each node by its last accepted announcement
+	Bound map[string]string `json:"bound"`
+}
+
+type StoreStep struct {
+	Event *nostr.Event `json:"event"`
+
+	// clip.StoreResult of the step, or ResultRejected with a reason
+	Result string `json:"result"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Load returns the vectors shipped with this package.
+func Load() (*Set, error) {
+	var set Set
+	if err := json.Unmarshal(vectorsJSON, &set); err != nil {
+		return nil, fmt.Errorf("parsing vectors: %w", err)
+	}
+	if set.Version != Version {
+		return nil, fmt.Errorf("vectors version %d, expected %d", set.Version, Version)
+	}
+	return &set, nil
+}
+
+// ShuffleRounds is the number of random orders in which the steps of a store vector
+// are stored by Run and RunStore.
+const ShuffleRounds = 20
+
+// Reason maps an error of Event.Verify or MapStore.StoreEvent to a reason.
+func Reason(err error) string {
+	return string(clip.ReasonOf(err))
+}
+
+// Run checks all vectors of the set against Event.Verify and MapStore and returns an
+// error for every mismatch.
+func Run(set *Set) []error {
+	var errs []error
+	for _, v := range set.Verify {
+		if err := runVerify(v); err != nil {
+			errs = append(errs, fmt.Errorf("verify vector %q: %w", v.Name, err))
+		}
+	}
+	newStore := func() (clip.Store, error) {
+		return clip.NewMapStore(), nil
+	}
+	return append(errs, RunStore(set, newStore)...)
+}
+
+// RunStore checks the store vectors against the store implementation, in the given
+// order and in ShuffleRounds random orders. newStore is called for every run and has
+// to return an empty store.
+func RunStore(set *Set, newStore func() (clip.Store, error)) []error {
+	var errs []error
+	for i, v := range set.Store {
+		store, err := newStore()
+		if err == nil {
+			err = RunStoreVector(v, store)
+		}
+		if err == nil {
+			rnd := rand.New(rand.NewSource(int64(i)))
+			err = RunStoreVectorShuffled(v, newStore, rnd, ShuffleRounds)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("store vector %q: %w", v.Name, err))
+		}
+	}
+	return errs
+}
+
+func runVerify(v VerifyVector) error {
+	ev := &clip.Event{NostrEvent: copyEvent(v.Event)}
+	if hash := string(ev.Hash()); hash != v.Hash {
+		return fmt.Errorf("hash %s, expected %s", hash, v.Hash)
+	}
+
+	var tagD, tagK string
+	if id, err := ev.GetIdentifier(); err == nil {
+		tagD, tagK = id.TagD, fmt.Sprint(int(id.Kind))
+	}
+	if tagD != v.TagD || tagK != v.TagK {
+		return fmt.Errorf("tags d=%q k=%q, expected d=%q k=%q", tagD, tagK, v.TagD, v.TagK)
+	}
+
+	valid, reason := verify(v.Event)
+	if valid != v.Valid || reason != v.Reason {
+		return fmt.Errorf("valid=%v reason=%q, expected valid=%v reason=%q",
+			valid, reason, v.Valid, v.Reason)
+	}
+	return nil
+}
+
+// RunStoreVector stores the events of the vector in the given empty store and checks
+// the outcome of every step, the accepted events and the announcement states.
+func RunStoreVector(v StoreVector, store clip.Store) error {
+	for i, step := range v.Steps {
+		ev, err := stepEvent(step)
+		if err != nil {
+			return fmt.Errorf("step %d: %w", i, err)
+		}
+
+		result, reason := storeResult(store.StoreEvent(ev))
+		if result != step.Result || reason != step.Reason {
+			return fmt.Errorf("step %d: result=%q reason=%q, expected result=%q reason=%q",
+				i, result, reason, step.Result, step.Reason)
+		}
+	}
+	return checkState(v, store)
+}
+
+// RunStoreVectorShuffled stores the events of the vector in random orders and checks
+// that the accepted events and the announcement states are always the same. The
+// outcome of the single steps depends on the order and isn't checked.
+func RunStoreVectorShuffled(v StoreVector, newStore func() (clip.Store, error),
+	rnd *rand.Rand, rounds int) error {
+
+	events := make([]*clip.Event, len(v.Steps))
+	for i, step := range v.Steps {
+		ev, err := stepEvent(step)
+		if err != nil {
+			return fmt.Errorf("step %d: %w", i, err)
+		}
+		events[i] = ev
+	}
+
+	for r := 0; r < rounds; r++ {
+		store, err := newStore()
+		if err != nil {
+			return err
+		}
+
+		perm := rnd.Perm(len(events))
+		for _, i := range perm {
+			_, _ = store.StoreEvent(events[i])
+		}
+		if err := checkState(v, store); err != nil {
+			return fmt.Errorf("order %v: %w", perm, err)
+		}
+	}
+	return nil
+}
+
+// storeResult returns the result and the reason of a store step.
+func storeResult(res clip.StoreResult, err error) (string, string) {
+	if err != nil {
+		return ResultRejected, Reason(err)
+	}
+	return res.String(), ""
+}
+
+func stepEvent(step StoreStep) (*clip.Event, error) {
+	ev, err := clip.NewEventFromNostrRelay(copyEvent(step.Event))
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := ev.Verify(); !ok || err != nil {
+		return nil, fmt.Errorf("invalid event: %v", err)
+	}
+	return ev, nil
+}
+
+// checkState checks the accepted events and the announcement states of the store
+// after all steps of the vector.
+func checkState(v StoreVector, store clip.Store) error {
+	accepted := acceptedIDs(store)
+	if strings.Join(accepted, ",") != strings.Join(v.Accepted, ",") {
+		return fmt.Errorf("accepted %v, expected %v", accepted, v.Accepted)
+	}
+
+	for node, npub := range v.Bound {
+		state, ok := store.GetAnnouncementState(node)
+		if !ok || state.PubKey != npub {
+			return fmt.Errorf("node %s bound to %q, expected %q", node, state.PubKey, npub)
+		}
+	}
+	bound := boundPubKeys(store)
+	if len(bound) != len(v.Bound) {
+		return fmt.Errorf("%d nodes bound, expected %d", len(bound), len(v.Bound))
+	}
+
+	return checkPubKeyFilter(store)
+}
+
+// checkPubKeyFilter checks that GetEvents returns exactly the events of a node if
+// filtered by its pubkey.
+func checkPubKeyFilter(store clip.Store) error {
+	for _, kind := range []clip.Kind{clip.KindNodeAnnouncement, clip.KindNodeInfo} {
+		perNode := make(map[string]int)
+		for _, ev := range store.GetEvents(kind, nil) {
+			id, err := ev.GetIdentifier()
+			if err != nil {
+				return err
+			}
+			perNode[id.PubKey]++
+		}
+		for node, n := range perNode {
+			events := store.GetEvents(kind, map[string]struct{}{node: {}})
+			for _, ev := range events {
+				if id, _ := ev.GetIdentifier(); id.PubKey != node {
+					return fmt.Errorf("filter by %s returned event of %s", node, id.PubKey)
+				}
+			}
+			if len(events) != n {
+				return fmt.Errorf("filter by %s returned %d events of kind %d, expected %d",
+					node, len(events), kind, n)
+			}
+		}
+	}
+	return nil
+}
+
+func verify(nev *nostr.Event) (bool, string) {
+	ev, err := clip.NewEventFromNostrRelay(copyEvent(nev))
+	if err != nil {
+		return false, Reason(err)
+	}
+	if ok, err := ev.Verify(); !ok || err != nil {
+		return false, Reason(err)
+	}
+	return true, ""
+}
+
+// boundPubKeys returns the announcement states of all nodes with an accepted
+// announcement.
+func boundPubKeys(store clip.Store) map[string]string {
+	bound := make(map[string]string)
+	for _, ev := range store.GetEvents(clip.KindNodeAnnouncement, nil) {
+		id, err := ev.GetIdentifier()
+		if err != nil {
+			continue
+		}
+		if state, ok := store.GetAnnouncementState(id.PubKey); ok {
+			bound[id.PubKey] = state.PubKey
+		}
+	}
+	return bound
+}
+
+func acceptedIDs(store clip.Store) []string {
+	ids := []string{}
+	for _, kind := range []clip.Kind{clip.KindNodeAnnouncement, clip.KindNodeInfo} {
+		for _, ev := range store.GetEvents(kind, nil) {
+			ids = append(ids, ev.NostrEvent.ID)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// copyEvent returns a deep copy, so that the vectors are never modified.
+func copyEvent(ev *nostr.Event) *nostr.Event {
+	c := *ev
+	c.Tags = make(nostr.Tags, len(ev.Tags))
+	for i, tag := range ev.Tags {
+		c.Tags[i] = append(nostr.Tag{}, tag...)
+	}
+	return &c
+}
@@ -0,0 +1,31 @@
+package vectors
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestVectors(t *testing.T) {
+	set, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range Run(set) {
+		t.Error(err)
+	}
+}
+
+// The shipped vectors have to be the generated ones, see go generate.
+func TestVectorsUpToDate(t *testing.T) {
+	set, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.MarshalIndent(set, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(append(b, '\n')) != string(vectorsJSON) {
+		t.Fatal("vectors.json is outdated, run go generate ./vectors")
+	}
+}